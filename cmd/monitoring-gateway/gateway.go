@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"net/url"
+	"os"
+	"slices"
 	"time"
 
 	extflag "github.com/efficientgo/tools/extkingpin"
@@ -16,7 +18,9 @@ import (
 	"github.com/thanos-io/thanos/pkg/extkingpin"
 	"github.com/thanos-io/thanos/pkg/extprom"
 	"github.com/thanos-io/thanos/pkg/prober"
+	grpcserver "github.com/thanos-io/thanos/pkg/server/grpc"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
+	"github.com/thanos-io/thanos/pkg/tls"
 
 	monitoringgateway "github.com/WhizardTelemetry/whizard/pkg/monitoring-gateway"
 )
@@ -28,20 +32,41 @@ type gatewayConfig struct {
 
 	debugEnabledUI bool
 
+	strictRulesTenancy bool
+
+	usageMetrics           bool
+	usageMetricsMaxTenants int
+
 	tenantsFilePath    string
 	tenantsFileContent string
 	refreshInterval    *model.Duration
 
+	limitsFilePath        string
+	limitsFileContent     string
+	limitsRefreshInterval *model.Duration
+
 	tenantHeader    string
 	tenantLabelName string
 
+	tenantResolution        []string
+	tenantResolutionHeaders []string
+
+	otlpTenantAttribute string
+
+	authConfig *extflag.PathOrContent
+
 	ExternalRemoteWrites struct {
 		ConfigPathOrContent extflag.PathOrContent
+		RefreshInterval     *model.Duration
+		QueueDir            string
 	}
 
 	queryConfig       *monitoringgateway.QueryConfig
 	rulesQueryConfig  *monitoringgateway.RulesQueryConfig
 	remoteWriteConfig *monitoringgateway.RemoteWriteConfig
+	haTrackerConfig   *monitoringgateway.HATrackerFlagConfig
+	otlpGRPCConfig    *monitoringgateway.OTLPGRPCConfig
+	tenantWatcher     *monitoringgateway.TenantWatcherFlagConfig
 }
 
 func registerGateway(app *extkingpin.App) {
@@ -51,6 +76,9 @@ func registerGateway(app *extkingpin.App) {
 		queryConfig:       &monitoringgateway.QueryConfig{},
 		rulesQueryConfig:  &monitoringgateway.RulesQueryConfig{},
 		remoteWriteConfig: &monitoringgateway.RemoteWriteConfig{},
+		haTrackerConfig:   &monitoringgateway.HATrackerFlagConfig{},
+		otlpGRPCConfig:    &monitoringgateway.OTLPGRPCConfig{},
+		tenantWatcher:     &monitoringgateway.TenantWatcherFlagConfig{},
 	}
 	conf.registerFlag(cmd)
 
@@ -60,6 +88,7 @@ func registerGateway(app *extkingpin.App) {
 			g,
 			logger,
 			reg,
+			tracer,
 			conf,
 			Gateway,
 		)
@@ -70,13 +99,16 @@ func runGateway(
 	g *run.Group,
 	logger log.Logger,
 	reg *prometheus.Registry,
+	tracer opentracing.Tracer,
 	conf *gatewayConfig,
 	comp component.Component,
 ) error {
 
 	httpProbe := prober.NewHTTP()
+	grpcProbe := prober.NewGRPC()
 	statusProber := prober.Combine(
 		httpProbe,
+		grpcProbe,
 		prober.NewInstrumentation(comp, logger, extprom.WrapRegistererWithPrefix("whizard_", reg)),
 	)
 
@@ -90,6 +122,49 @@ func runGateway(
 		TenantHeader:    conf.tenantHeader,
 		TenantLabelName: conf.tenantLabelName,
 		EnabledQueryUI:  conf.debugEnabledUI,
+
+		StrictRulesTenancy: conf.strictRulesTenancy,
+
+		EnabledUsageMetrics:    conf.usageMetrics,
+		UsageMetricsMaxTenants: conf.usageMetricsMaxTenants,
+
+		OTLPTenantAttribute: conf.otlpTenantAttribute,
+		MaxRequestSize:      conf.remoteWriteConfig.MaxRequestSize,
+
+		MaxQueryConcurrency: conf.queryConfig.MaxConcurrency,
+		QueryQueueTimeout:   time.Duration(*conf.queryConfig.QueueTimeout),
+	}
+
+	headers := conf.tenantResolutionHeaders
+	if len(headers) == 0 {
+		headers = []string{conf.tenantHeader, monitoringgateway.OrgIDHeader}
+	}
+	resolver, err := monitoringgateway.NewTenantResolver(conf.tenantResolution, headers)
+	if err != nil {
+		return errors.Wrap(err, "failed to setup tenant resolution")
+	}
+	options.TenantResolver = resolver
+
+	authContent, err := conf.authConfig.Content()
+	if err != nil {
+		return err
+	}
+	if len(authContent) == 0 && slices.Contains(conf.tenantResolution, string(monitoringgateway.TenantSourceClaim)) {
+		return errors.New("the claim tenant source requires the authenticators configured by 'auth.config'")
+	}
+	if len(authContent) == 0 && conf.otlpTenantAttribute != "" {
+		// The tenants are mapped from the resource attributes set by the clients, which are authorized by the authenticators only.
+		return errors.New("the OTLP tenant attribute requires the authenticators configured by 'auth.config'")
+	}
+	if len(authContent) > 0 {
+		authConfig, err := monitoringgateway.ParseAuthConfig(authContent)
+		if err != nil {
+			return errors.Wrap(err, "failed to validate auth configuration")
+		}
+		options.Authenticators, err = monitoringgateway.NewAuthenticators(log.With(logger, "component", "authenticator"), authConfig)
+		if err != nil {
+			return errors.Wrap(err, "failed to setup authenticators")
+		}
 	}
 
 	if conf.queryConfig.DownstreamURL != "" {
@@ -140,25 +215,105 @@ func runGateway(
 		options.RemoteWriteProxy = monitoringgateway.NewSingleHostReverseProxy(downstreamURL, downstreamTripper)
 	}
 
-	content, err := conf.ExternalRemoteWrites.ConfigPathOrContent.Content()
+	options.HATracker, err = monitoringgateway.NewHATrackerFromFlags(log.With(logger, "component", "ha-tracker"), reg, conf.haTrackerConfig)
 	if err != nil {
-		return err
+		return errors.Wrap(err, "failed to setup HA tracker")
 	}
-	rwsCfg, err := monitoringgateway.LoadExternalRemoteWriteConfig("", string(content))
-	if err != nil {
-		return err
+	if options.HATracker != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return options.HATracker.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
 	}
-	clients, err := monitoringgateway.NewExternalRemoteWriteClients(rwsCfg)
-	if err != nil {
-		return err
+
+	writer := monitoringgateway.NewExternalRemoteWriter(log.With(logger, "component", "external-remote-writer"), reg, conf.ExternalRemoteWrites.QueueDir, conf.tenantHeader)
+	applyRemoteWrites := func() error {
+		content, err := conf.ExternalRemoteWrites.ConfigPathOrContent.Content()
+		if err != nil {
+			return err
+		}
+		rwsCfg, err := monitoringgateway.LoadExternalRemoteWriteConfig("", string(content))
+		if err != nil {
+			return err
+		}
+		return writer.ApplyConfig(rwsCfg)
+	}
+	if err := applyRemoteWrites(); err != nil {
+		return errors.Wrap(err, "failed to apply external remote writes configuration")
 	}
+	writerCtx, writerCancel := context.WithCancel(context.Background())
+	g.Add(func() error {
+		return writer.Run(writerCtx)
+	}, func(error) {
+		writerCancel()
+	})
+	options.ExternalRemoteWriter = writer
+
+	// The external remote writes file is given initializing file watcher, which reloads the targets on changes.
+	if path := conf.ExternalRemoteWrites.ConfigPathOrContent.Path(); path != "" {
+		fw, err := monitoringgateway.NewFileWatcher(log.With(logger, "component", "external-remote-writes-watcher"), reg, "external_remote_writes", path, *conf.ExternalRemoteWrites.RefreshInterval, func([]byte) error {
+			return applyRemoteWrites()
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to initialize external remote writes file watcher")
+		}
 
-	options.ExternalRWClients = clients
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return fw.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
 
-	if conf.tenantsFileContent != "" || conf.tenantsFilePath != "" {
+	if conf.tenantsFileContent != "" || conf.tenantsFilePath != "" || conf.tenantWatcher.Enabled {
 		options.EnabledTenantsAdmission = true
 	}
 
+	if conf.limitsFilePath != "" || conf.limitsFileContent != "" {
+		options.Limits = monitoringgateway.NewLimits(reg)
+
+		// The limits file path is given initializing file watcher, which reloads the limits on changes.
+		if conf.limitsFilePath != "" {
+			setLimits := func(content []byte) error {
+				cfg, err := monitoringgateway.ParseLimitsConfig(content)
+				if err != nil {
+					return err
+				}
+				options.Limits.SetConfig(cfg)
+				return nil
+			}
+
+			content, err := os.ReadFile(conf.limitsFilePath)
+			if err != nil {
+				return errors.Wrap(err, "failed to read limits configuration file")
+			}
+			if err := setLimits(content); err != nil {
+				return errors.Wrap(err, "failed to validate limits configuration file")
+			}
+
+			fw, err := monitoringgateway.NewFileWatcher(log.With(logger, "component", "limits-watcher"), reg, "tenant_limits", conf.limitsFilePath, *conf.limitsRefreshInterval, setLimits)
+			if err != nil {
+				return errors.Wrap(err, "failed to initialize limits file watcher")
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			g.Add(func() error {
+				return fw.Run(ctx)
+			}, func(error) {
+				cancel()
+			})
+		} else {
+			cfg, err := monitoringgateway.ParseLimitsConfig([]byte(conf.limitsFileContent))
+			if err != nil {
+				return errors.Wrap(err, "failed to validate limits configuration content")
+			}
+			options.Limits.SetConfig(cfg)
+		}
+	}
+
 	webhandler := monitoringgateway.NewHandler(logger, reg, options)
 
 	srv.Handle("/", webhandler.Router())
@@ -175,10 +330,50 @@ func runGateway(
 		srv.Shutdown(err)
 	})
 
+	// The OTLP/gRPC receiver handles the export requests as the OTLP/HTTP requests of the handler.
+	if conf.otlpGRPCConfig.BindAddress != "" {
+		tlsCfg, err := tls.NewServerConfig(log.With(logger, "protocol", "gRPC"), conf.otlpGRPCConfig.TLSCert, conf.otlpGRPCConfig.TLSKey, conf.otlpGRPCConfig.TLSClientCA, "1.2")
+		if err != nil {
+			return errors.Wrap(err, "setup OTLP/gRPC receiver TLS config")
+		}
+
+		otlpServer := monitoringgateway.NewOTLPGRPCServer(webhandler)
+		grpcSrv := grpcserver.New(logger, reg, tracer, nil, nil, comp, grpcProbe,
+			grpcserver.WithServer(otlpServer.Register),
+			grpcserver.WithListen(conf.otlpGRPCConfig.BindAddress),
+			grpcserver.WithGracePeriod(time.Duration(*conf.otlpGRPCConfig.GracePeriod)),
+			grpcserver.WithTLSConfig(tlsCfg),
+		)
+		g.Add(func() error {
+			statusProber.Healthy()
+
+			return grpcSrv.ListenAndServe()
+		}, func(err error) {
+			statusProber.NotReady(err)
+			defer statusProber.NotHealthy(err)
+
+			grpcSrv.Shutdown(err)
+		})
+	}
+
 	updates := make(chan monitoringgateway.AdmissionControlConfig, 1)
 
-	// The config file path is given initializing config watcher.
-	if conf.tenantsFilePath != "" {
+	// The Tenant resources are watched from Kubernetes, the config file is the fallback out of the cluster.
+	if conf.tenantWatcher.Enabled {
+		tw, err := monitoringgateway.NewTenantWatcherFromFlags(log.With(logger, "component", "tenant-watcher"), reg, conf.tenantWatcher)
+		if err != nil {
+			close(updates)
+			return errors.Wrap(err, "failed to initialize tenant watcher")
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return monitoringgateway.ConfigFromWatcher(ctx, updates, tw)
+		}, func(error) {
+			cancel()
+		})
+	} else if conf.tenantsFilePath != "" {
+		// The config file path is given initializing config watcher.
 		cw, err := monitoringgateway.NewConfigWatcher(log.With(logger, "component", "config-watcher"), reg, conf.tenantsFilePath, *conf.refreshInterval)
 		if err != nil {
 			return errors.Wrap(err, "failed to initialize config watcher")
@@ -258,15 +453,32 @@ func (gc *gatewayConfig) registerFlag(cmd extkingpin.FlagClause) {
 
 	cmd.Flag("tenant.header", "HTTP header to determine tenant for write requests.").Default("WHIZARD-TENANT").StringVar(&gc.tenantHeader)
 	cmd.Flag("tenant.label-name", "Label name through which the tenant will be announced.").Default("tenant_id").StringVar(&gc.tenantLabelName)
+	cmd.Flag("tenant.rules-strict-tenancy", "If true, the rules without the tenant label are dropped from the /api/v1/rules responses, otherwise they are returned to all tenants with their alerts filtered.").Default("false").BoolVar(&gc.strictRulesTenancy)
+	cmd.Flag("tenant.resolution", "Source to resolve the tenant of the requests from, either path, header, cert or claim. The sources are tried in order, and the global /api/v1 routes are tenant enforced if a tenant is resolved by the other sources than the path. Repeat for multiple sources.").Default(string(monitoringgateway.TenantSourcePath)).EnumsVar(&gc.tenantResolution,
+		string(monitoringgateway.TenantSourcePath), string(monitoringgateway.TenantSourceHeader), string(monitoringgateway.TenantSourceCert), string(monitoringgateway.TenantSourceClaim))
+	cmd.Flag("tenant.resolution-header", "HTTP header to resolve the tenant from by the header tenant source, tried in order. Defaults to 'tenant.header' and X-Scope-OrgID. Repeat for multiple headers.").StringsVar(&gc.tenantResolutionHeaders)
+	cmd.Flag("tenant.usage-metrics", "If true, the per-tenant usage of the write and query requests is exported by the whizard_gateway_tenant_* metrics, which decodes the write requests to count their samples.").Default("false").BoolVar(&gc.usageMetrics)
+	cmd.Flag("tenant.usage-metrics-max-tenants", "Maximum number of tenants labeled in the usage metrics, the usage of the other tenants is accounted to the __overflow__ tenant. 0 is unlimited.").Default("1000").IntVar(&gc.usageMetricsMaxTenants)
+	cmd.Flag("otlp.tenant-attribute", "Resource attribute the tenants of the OTLP requests are mapped from, e.g. k8s.cluster.name. The resource metrics of the requests are split by tenant, and the resources without the attribute are written to the tenant of the request. It requires the authenticators configured by 'auth.config'. Disabled if empty.").Default("").StringVar(&gc.otlpTenantAttribute)
+	gc.authConfig = extflag.RegisterPathOrContent(cmd, "auth.config", "YAML file that contains the authenticator chain (mTLS, OIDC/JWT bearer tokens, static API keys and Kubernetes tokens) the tenant requests are authenticated with. The requests are not authenticated if empty.", extflag.WithEnvSubstitution())
 	cmd.Flag("tenant.admission-control-config-file", "Path to file that contains the configuration. A watcher is initialized to watch changes and update the dynamically.").PlaceHolder("<path>").StringVar(&gc.tenantsFilePath)
 	cmd.Flag("tenant.admission-control-config", "Alternative to 'tenant.admission-control-config-file' flag (lower priority). Content of file that contains the configuration.").PlaceHolder("<content>").StringVar(&gc.tenantsFileContent)
 	gc.refreshInterval = extkingpin.ModelDuration(cmd.Flag("tenant.admission-control-config-file-refresh-interval", "Refresh interval to re-read the configuration file. (used as a fallback)").Default("1m"))
 
+	cmd.Flag("tenant.limits-config-file", "Path to YAML file that contains the per-tenant limits. A watcher is initialized to watch changes and update the limits dynamically.").PlaceHolder("<path>").StringVar(&gc.limitsFilePath)
+	cmd.Flag("tenant.limits-config", "Alternative to 'tenant.limits-config-file' flag (lower priority). Content of YAML file that contains the per-tenant limits.").PlaceHolder("<content>").StringVar(&gc.limitsFileContent)
+	gc.limitsRefreshInterval = extkingpin.ModelDuration(cmd.Flag("tenant.limits-config-file-refresh-interval", "Refresh interval to re-read the limits configuration file. (used as a fallback)").Default("1m"))
+
 	gc.ExternalRemoteWrites.ConfigPathOrContent = *extflag.RegisterPathOrContent(cmd, "external-remote-writes.config", "Path to YAML config for the external remote-write configurations, that specify servers where received remote-write requests should be forwarded to.", extflag.WithEnvSubstitution())
+	gc.ExternalRemoteWrites.RefreshInterval = extkingpin.ModelDuration(cmd.Flag("external-remote-writes.config-file-refresh-interval", "Refresh interval to re-read the external remote-write configuration file. (used as a fallback)").Default("1m"))
+	cmd.Flag("external-remote-writes.queue-dir", "Directory of the durable queues of the external remote-write targets, the requests are kept until they are delivered.").Default("/whizard/external-remote-writes").StringVar(&gc.ExternalRemoteWrites.QueueDir)
 
 	gc.queryConfig.RegisterFlag(cmd)
 	gc.rulesQueryConfig.RegisterFlag(cmd)
 	gc.remoteWriteConfig.RegisterFlag(cmd)
+	gc.haTrackerConfig.RegisterFlag(cmd)
+	gc.otlpGRPCConfig.RegisterFlag(cmd)
+	gc.tenantWatcher.RegisterFlag(cmd)
 }
 
 var (
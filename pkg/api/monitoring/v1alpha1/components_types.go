@@ -98,6 +98,26 @@ type GatewaySpec struct {
 	// Deny unknown tenant data remote-write and query if enabled
 	EnabledTenantsAdmission bool `json:"enabledTenantsAdmission,omitempty"`
 
+	// The source of the admitted tenants if the tenants admission is enabled, one of configmap, kubernetes. Defaults to configmap.
+	// The configmap source mounts the tenants rendered to a ConfigMap, which are synced by the kubelet with a delay of up to a minute,
+	// while the kubernetes source watches the Tenant resources of the service from the gateway directly.
+	// +kubebuilder:validation:Enum="";configmap;kubernetes
+	TenantsAdmissionSource TenantsAdmissionSource `json:"tenantsAdmissionSource,omitempty"`
+
+	// Authenticate requests by Kubernetes bearer tokens, and authorize the tenant access by Kubernetes RBAC if enabled.
+	KubernetesAuth *GatewayKubernetesAuth `json:"kubernetesAuth,omitempty"`
+
+	// Deduplicate the remote write requests of the replicas of HA clusters, such as HA pairs of Prometheus, if enabled.
+	HATracker *GatewayHATracker `json:"haTracker,omitempty"`
+
+	// Receive the OTLP metrics over gRPC on the otlp-grpc port of the gateway service if enabled.
+	OTLPGRPC *GatewayOTLPGRPC `json:"otlpGRPC,omitempty"`
+
+	// The resource attribute the tenants of the OTLP requests are mapped from, such as k8s.cluster.name.
+	// The resource metrics of the requests are split by tenant, and the resources without the attribute
+	// are written to the tenant of the request. It requires the kubernetesAuth enabled to authorize the tenants.
+	OTLPTenantAttribute string `json:"otlpTenantAttribute,omitempty"`
+
 	// NodePort is the port used to expose the gateway service.
 	// If this is a valid node port, the gateway service type will be set to NodePort accordingly.
 	NodePort int32 `json:"nodePort,omitempty"`
@@ -105,6 +125,58 @@ type GatewaySpec struct {
 	CommonSpec `json:",inline"`
 }
 
+type TenantsAdmissionSource string
+
+const (
+	ConfigMapTenantsAdmission  TenantsAdmissionSource = "configmap"
+	KubernetesTenantsAdmission TenantsAdmissionSource = "kubernetes"
+)
+
+// GatewayKubernetesAuth configures the authentication of the gateway requests by Kubernetes bearer tokens,
+// such as the tokens of ServiceAccounts.
+//
+// The tokens are validated with the TokenReview API, and the tenant access is authorized with SubjectAccessReviews
+// on the resource `tenants.monitoring.whizard.io`, named by the tenant, with the verb `read` for queries and `write` for writes.
+// For example, a ServiceAccount is granted to write the tenant `t1` by a Role with the rule:
+//
+//	apiGroups: ["monitoring.whizard.io"]
+//	resources: ["tenants"]
+//	resourceNames: ["t1"]
+//	verbs: ["write"]
+type GatewayKubernetesAuth struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Audiences of the tokens. The audiences of the API server are assumed if empty.
+	Audiences []string `json:"audiences,omitempty"`
+	// The duration the token and access reviews are cached for. Defaults to 1m.
+	CacheTTL Duration `json:"cacheTTL,omitempty"`
+}
+
+// GatewayHATracker configures the deduplication of the remote write requests of the replicas of HA clusters.
+//
+// The requests are identified by the cluster and replica labels of their series. One replica per cluster of a tenant
+// is elected, whose samples are accepted without the replica label, while the requests of the other replicas are
+// accepted and dropped. Another replica is elected once the elected one has not written for the failover timeout.
+// The elected replicas are shared across the gateway replicas by Leases in the namespace of the gateway.
+type GatewayHATracker struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// The label identifying the HA cluster of the series. Defaults to cluster.
+	ClusterLabel string `json:"clusterLabel,omitempty"`
+	// The label identifying the replica of the series in the HA cluster. Defaults to __replica__.
+	ReplicaLabel string `json:"replicaLabel,omitempty"`
+	// The time after the last accepted request of the elected replica, another replica is elected after. Defaults to 30s.
+	FailoverTimeout Duration `json:"failoverTimeout,omitempty"`
+}
+
+// GatewayOTLPGRPC configures the OTLP/gRPC metrics receiver of the gateway.
+//
+// The receiver listens on the port 4317, exposed as the otlp-grpc port of the gateway service, and forwards the
+// metrics to the OTLP endpoint of the router. The tenants of the requests are resolved from the request metadata
+// by the tenant header, or mapped from the otlpTenantAttribute resource attribute if it's set. The TLS of the
+// web config, if any, applies to the receiver too, and the tenants are resolved from the client certificates then.
+type GatewayOTLPGRPC struct {
+	Enabled bool `json:"enabled,omitempty"`
+}
+
 // GatewayStatus defines the observed state of Gateway
 type GatewayStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
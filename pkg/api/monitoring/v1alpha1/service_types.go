@@ -108,6 +108,16 @@ type RemoteWriteSpec struct {
 	Headers map[string]string `json:"headers,omitempty"`
 	// Timeout for requests to the remote write endpoint.
 	RemoteTimeout Duration `json:"remoteTimeout,omitempty"`
+	// The remote write protobuf message sent to the endpoint, "prometheus.WriteRequest" (remote write 1.0, default)
+	// or "io.prometheus.write.v2.Request" (remote write 2.0). The received requests are transcoded if needed.
+	// +kubebuilder:validation:Enum="";prometheus.WriteRequest;io.prometheus.write.v2.Request
+	ProtobufMessage string `json:"protobufMessage,omitempty"`
+	// The list of relabel configurations applied to the series before sending them to the endpoint.
+	WriteRelabelConfigs []RelabelConfig `json:"writeRelabelConfigs,omitempty"`
+	// The tenants whose series are sent to the endpoint. All tenants are allowed if empty.
+	AllowedTenants []string `json:"allowedTenants,omitempty"`
+	// The tenants whose series are never sent to the endpoint, it takes precedence over AllowedTenants.
+	DeniedTenants []string `json:"deniedTenants,omitempty"`
 
 	HTTPClientConfig `json:",inline"`
 }
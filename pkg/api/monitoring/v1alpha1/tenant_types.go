@@ -18,19 +18,39 @@ import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 // TenantSpec defines the desired state of Tenant
 type TenantSpec struct {
 	Tenant string `json:"tenant,omitempty"`
+
+	// The access mode of the tenant, one of active, read-only, write-only, suspended. Defaults to active.
+	// The gateways with the tenants admission enabled reject the writes of the read-only tenants, the reads of
+	// the write-only tenants and all requests of the suspended tenants, while the data of the tenants are kept.
+	// +kubebuilder:validation:Enum="";active;read-only;write-only;suspended
+	AccessMode TenantAccessMode `json:"accessMode,omitempty"`
 }
 
+type TenantAccessMode string
+
+const (
+	TenantAccessModeActive    TenantAccessMode = "active"
+	TenantAccessModeReadOnly  TenantAccessMode = "read-only"
+	TenantAccessModeWriteOnly TenantAccessMode = "write-only"
+	TenantAccessModeSuspended TenantAccessMode = "suspended"
+)
+
 // TenantStatus defines the observed state of Tenant
 type TenantStatus struct {
 	Ruler     *ObjectReference `json:"ruler,omitempty"`
 	Compactor *ObjectReference `json:"compactor,omitempty"`
 	Ingester  *ObjectReference `json:"ingester,omitempty"`
+
+	// The effective access mode of the tenant.
+	AccessMode TenantAccessMode `json:"accessMode,omitempty"`
 }
 
 // +genclient
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Access Mode",type="string",JSONPath=".status.accessMode",description="The effective access mode of the tenant"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // The `Tenant` custom resource definition (CRD) defines the tenant configuration for multi-tenant data separation in Whizard.
 // In Whizard, a tenant can represent various types of data sources, such as:
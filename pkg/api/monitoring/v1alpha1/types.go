@@ -209,3 +209,27 @@ type BasicAuth struct {
 
 type HTTPServerConfig struct {
 }
+
+// RelabelConfig allows dynamic rewriting of the label set.
+// More info: https://prometheus.io/docs/prometheus/latest/configuration/configuration/#relabel_config
+type RelabelConfig struct {
+	// The source labels select values from existing labels. Their content is
+	// concatenated using the configured Separator and matched against the
+	// configured regular expression.
+	SourceLabels []string `json:"sourceLabels,omitempty"`
+	// Separator is the string between concatenated SourceLabels.
+	Separator *string `json:"separator,omitempty"`
+	// Label to which the resulting string is written in a replacement.
+	TargetLabel string `json:"targetLabel,omitempty"`
+	// Regular expression against which the extracted value is matched.
+	Regex string `json:"regex,omitempty"`
+	// Modulus to take of the hash of the source label values.
+	Modulus uint64 `json:"modulus,omitempty"`
+	// Replacement value against which a Replace action is performed if the
+	// regular expression matches.
+	Replacement *string `json:"replacement,omitempty"`
+	// Action to perform based on the regex matching.
+	// +kubebuilder:validation:Enum=replace;keep;drop;keepequal;dropequal;hashmod;labelmap;labeldrop;labelkeep;lowercase;uppercase
+	// +kubebuilder:default=replace
+	Action string `json:"action,omitempty"`
+}
@@ -360,6 +360,41 @@ func (in *Gateway) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewayHATracker) DeepCopyInto(out *GatewayHATracker) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewayHATracker.
+func (in *GatewayHATracker) DeepCopy() *GatewayHATracker {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewayHATracker)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewayKubernetesAuth) DeepCopyInto(out *GatewayKubernetesAuth) {
+	*out = *in
+	if in.Audiences != nil {
+		in, out := &in.Audiences, &out.Audiences
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewayKubernetesAuth.
+func (in *GatewayKubernetesAuth) DeepCopy() *GatewayKubernetesAuth {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewayKubernetesAuth)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GatewayList) DeepCopyInto(out *GatewayList) {
 	*out = *in
@@ -392,6 +427,21 @@ func (in *GatewayList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewayOTLPGRPC) DeepCopyInto(out *GatewayOTLPGRPC) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewayOTLPGRPC.
+func (in *GatewayOTLPGRPC) DeepCopy() *GatewayOTLPGRPC {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewayOTLPGRPC)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GatewaySpec) DeepCopyInto(out *GatewaySpec) {
 	*out = *in
@@ -400,6 +450,21 @@ func (in *GatewaySpec) DeepCopyInto(out *GatewaySpec) {
 		*out = new(WebConfig)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.KubernetesAuth != nil {
+		in, out := &in.KubernetesAuth, &out.KubernetesAuth
+		*out = new(GatewayKubernetesAuth)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HATracker != nil {
+		in, out := &in.HATracker, &out.HATracker
+		*out = new(GatewayHATracker)
+		**out = **in
+	}
+	if in.OTLPGRPC != nil {
+		in, out := &in.OTLPGRPC, &out.OTLPGRPC
+		*out = new(GatewayOTLPGRPC)
+		**out = **in
+	}
 	in.CommonSpec.DeepCopyInto(&out.CommonSpec)
 }
 
@@ -964,6 +1029,36 @@ func (in *QueryStores) DeepCopy() *QueryStores {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RelabelConfig) DeepCopyInto(out *RelabelConfig) {
+	*out = *in
+	if in.SourceLabels != nil {
+		in, out := &in.SourceLabels, &out.SourceLabels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Separator != nil {
+		in, out := &in.Separator, &out.Separator
+		*out = new(string)
+		**out = **in
+	}
+	if in.Replacement != nil {
+		in, out := &in.Replacement, &out.Replacement
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RelabelConfig.
+func (in *RelabelConfig) DeepCopy() *RelabelConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(RelabelConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RemoteQuerySpec) DeepCopyInto(out *RemoteQuerySpec) {
 	*out = *in
@@ -990,6 +1085,23 @@ func (in *RemoteWriteSpec) DeepCopyInto(out *RemoteWriteSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.WriteRelabelConfigs != nil {
+		in, out := &in.WriteRelabelConfigs, &out.WriteRelabelConfigs
+		*out = make([]RelabelConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AllowedTenants != nil {
+		in, out := &in.AllowedTenants, &out.AllowedTenants
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DeniedTenants != nil {
+		in, out := &in.DeniedTenants, &out.DeniedTenants
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	in.HTTPClientConfig.DeepCopyInto(&out.HTTPClientConfig)
 }
 
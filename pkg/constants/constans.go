@@ -22,6 +22,7 @@ const (
 	FinalizerIngester  = "finalizers.monitoring.whizard.io/ingester"
 	FinalizerCompactor = "finalizers.monitoring.whizard.io/compactor"
 	FinalizerDeletePVC = "finalizers.monitoring.whizard.io/deletePVC"
+	FinalizerGateway   = "finalizers.monitoring.whizard.io/gateway"
 
 	DefaultStorage = "default"
 	LocalStorage   = "local"
@@ -150,6 +151,10 @@ const (
 	RemoteWritePort     = 19291
 	CapNProtoPortName   = "capnproto"
 	CapNProtoPort       = 19391
+
+	// gateway
+	OTLPGRPCPortName = "otlp-grpc"
+	OTLPGRPCPort     = 4317
 )
 
 // ConponentProbePreset defines standard probe presets for components.
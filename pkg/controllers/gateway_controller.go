@@ -16,6 +16,7 @@ import (
 	"dario.cat/mergo"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -23,6 +24,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -53,6 +55,11 @@ type GatewayReconciler struct {
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=services;configmaps;secrets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles;clusterrolebindings,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;create;update;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -81,6 +88,20 @@ func (r *GatewayReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, nil
 	}
 
+	// The cluster scoped resources of the gateway are not garbage collected by owner references.
+	if !instance.GetDeletionTimestamp().IsZero() {
+		if controllerutil.ContainsFinalizer(instance, constants.FinalizerGateway) {
+			if err := gateway.DeleteClusterResources(ctx, r.Client, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+			controllerutil.RemoveFinalizer(instance, constants.FinalizerGateway)
+			return ctrl.Result{}, r.Client.Update(ctx, instance)
+		}
+		return ctrl.Result{}, nil
+	}
+	// The instance is merged with the template of the service below, which must not be persisted.
+	original := instance.DeepCopy()
+
 	service := &monitoringv1alpha1.Service{}
 	if err := r.Get(ctx, *util.ServiceNamespacedName(&instance.ObjectMeta), service); err != nil {
 		return ctrl.Result{}, err
@@ -90,6 +111,14 @@ func (r *GatewayReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, err
 	}
 
+	if gateway.RequiresClusterResources(instance) && !controllerutil.ContainsFinalizer(original, constants.FinalizerGateway) {
+		patched := original.DeepCopy()
+		controllerutil.AddFinalizer(patched, constants.FinalizerGateway)
+		if err := r.Client.Patch(ctx, patched, client.MergeFrom(original)); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
 	gatewayReconciler, err := gateway.New(
 		resources.BaseReconciler{
 			Client:  r.Client,
@@ -138,6 +167,10 @@ func (r *GatewayReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
 		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Secret{}).
+		Owns(&corev1.ServiceAccount{}).
+		Owns(&rbacv1.Role{}).
+		Owns(&rbacv1.RoleBinding{}).
 		Complete(r)
 }
 
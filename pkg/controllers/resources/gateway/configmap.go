@@ -26,7 +26,8 @@ func (g *Gateway) tenantsAdmissionConfigMap() (runtime.Object, resources.Operati
 		return cm, resources.OperationDelete, nil
 	}
 
-	if !g.gateway.Spec.EnabledTenantsAdmission {
+	// The gateway watches the Tenant resources directly instead of the ConfigMap.
+	if !g.gateway.Spec.EnabledTenantsAdmission || g.tenantsAdmissionWatched() {
 		return cm, resources.OperationDelete, nil
 	}
 
@@ -41,6 +42,12 @@ func (g *Gateway) tenantsAdmissionConfigMap() (runtime.Object, resources.Operati
 		if tenant.GetDeletionTimestamp().IsZero() {
 			if v, ok := tenant.Labels[constants.ServiceLabelKey]; ok && g.gateway.Labels[constants.ServiceLabelKey] == v {
 				acConfig.Tenants = append(acConfig.Tenants, tenant.Spec.Tenant)
+				if mode := tenant.Spec.AccessMode; mode != "" && mode != v1alpha1.TenantAccessModeActive {
+					if acConfig.AccessModes == nil {
+						acConfig.AccessModes = map[string]monitoringgateway.AccessMode{}
+					}
+					acConfig.AccessModes[tenant.Spec.Tenant] = monitoringgateway.AccessMode(mode)
+				}
 			}
 		}
 	}
@@ -6,11 +6,12 @@ import (
 	"fmt"
 	"net/url"
 	"reflect"
-	"time"
+	"strings"
 
 	"github.com/prometheus-operator/prometheus-operator/pkg/k8sutil"
 	config_util "github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
 	"gopkg.in/yaml.v3"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -29,6 +30,11 @@ import (
 	"github.com/WhizardTelemetry/whizard/pkg/util"
 )
 
+const (
+	externalRemoteWritesMountPath = "/etc/whizard/external-remote-writes/"
+	externalRemoteWritesQueueDir  = "/whizard/external-remote-writes"
+)
+
 func (g *Gateway) deployment() (runtime.Object, resources.Operation, error) {
 	var d = &appsv1.Deployment{ObjectMeta: g.meta(g.name())}
 
@@ -111,7 +117,14 @@ func (g *Gateway) deployment() (runtime.Object, resources.Operation, error) {
 	if g.gateway.Spec.DebugMode {
 		container.Args = append(container.Args, "--debug.enable-ui")
 	}
-	if g.gateway.Spec.EnabledTenantsAdmission {
+	if g.tenantsAdmissionWatched() {
+		d.Spec.Template.Spec.ServiceAccountName = g.name()
+
+		container.Args = append(container.Args,
+			"--tenant.admission-control-kubernetes",
+			fmt.Sprintf("--tenant.admission-control-kubernetes-selector=%s=%s", constants.ServiceLabelKey, g.gateway.Labels[constants.ServiceLabelKey]),
+		)
+	} else if g.gateway.Spec.EnabledTenantsAdmission {
 
 		container.Args = append(container.Args, fmt.Sprintf("--tenant.admission-control-config-file=%s", constants.WhizardConfigMountPath+tenantsAdmissionConfigFile))
 
@@ -134,6 +147,85 @@ func (g *Gateway) deployment() (runtime.Object, resources.Operation, error) {
 		container.VolumeMounts = append(container.VolumeMounts, volumeMount)
 	}
 
+	if g.kubernetesAuthEnabled() {
+		d.Spec.Template.Spec.ServiceAccountName = g.name()
+
+		authCfg := &monitoringgateway.KubernetesAuthConfig{
+			Audiences: g.gateway.Spec.KubernetesAuth.Audiences,
+		}
+		if g.gateway.Spec.KubernetesAuth.CacheTTL != "" {
+			ttl, err := model.ParseDuration(string(g.gateway.Spec.KubernetesAuth.CacheTTL))
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid kubernetesAuth cacheTTL: %s", g.gateway.Spec.KubernetesAuth.CacheTTL)
+			}
+			authCfg.CacheTTL = ttl
+		}
+		buff, err := yaml.Marshal(monitoringgateway.AuthConfig{
+			Authenticators: []monitoringgateway.AuthenticatorConfig{{Kubernetes: authCfg}},
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		container.Args = append(container.Args, fmt.Sprintf("--auth.config=%s", buff))
+	}
+
+	if g.haTrackerEnabled() {
+		d.Spec.Template.Spec.ServiceAccountName = g.name()
+
+		haTracker := g.gateway.Spec.HATracker
+		container.Args = append(container.Args,
+			"--ha-tracker.enabled",
+			"--ha-tracker.store="+monitoringgateway.HAStoreLease,
+			"--ha-tracker.lease-namespace="+d.Namespace,
+		)
+		if haTracker.ClusterLabel != "" {
+			container.Args = append(container.Args, "--ha-tracker.cluster-label="+haTracker.ClusterLabel)
+		}
+		if haTracker.ReplicaLabel != "" {
+			container.Args = append(container.Args, "--ha-tracker.replica-label="+haTracker.ReplicaLabel)
+		}
+		if haTracker.FailoverTimeout != "" {
+			timeout, err := model.ParseDuration(string(haTracker.FailoverTimeout))
+			if err != nil || timeout <= 0 {
+				return nil, "", fmt.Errorf("invalid haTracker failoverTimeout: %s", haTracker.FailoverTimeout)
+			}
+			// The elected replicas are updated twice per failover timeout.
+			container.Args = append(container.Args,
+				"--ha-tracker.failover-timeout="+timeout.String(),
+				"--ha-tracker.update-timeout="+(timeout/2).String(),
+			)
+		}
+	}
+
+	if g.gateway.Spec.OTLPTenantAttribute != "" {
+		// The mapped tenants are set by the clients, which are authorized by the authenticators only.
+		if !g.kubernetesAuthEnabled() {
+			return nil, "", fmt.Errorf("otlpTenantAttribute requires kubernetesAuth enabled")
+		}
+		container.Args = append(container.Args, "--otlp.tenant-attribute="+g.gateway.Spec.OTLPTenantAttribute)
+	}
+	if g.otlpGRPCEnabled() {
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			Name:          constants.OTLPGRPCPortName,
+			ContainerPort: constants.OTLPGRPCPort,
+			Protocol:      corev1.ProtocolTCP,
+		})
+		container.Args = append(container.Args, fmt.Sprintf("--otlp.grpc-address=0.0.0.0:%d", constants.OTLPGRPCPort))
+		// The receiver is served with the TLS assets of the web config.
+		if webConfig := g.gateway.Spec.WebConfig; webConfig != nil && webConfig.HTTPServerTLSConfig != nil {
+			tlsConfig := webConfig.HTTPServerTLSConfig
+			if tlsConfig.CertSecret.Name != "" && tlsConfig.KeySecret.Name != "" {
+				container.Args = append(container.Args,
+					"--otlp.grpc-server-tls-cert="+constants.WhizardCertsMountPath+tlsConfig.CertSecret.Key,
+					"--otlp.grpc-server-tls-key="+constants.WhizardCertsMountPath+tlsConfig.KeySecret.Key,
+				)
+				if tlsConfig.ClientCASecret.Name != "" {
+					container.Args = append(container.Args, "--otlp.grpc-server-tls-client-ca="+constants.WhizardCertsMountPath+tlsConfig.ClientCASecret.Key)
+				}
+			}
+		}
+	}
+
 	if g.gateway.Spec.WebConfig != nil {
 		secret, _, err := g.webConfigSecret()
 		if err != nil {
@@ -253,53 +345,34 @@ func (g *Gateway) deployment() (runtime.Object, resources.Operation, error) {
 		container.Args = append(container.Args, fmt.Sprintf("--remote-write.config=%s", buff))
 	}
 
-	var rwsCfg []*monitoringgateway.ExternalRemoteWriteConfig
-	// write to configured remote-writes targets
-	if g.Service != nil {
-		for _, rw := range g.Service.Spec.RemoteWrites {
-			url, err := url.Parse(rw.URL)
-			if err != nil {
-				return nil, "", fmt.Errorf("invalid remote write url: %s", rw.URL)
-			}
-			rwCfg := &monitoringgateway.ExternalRemoteWriteConfig{
-				Name:    rw.Name,
-				URL:     &config_util.URL{URL: url},
-				Headers: rw.Headers,
-			}
-			if rw.RemoteTimeout != "" {
-				timeout, err := time.ParseDuration(string(rw.RemoteTimeout))
-				if err != nil {
-					return nil, "", fmt.Errorf("invalid remoteTimeout: %s", rw.RemoteTimeout)
-				}
-				rwCfg.RemoteTimeout = model.Duration(timeout)
-			}
-			if url.Scheme == "https" {
-				rwCfg.TLSConfig = config_util.TLSConfig{InsecureSkipVerify: true}
-			}
-			if !reflect.DeepEqual(rw.HTTPClientConfig.BasicAuth, v1alpha1.BasicAuth{}) {
-				secret := &corev1.Secret{}
-				rwCfg.BasicAuth = &monitoringgateway.BasicAuth{}
-				if err := g.Client.Get(g.Context, client.ObjectKey{Name: rw.HTTPClientConfig.BasicAuth.Username.Name, Namespace: g.Service.Namespace}, secret); err != nil {
-					return nil, "", err
-				}
-				rwCfg.BasicAuth.Username = string(secret.Data[rw.HTTPClientConfig.BasicAuth.Username.Key])
-				if err := g.Client.Get(g.Context, client.ObjectKey{Name: rw.HTTPClientConfig.BasicAuth.Password.Name, Namespace: g.Service.Namespace}, secret); err != nil {
-					return nil, "", err
-				}
-				rwCfg.BasicAuth.Password = string(secret.Data[rw.HTTPClientConfig.BasicAuth.Password.Key])
-			}
-			if rw.HTTPClientConfig.BearerToken != "" {
-				rwCfg.BearerToken = string(rw.HTTPClientConfig.BearerToken)
-			}
-			rwsCfg = append(rwsCfg, rwCfg)
-		}
+	// The external remote writes are read from the mounted secret and reloaded on change,
+	// so the pods don't need to be restarted when the remote writes of the service are updated.
+	container.Args = append(container.Args, fmt.Sprintf("--external-remote-writes.config-file=%s", externalRemoteWritesMountPath+externalRemoteWritesConfigFile))
+	configVolume := corev1.Volume{
+		Name: "external-remote-writes-config",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: g.name("external-remote-writes"),
+			},
+		},
 	}
-	// add external-remote-writes.config flag to gateway
-	buff, err := yaml.Marshal(rwsCfg)
-	if err != nil {
-		return nil, "", err
+	// The queues of the external remote writes survive container restarts.
+	queueVolume := corev1.Volume{
+		Name: "external-remote-writes-queue",
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
 	}
-	container.Args = append(container.Args, fmt.Sprintf("--external-remote-writes.config=%s", buff))
+	d.Spec.Template.Spec.Volumes = append(d.Spec.Template.Spec.Volumes, configVolume, queueVolume)
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      configVolume.Name,
+		MountPath: externalRemoteWritesMountPath,
+		ReadOnly:  true,
+	}, corev1.VolumeMount{
+		Name:      queueVolume.Name,
+		MountPath: externalRemoteWritesQueueDir,
+	})
+	container.Args = append(container.Args, fmt.Sprintf("--external-remote-writes.queue-dir=%s", externalRemoteWritesQueueDir))
 
 	d.Spec.Template.Spec.Containers = append(d.Spec.Template.Spec.Containers, container)
 
@@ -318,6 +391,33 @@ func (g *Gateway) deployment() (runtime.Object, resources.Operation, error) {
 	return d, resources.OperationCreateOrUpdate, ctrl.SetControllerReference(g.gateway, d, g.Scheme)
 }
 
+// relabelConfig converts a relabel config of the API to the Prometheus one, defaulting the unset fields.
+func relabelConfig(rc v1alpha1.RelabelConfig) (*relabel.Config, error) {
+	cfg := relabel.DefaultRelabelConfig
+	for _, l := range rc.SourceLabels {
+		cfg.SourceLabels = append(cfg.SourceLabels, model.LabelName(l))
+	}
+	if rc.Separator != nil {
+		cfg.Separator = *rc.Separator
+	}
+	cfg.TargetLabel = rc.TargetLabel
+	if rc.Regex != "" {
+		regex, err := relabel.NewRegexp(rc.Regex)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Regex = regex
+	}
+	cfg.Modulus = rc.Modulus
+	if rc.Replacement != nil {
+		cfg.Replacement = *rc.Replacement
+	}
+	if rc.Action != "" {
+		cfg.Action = relabel.Action(strings.ToLower(rc.Action))
+	}
+	return &cfg, cfg.Validate()
+}
+
 func (g *Gateway) queryfrontendAddress() (string, error) {
 	queryFrontendList := &v1alpha1.QueryFrontendList{}
 	if err := g.Client.List(g.Context, queryFrontendList, client.MatchingLabels(util.ManagedLabelBySameService(g.gateway))); err != nil {
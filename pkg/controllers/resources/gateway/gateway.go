@@ -69,6 +69,13 @@ func (g *Gateway) HttpsAddr() string {
 
 func (g *Gateway) Reconcile() error {
 	return g.ReconcileResources([]resources.Resource{
+		g.serviceAccount,
+		g.clusterRole,
+		g.clusterRoleBinding,
+		g.role,
+		g.roleBinding,
+		// The secret is mounted by the deployment, so that it's reconciled first.
+		g.externalRemoteWritesSecret,
 		g.deployment,
 		g.service,
 		g.tenantsAdmissionConfigMap,
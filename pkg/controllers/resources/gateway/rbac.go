@@ -0,0 +1,174 @@
+package gateway
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/WhizardTelemetry/whizard/pkg/api/monitoring/v1alpha1"
+	"github.com/WhizardTelemetry/whizard/pkg/constants"
+	"github.com/WhizardTelemetry/whizard/pkg/controllers/resources"
+)
+
+// kubernetesAuthEnabled returns true if the gateway authenticates the requests by Kubernetes tokens,
+// which requires the gateway to create TokenReviews and SubjectAccessReviews.
+func (g *Gateway) kubernetesAuthEnabled() bool {
+	return g.gateway != nil && g.gateway.Spec.KubernetesAuth != nil && g.gateway.Spec.KubernetesAuth.Enabled
+}
+
+// haTrackerEnabled returns true if the gateway deduplicates the requests of HA clusters,
+// which requires the gateway to manage the Leases of the elected replicas.
+func (g *Gateway) haTrackerEnabled() bool {
+	return g.gateway != nil && g.gateway.Spec.HATracker != nil && g.gateway.Spec.HATracker.Enabled
+}
+
+// tenantsAdmissionWatched returns true if the gateway watches the Tenant resources of the service for the tenants admission,
+// which requires the gateway to list and watch the Tenants.
+func (g *Gateway) tenantsAdmissionWatched() bool {
+	return g.gateway != nil && g.gateway.Spec.EnabledTenantsAdmission &&
+		g.gateway.Spec.TenantsAdmissionSource == v1alpha1.KubernetesTenantsAdmission
+}
+
+func (g *Gateway) serviceAccount() (runtime.Object, resources.Operation, error) {
+	var sa = &corev1.ServiceAccount{ObjectMeta: g.meta(g.name())}
+
+	if !g.kubernetesAuthEnabled() && !g.haTrackerEnabled() && !g.tenantsAdmissionWatched() {
+		return sa, resources.OperationDelete, nil
+	}
+
+	return sa, resources.OperationCreateOrUpdate, ctrl.SetControllerReference(g.gateway, sa, g.Scheme)
+}
+
+// role and roleBinding grant the gateway to manage the Leases of the HA tracker in its namespace.
+func (g *Gateway) role() (runtime.Object, resources.Operation, error) {
+	var role = &rbacv1.Role{ObjectMeta: g.meta(g.name("ha-tracker"))}
+
+	if !g.haTrackerEnabled() {
+		return role, resources.OperationDelete, nil
+	}
+
+	role.Rules = []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{"coordination.k8s.io"},
+			Resources: []string{"leases"},
+			Verbs:     []string{"get", "list", "create", "update", "delete"},
+		},
+	}
+	return role, resources.OperationCreateOrUpdate, ctrl.SetControllerReference(g.gateway, role, g.Scheme)
+}
+
+func (g *Gateway) roleBinding() (runtime.Object, resources.Operation, error) {
+	var binding = &rbacv1.RoleBinding{ObjectMeta: g.meta(g.name("ha-tracker"))}
+
+	if !g.haTrackerEnabled() {
+		return binding, resources.OperationDelete, nil
+	}
+
+	binding.RoleRef = rbacv1.RoleRef{
+		APIGroup: rbacv1.GroupName,
+		Kind:     "Role",
+		Name:     binding.Name,
+	}
+	binding.Subjects = []rbacv1.Subject{
+		{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      g.name(),
+			Namespace: binding.Namespace,
+		},
+	}
+	return binding, resources.OperationCreateOrUpdate, ctrl.SetControllerReference(g.gateway, binding, g.Scheme)
+}
+
+// clusterRole and clusterRoleBinding are cluster scoped, so they can't be owned by the gateway,
+// they are deleted by DeleteClusterResources instead when the gateway is deleted.
+func (g *Gateway) clusterRole() (runtime.Object, resources.Operation, error) {
+	var role = &rbacv1.ClusterRole{ObjectMeta: clusterResourceMeta(g.gateway)}
+
+	if !g.clusterRoleRequired() {
+		return role, resources.OperationDelete, nil
+	}
+
+	role.Labels = g.labels()
+	if g.kubernetesAuthEnabled() {
+		role.Rules = append(role.Rules,
+			rbacv1.PolicyRule{
+				APIGroups: []string{"authentication.k8s.io"},
+				Resources: []string{"tokenreviews"},
+				Verbs:     []string{"create"},
+			},
+			rbacv1.PolicyRule{
+				APIGroups: []string{"authorization.k8s.io"},
+				Resources: []string{"subjectaccessreviews"},
+				Verbs:     []string{"create"},
+			},
+		)
+	}
+	if g.tenantsAdmissionWatched() {
+		role.Rules = append(role.Rules, rbacv1.PolicyRule{
+			APIGroups: []string{v1alpha1.GroupVersion.Group},
+			Resources: []string{"tenants"},
+			Verbs:     []string{"get", "list", "watch"},
+		})
+	}
+	return role, resources.OperationCreateOrUpdate, nil
+}
+
+func (g *Gateway) clusterRoleBinding() (runtime.Object, resources.Operation, error) {
+	var binding = &rbacv1.ClusterRoleBinding{ObjectMeta: clusterResourceMeta(g.gateway)}
+
+	if !g.clusterRoleRequired() {
+		return binding, resources.OperationDelete, nil
+	}
+
+	binding.Labels = g.labels()
+	binding.RoleRef = rbacv1.RoleRef{
+		APIGroup: rbacv1.GroupName,
+		Kind:     "ClusterRole",
+		Name:     binding.Name,
+	}
+	binding.Subjects = []rbacv1.Subject{
+		{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      g.name(),
+			Namespace: g.gateway.Namespace,
+		},
+	}
+	return binding, resources.OperationCreateOrUpdate, nil
+}
+
+// clusterRoleRequired returns true if the cluster scoped resources of the gateway are kept.
+func (g *Gateway) clusterRoleRequired() bool {
+	return RequiresClusterResources(g.gateway) && g.gateway.DeletionTimestamp.IsZero()
+}
+
+// RequiresClusterResources returns true if the gateway requires the cluster scoped permissions,
+// by the Kubernetes authentication or the Tenants watch, which are deleted by DeleteClusterResources.
+func RequiresClusterResources(gateway *v1alpha1.Gateway) bool {
+	g := &Gateway{gateway: gateway}
+	return g.kubernetesAuthEnabled() || g.tenantsAdmissionWatched()
+}
+
+// DeleteClusterResources deletes the cluster scoped resources of the gateway.
+func DeleteClusterResources(ctx context.Context, c client.Client, gateway *v1alpha1.Gateway) error {
+	for _, obj := range []client.Object{
+		&rbacv1.ClusterRoleBinding{ObjectMeta: clusterResourceMeta(gateway)},
+		&rbacv1.ClusterRole{ObjectMeta: clusterResourceMeta(gateway)},
+	} {
+		if err := c.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func clusterResourceMeta(gateway *v1alpha1.Gateway) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name: "whizard:" + gateway.Namespace + ":" + constants.AppNameGateway + "-" + gateway.Name + "-auth",
+	}
+}
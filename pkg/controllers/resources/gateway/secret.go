@@ -1,24 +1,35 @@
 package gateway
 
 import (
+	"fmt"
 	"math/rand"
+	"net/url"
+	"reflect"
 	"time"
 
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	promconfig "github.com/prometheus/prometheus/config"
 	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/WhizardTelemetry/whizard/pkg/api/monitoring/v1alpha1"
 	"github.com/WhizardTelemetry/whizard/pkg/constants"
 	"github.com/WhizardTelemetry/whizard/pkg/controllers/resources"
+	monitoringgateway "github.com/WhizardTelemetry/whizard/pkg/monitoring-gateway"
 )
 
 const (
 	TLSVersionTLS12 = "TLS12"
 	TLSVersionTLS13 = "TLS13"
+
+	externalRemoteWritesConfigFile = "external-remote-writes.yaml"
 )
 
 func (g *Gateway) webConfigSecret() (runtime.Object, resources.Operation, error) {
@@ -72,6 +83,78 @@ func (g *Gateway) webConfigSecret() (runtime.Object, resources.Operation, error)
 	return secret, resources.OperationCreateOrUpdate, ctrl.SetControllerReference(g.gateway, secret, g.Scheme)
 }
 
+// externalRemoteWritesSecret renders the external remote writes of the service, which the gateway reloads on change.
+// It is kept even if there is no remote write, so that the removal of the last one is picked up as well.
+func (g *Gateway) externalRemoteWritesSecret() (runtime.Object, resources.Operation, error) {
+	var secret = &corev1.Secret{ObjectMeta: g.meta(g.name("external-remote-writes"))}
+
+	if g.gateway == nil {
+		return secret, resources.OperationDelete, nil
+	}
+
+	var rwsCfg []*monitoringgateway.ExternalRemoteWriteConfig
+	// write to configured remote-writes targets
+	if g.Service != nil {
+		for _, rw := range g.Service.Spec.RemoteWrites {
+			url, err := url.Parse(rw.URL)
+			if err != nil {
+				return nil, resources.OperationCreateOrUpdate, fmt.Errorf("invalid remote write url: %s", rw.URL)
+			}
+			rwCfg := &monitoringgateway.ExternalRemoteWriteConfig{
+				Name:            rw.Name,
+				URL:             &config_util.URL{URL: url},
+				Headers:         rw.Headers,
+				ProtobufMessage: promconfig.RemoteWriteProtoMsg(rw.ProtobufMessage),
+			}
+			if rw.RemoteTimeout != "" {
+				timeout, err := time.ParseDuration(string(rw.RemoteTimeout))
+				if err != nil {
+					return nil, resources.OperationCreateOrUpdate, fmt.Errorf("invalid remoteTimeout: %s", rw.RemoteTimeout)
+				}
+				rwCfg.RemoteTimeout = model.Duration(timeout)
+			}
+			if url.Scheme == "https" {
+				rwCfg.TLSConfig = config_util.TLSConfig{InsecureSkipVerify: true}
+			}
+			if !reflect.DeepEqual(rw.HTTPClientConfig.BasicAuth, v1alpha1.BasicAuth{}) {
+				secret := &corev1.Secret{}
+				rwCfg.BasicAuth = &monitoringgateway.BasicAuth{}
+				if err := g.Client.Get(g.Context, client.ObjectKey{Name: rw.HTTPClientConfig.BasicAuth.Username.Name, Namespace: g.Service.Namespace}, secret); err != nil {
+					return nil, resources.OperationCreateOrUpdate, err
+				}
+				rwCfg.BasicAuth.Username = string(secret.Data[rw.HTTPClientConfig.BasicAuth.Username.Key])
+				if err := g.Client.Get(g.Context, client.ObjectKey{Name: rw.HTTPClientConfig.BasicAuth.Password.Name, Namespace: g.Service.Namespace}, secret); err != nil {
+					return nil, resources.OperationCreateOrUpdate, err
+				}
+				rwCfg.BasicAuth.Password = string(secret.Data[rw.HTTPClientConfig.BasicAuth.Password.Key])
+			}
+			if rw.HTTPClientConfig.BearerToken != "" {
+				rwCfg.BearerToken = string(rw.HTTPClientConfig.BearerToken)
+			}
+			for _, rc := range rw.WriteRelabelConfigs {
+				relabelCfg, err := relabelConfig(rc)
+				if err != nil {
+					return nil, resources.OperationCreateOrUpdate, fmt.Errorf("invalid write relabel config of remote write %s: %w", rw.URL, err)
+				}
+				rwCfg.WriteRelabelConfigs = append(rwCfg.WriteRelabelConfigs, relabelCfg)
+			}
+			rwCfg.AllowedTenants = rw.AllowedTenants
+			rwCfg.DeniedTenants = rw.DeniedTenants
+			rwsCfg = append(rwsCfg, rwCfg)
+		}
+	}
+	buff, err := yaml.Marshal(rwsCfg)
+	if err != nil {
+		return nil, resources.OperationCreateOrUpdate, err
+	}
+
+	secret.Data = map[string][]byte{
+		externalRemoteWritesConfigFile: buff,
+	}
+
+	return secret, resources.OperationCreateOrUpdate, ctrl.SetControllerReference(g.gateway, secret, g.Scheme)
+}
+
 func (g *Gateway) generateBuiltInBasicAuthUserSecret() error {
 
 	user := randomString(16)
@@ -1,6 +1,8 @@
 package gateway
 
 import (
+	"slices"
+
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -45,5 +47,28 @@ func (g *Gateway) service() (runtime.Object, resources.Operation, error) {
 		s.Spec.Ports = append(s.Spec.Ports, port)
 	}
 
+	otlpPort := corev1.ServicePort{
+		Protocol:   corev1.ProtocolTCP,
+		Name:       constants.OTLPGRPCPortName,
+		Port:       constants.OTLPGRPCPort,
+		TargetPort: intstr.FromInt(constants.OTLPGRPCPort),
+	}
+	if g.otlpGRPCEnabled() {
+		if !util.ReplaceInSlice(s.Spec.Ports, func(v interface{}) bool {
+			return v.(corev1.ServicePort).Name == otlpPort.Name
+		}, otlpPort) {
+			s.Spec.Ports = append(s.Spec.Ports, otlpPort)
+		}
+	} else {
+		s.Spec.Ports = slices.DeleteFunc(s.Spec.Ports, func(p corev1.ServicePort) bool {
+			return p.Name == otlpPort.Name
+		})
+	}
+
 	return s, resources.OperationCreateOrUpdate, ctrl.SetControllerReference(g.gateway, s, g.Scheme)
 }
+
+// otlpGRPCEnabled returns true if the gateway receives the OTLP metrics over gRPC.
+func (g *Gateway) otlpGRPCEnabled() bool {
+	return g.gateway != nil && g.gateway.Spec.OTLPGRPC != nil && g.gateway.Spec.OTLPGRPC.Enabled
+}
@@ -21,6 +21,9 @@ func New(reconciler resources.BaseReconciler, tenant *monitoringv1alpha1.Tenant)
 }
 
 func (t *Tenant) Reconcile() error {
+	if err := t.accessMode(); err != nil {
+		return err
+	}
 	if err := t.ingester(); err != nil {
 		return err
 	}
@@ -35,3 +38,16 @@ func (t *Tenant) Reconcile() error {
 	}
 	return nil
 }
+
+// accessMode updates the effective access mode of the tenant in the status, which defaults to active.
+func (t *Tenant) accessMode() error {
+	mode := t.tenant.Spec.AccessMode
+	if mode == "" {
+		mode = monitoringv1alpha1.TenantAccessModeActive
+	}
+	if t.tenant.Status.AccessMode == mode {
+		return nil
+	}
+	t.tenant.Status.AccessMode = mode
+	return t.Client.Status().Update(t.Context, t.tenant)
+}
@@ -22,6 +22,13 @@ const (
 	write       = "/api/v1/write"   // prometheus remote write endpoint
 	rules       = "/api/v1/rules"
 	alerts      = "/api/v1/alerts"
+	read        = "/api/v1/read" // prometheus remote read endpoint
+
+	buildInfo      = "/api/v1/status/buildinfo"
+	metadata       = "/api/v1/metadata"
+	queryExemplars = "/api/v1/query_exemplars"
+	formatQuery    = "/api/v1/format_query"
+	parseQuery     = "/api/v1/parse_query"
 )
 
 type Options struct {
@@ -64,9 +71,16 @@ func NewServer(logger log.Logger, opt *Options) *Server {
 	s.router.Get(labels, s.wrap())
 	s.router.Get(labelValues, s.wrap())
 	s.router.Get(rules, s.wrap())
-	// do provide /api/v1/alerts because thanos does not support alerts filtering as of v0.28.0
-	// please filtering alerts by /api/v1/rules
-	// s.router.Get(alerts, s.wrap(alerts))
+	s.router.Get(alerts, s.wrap())
+	s.router.Post(read, s.wrap())
+	s.router.Get(buildInfo, s.wrap())
+	s.router.Get(metadata, s.wrap())
+	s.router.Get(queryExemplars, s.wrap())
+	s.router.Post(queryExemplars, s.wrap())
+	s.router.Get(formatQuery, s.wrap())
+	s.router.Post(formatQuery, s.wrap())
+	s.router.Get(parseQuery, s.wrap())
+	s.router.Post(parseQuery, s.wrap())
 
 	s.router.Post(receive, s.wrap())
 	s.router.Post(otlp, s.wrap())
@@ -0,0 +1,49 @@
+package monitoringgateway
+
+import (
+	"fmt"
+)
+
+// AccessMode is the access mode of an admitted tenant, which restricts the requests of the tenant by verb.
+type AccessMode string
+
+const (
+	// AccessModeActive accepts both the reads and writes of the tenant.
+	AccessModeActive AccessMode = "active"
+	// AccessModeReadOnly rejects the writes of the tenant, e.g. during incidents.
+	AccessModeReadOnly AccessMode = "read-only"
+	// AccessModeWriteOnly rejects the reads of the tenant.
+	AccessModeWriteOnly AccessMode = "write-only"
+	// AccessModeSuspended rejects all requests of the tenant while its data are kept, e.g. during offboarding.
+	AccessModeSuspended AccessMode = "suspended"
+)
+
+// validate returns an error if the access mode is unknown, the empty mode is active.
+func (m AccessMode) validate() error {
+	switch m {
+	case "", AccessModeActive, AccessModeReadOnly, AccessModeWriteOnly, AccessModeSuspended:
+		return nil
+	}
+	return fmt.Errorf("unknown access mode %q", m)
+}
+
+// check returns an error if the requests of the verb to the tenant are rejected by the access mode.
+func (m AccessMode) check(tenant, verb string) error {
+	switch m {
+	case "", AccessModeActive:
+		return nil
+	case AccessModeReadOnly:
+		if verb == verbWrite {
+			return fmt.Errorf("tenant %s is read-only, writes are rejected", tenant)
+		}
+		return nil
+	case AccessModeWriteOnly:
+		if verb == verbRead {
+			return fmt.Errorf("tenant %s is write-only, reads are rejected", tenant)
+		}
+		return nil
+	case AccessModeSuspended:
+		return fmt.Errorf("tenant %s is suspended, all requests are rejected", tenant)
+	}
+	return fmt.Errorf("tenant %s has the unknown access mode %q", tenant, m)
+}
@@ -0,0 +1,87 @@
+package monitoringgateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAccessModes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantHeader:            "WHIZARD-TENANT",
+		TenantLabelName:         "tenant_id",
+		QueryProxy:              NewSingleHostReverseProxy(target, http.DefaultTransport),
+		RemoteWriteProxy:        NewSingleHostReverseProxy(target, http.DefaultTransport),
+		EnabledTenantsAdmission: true,
+	})
+	cfg, err := ParseConfig([]byte(`{"tenants":["active","ro","wo","suspended"],"accessModes":{"ro":"read-only","wo":"write-only","suspended":"suspended"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetAdmissionControlHandler(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path string) (int, string) {
+		req := httptest.NewRequest(method, path, strings.NewReader("up 1\n"))
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, req)
+		return rec.Code, strings.TrimSpace(rec.Body.String())
+	}
+
+	for _, tc := range []struct {
+		tenant       string
+		read, write  int
+		errorMessage string
+	}{
+		{tenant: "active", read: http.StatusOK, write: http.StatusOK},
+		{tenant: "ro", read: http.StatusOK, write: http.StatusForbidden, errorMessage: "tenant ro is read-only, writes are rejected"},
+		{tenant: "wo", read: http.StatusForbidden, write: http.StatusOK, errorMessage: "tenant wo is write-only, reads are rejected"},
+		{tenant: "suspended", read: http.StatusForbidden, write: http.StatusForbidden, errorMessage: "tenant suspended is suspended, all requests are rejected"},
+	} {
+		t.Run(tc.tenant, func(t *testing.T) {
+			code, body := do(http.MethodGet, "/"+tc.tenant+"/api/v1/query?query=up")
+			if code != tc.read {
+				t.Fatalf("expected read status %d, got %d: %s", tc.read, code, body)
+			}
+			if code == http.StatusForbidden && body != tc.errorMessage {
+				t.Fatalf("unexpected error message %q", body)
+			}
+			for _, path := range []string{"/api/v1/receive", "/api/v1/push/job/batch"} {
+				code, body := do(http.MethodPost, "/"+tc.tenant+path)
+				if code != tc.write {
+					t.Fatalf("expected write status %d of %s, got %d: %s", tc.write, path, code, body)
+				}
+				if code == http.StatusForbidden && body != tc.errorMessage {
+					t.Fatalf("unexpected error message %q", body)
+				}
+			}
+		})
+	}
+
+	t.Run("mode changed", func(t *testing.T) {
+		if err := h.SetAdmissionControlHandler(AdmissionControlConfig{Tenants: []string{"ro"}}); err != nil {
+			t.Fatal(err)
+		}
+		if code, body := do(http.MethodPost, "/ro/api/v1/receive"); code == http.StatusForbidden {
+			t.Fatalf("expected the writes of the reactivated tenant to be accepted: %s", body)
+		}
+		if code, _ := do(http.MethodGet, "/wo/api/v1/query?query=up"); code != http.StatusForbidden {
+			t.Fatalf("expected the removed tenant to be rejected, got %d", code)
+		}
+	})
+
+	t.Run("invalid mode", func(t *testing.T) {
+		if _, err := ParseConfig([]byte(`{"tenants":["t1"],"accessModes":{"t1":"readonly"}}`)); err == nil {
+			t.Fatal("expected an error for the unknown access mode")
+		}
+	})
+}
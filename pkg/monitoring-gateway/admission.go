@@ -0,0 +1,181 @@
+package monitoringgateway
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The rule names of the admission decisions which are not made by the rules of the configuration.
+const (
+	admissionRuleTenants = "tenants"
+	admissionRuleDefault = "default"
+)
+
+// AdmissionRule admits or denies the tenants matching a glob or regex pattern.
+type AdmissionRule struct {
+	// Name identifies the rule in the metrics, it defaults to the pattern of the rule.
+	Name string `json:"name,omitempty"`
+	// Glob is the pattern of the tenants, where * matches any sequence of characters and ? matches any single character, e.g. prod-*.
+	Glob string `json:"glob,omitempty"`
+	// Regex is the regular expression of the tenants, which is anchored at both ends.
+	Regex string `json:"regex,omitempty"`
+	// Deny denies the matching tenants instead of admitting them.
+	Deny bool `json:"deny,omitempty"`
+	// AccessMode is the access mode of the admitted tenants, which are active if empty.
+	AccessMode AccessMode `json:"accessMode,omitempty"`
+}
+
+// compiledAdmissionRule is an AdmissionRule whose pattern is compiled.
+type compiledAdmissionRule struct {
+	name   string
+	regex  *regexp.Regexp
+	access AccessMode
+}
+
+// admissionMatcher decides the admission of the tenants by the compiled configuration. The deny rules are
+// evaluated first, followed by the listed tenants and the allow rules in order.
+type admissionMatcher struct {
+	// names are the listed tenants in order.
+	names   []string
+	tenants map[string]AccessMode
+	deny    []compiledAdmissionRule
+	allow   []compiledAdmissionRule
+}
+
+// newAdmissionMatcher compiles the admission configuration.
+func newAdmissionMatcher(c AdmissionControlConfig) (*admissionMatcher, error) {
+	m := &admissionMatcher{names: c.Tenants, tenants: make(map[string]AccessMode, len(c.Tenants))}
+	for tenant, mode := range c.AccessModes {
+		if err := mode.validate(); err != nil {
+			return nil, errors.Wrapf(err, "tenant %s", tenant)
+		}
+	}
+	for _, tenant := range c.Tenants {
+		m.tenants[tenant] = c.AccessMode(tenant)
+	}
+
+	for i, rule := range c.Rules {
+		var pattern string
+		switch {
+		case rule.Glob != "" && rule.Regex != "":
+			return nil, fmt.Errorf("rule %d: only one of glob and regex is allowed", i)
+		case rule.Glob != "":
+			pattern = globToRegex(rule.Glob)
+		case rule.Regex != "":
+			pattern = rule.Regex
+		default:
+			return nil, fmt.Errorf("rule %d: either glob or regex is required", i)
+		}
+		regex, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, errors.Wrapf(err, "rule %d", i)
+		}
+		if err := rule.AccessMode.validate(); err != nil {
+			return nil, errors.Wrapf(err, "rule %d", i)
+		}
+		if rule.Deny && rule.AccessMode != "" {
+			return nil, fmt.Errorf("rule %d: the deny rules have no access mode", i)
+		}
+
+		compiled := compiledAdmissionRule{name: rule.name(), regex: regex, access: rule.AccessMode}
+		if compiled.access == "" {
+			compiled.access = AccessModeActive
+		}
+		if rule.Deny {
+			m.deny = append(m.deny, compiled)
+		} else {
+			m.allow = append(m.allow, compiled)
+		}
+	}
+	return m, nil
+}
+
+// name returns the name of the rule, or its pattern if it's not named.
+func (r AdmissionRule) name() string {
+	switch {
+	case r.Name != "":
+		return r.Name
+	case r.Glob != "":
+		return "glob:" + r.Glob
+	default:
+		return "regex:" + r.Regex
+	}
+}
+
+// globToRegex converts the glob pattern to a regular expression.
+func globToRegex(glob string) string {
+	var b strings.Builder
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return b.String()
+}
+
+// match returns the access mode of the tenant and the rule that decided it, or false if the tenant is denied.
+func (m *admissionMatcher) match(tenant string) (AccessMode, string, bool) {
+	for _, rule := range m.deny {
+		if rule.regex.MatchString(tenant) {
+			return "", rule.name, false
+		}
+	}
+	if mode, ok := m.tenants[tenant]; ok {
+		return mode, admissionRuleTenants, true
+	}
+	for _, rule := range m.allow {
+		if rule.regex.MatchString(tenant) {
+			return rule.access, rule.name, true
+		}
+	}
+	return "", admissionRuleDefault, false
+}
+
+// tenantsAdmission holds the admission matcher of the current configuration, which is replaced on updates.
+type tenantsAdmission struct {
+	matcher atomic.Pointer[admissionMatcher]
+
+	decisions *prometheus.CounterVec
+}
+
+func newTenantsAdmission(reg prometheus.Registerer) *tenantsAdmission {
+	return &tenantsAdmission{
+		decisions: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_tenant_admission_decisions_total",
+				Help: "Total number of the tenant admission decisions, labeled by the rule which decided and the decision, either allow or deny. The listed tenants are decided by the tenants rule, and the unmatched tenants by the default rule.",
+			},
+			[]string{"rule", "decision"},
+		),
+	}
+}
+
+// admit returns the access mode of the tenant, or an error if the tenant is not admitted.
+func (a *tenantsAdmission) admit(tenant string) (AccessMode, error) {
+	m := a.matcher.Load()
+	if m == nil {
+		return "", fmt.Errorf("tenant %s is not allowed to access", tenant)
+	}
+
+	mode, rule, ok := m.match(tenant)
+	if !ok {
+		a.decisions.WithLabelValues(rule, "deny").Inc()
+		if rule != admissionRuleDefault {
+			return "", fmt.Errorf("tenant %s is denied by the admission rule %s", tenant, rule)
+		}
+		return "", fmt.Errorf("tenant %s is not allowed to access", tenant)
+	}
+	a.decisions.WithLabelValues(rule, "allow").Inc()
+	return mode, nil
+}
@@ -0,0 +1,78 @@
+package monitoringgateway
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTenantsAdmission(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`{
+  "tenants": ["t1", "prod-legacy"],
+  "accessModes": {"t1": "read-only"},
+  "rules": [
+    {"glob": "prod-*"},
+    {"name": "staging", "regex": "staging-[0-9]+", "accessMode": "write-only"},
+    {"glob": "prod-legacy", "deny": true}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := newAdmissionMatcher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admission := newTenantsAdmission(prometheus.NewRegistry())
+	admission.matcher.Store(m)
+
+	for _, tc := range []struct {
+		tenant string
+		mode   AccessMode
+		rule   string
+		denied bool
+	}{
+		{tenant: "t1", mode: AccessModeReadOnly, rule: "tenants"},
+		{tenant: "prod-a", mode: AccessModeActive, rule: "glob:prod-*"},
+		// The deny rules take precedence over the listed tenants.
+		{tenant: "prod-legacy", rule: "glob:prod-legacy", denied: true},
+		{tenant: "staging-12", mode: AccessModeWriteOnly, rule: "staging"},
+		{tenant: "staging-x", rule: "default", denied: true},
+		// The patterns are anchored.
+		{tenant: "xprod-a", rule: "default", denied: true},
+	} {
+		t.Run(tc.tenant, func(t *testing.T) {
+			decision := "allow"
+			if tc.denied {
+				decision = "deny"
+			}
+			before := testutil.ToFloat64(admission.decisions.WithLabelValues(tc.rule, decision))
+
+			mode, err := admission.admit(tc.tenant)
+			if tc.denied != (err != nil) {
+				t.Fatalf("expected denied %v, got error %v", tc.denied, err)
+			}
+			if mode != tc.mode {
+				t.Fatalf("expected access mode %q, got %q", tc.mode, mode)
+			}
+			if n := testutil.ToFloat64(admission.decisions.WithLabelValues(tc.rule, decision)) - before; n != 1 {
+				t.Fatalf("expected the %s decision of rule %s to be counted once, got %v", decision, tc.rule, n)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, content := range []string{
+			`{"rules": [{"glob": "a*", "regex": "a.*"}]}`,
+			`{"rules": [{"name": "empty"}]}`,
+			`{"rules": [{"regex": "a("}]}`,
+			`{"rules": [{"glob": "a*", "deny": true, "accessMode": "read-only"}]}`,
+			`{"rules": [{"glob": "a*", "accessMode": "readonly"}]}`,
+		} {
+			if _, err := ParseConfig([]byte(content)); err == nil {
+				t.Fatalf("expected an error for %s", content)
+			}
+		}
+	})
+}
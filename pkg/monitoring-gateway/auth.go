@@ -1,14 +1,93 @@
 package monitoringgateway
 
 import (
-	"errors"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	errInvalidCert     = errors.New("invalid cert")
+	errUnauthenticated = errors.New("no valid credentials are provided")
 )
 
-var errInvalidCert = errors.New("invalid cert")
+const (
+	// allTenants in the tenants of an identity allows to access all tenants.
+	allTenants = "*"
 
+	defaultAPIKeyHeader        = "X-API-Key"
+	defaultOIDCUsernameClaim   = "sub"
+	defaultJWKSRefreshInterval = model.Duration(time.Hour)
+	// minJWKSRefetchInterval bounds how often the key set is fetched for tokens signed with an unknown key.
+	minJWKSRefetchInterval = 10 * time.Second
+)
+
+// oidcSignatureAlgorithms are the accepted signature algorithms of the OIDC tokens.
+var oidcSignatureAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// Identity is an authenticated requester, together with the tenants it's allowed to access.
+type Identity struct {
+	Name   string
+	UID    string
+	Groups []string
+	Extra  map[string][]string
+	// Tenants are the tenants the requester is allowed to access, "*" allows all tenants.
+	Tenants []string
+	// Authorizer decides the tenant access of the identity instead of the tenants if set.
+	Authorizer Authorizer
+}
+
+// AllowsTenant returns true if the identity is allowed to access the tenant.
+func (i *Identity) AllowsTenant(tenant string) bool {
+	for _, t := range i.Tenants {
+		if t == tenant || t == allTenants {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize returns true if the identity is allowed to access the tenant with the verb.
+func (i *Identity) authorize(ctx context.Context, tenant, verb string) (bool, error) {
+	if i.Authorizer != nil {
+		return i.Authorizer.Authorize(ctx, i, tenant, verb)
+	}
+	return i.AllowsTenant(tenant), nil
+}
+
+// Authorizer decides whether an identity is allowed to access a tenant with a verb, either read or write.
+type Authorizer interface {
+	Authorize(ctx context.Context, identity *Identity, tenant, verb string) (bool, error)
+}
+
+// Authenticator authenticates the requester of a request.
+// It returns false if the request doesn't carry the credentials it handles, so that the next authenticator
+// of the chain is tried, and an error if the credentials are invalid.
+type Authenticator interface {
+	AuthenticateRequest(req *http.Request) (*Identity, bool, error)
+}
+
+// CertAuthenticator authenticates the requests by the client certificate, whose common name is the tenant.
 type CertAuthenticator struct {
 }
 
@@ -16,51 +95,566 @@ func NewCertAuthenticator() *CertAuthenticator {
 	return &CertAuthenticator{}
 }
 
-func (cauth *CertAuthenticator) AuthenticateRequest(req *http.Request) (tenantId string, ok bool) {
+func (cauth *CertAuthenticator) AuthenticateRequest(req *http.Request) (*Identity, bool, error) {
 
-	if len(req.TLS.PeerCertificates) == 0 {
-		return "", false
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil, false, nil
 	}
 
-	return req.TLS.PeerCertificates[0].Subject.CommonName, true
+	cn := req.TLS.PeerCertificates[0].Subject.CommonName
+	if cn == "" {
+		return nil, false, errInvalidCert
+	}
+	return &Identity{Name: cn, Tenants: []string{cn}}, true, nil
 }
 
-func withAuthorization(f http.HandlerFunc, certAuthenticator *CertAuthenticator) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+// APIKeyAuthenticator authenticates the requests by static API keys.
+type APIKeyAuthenticator struct {
+	header string
+	// The identities are looked up by the hash of the keys, so that the lookup doesn't leak the keys by timing.
+	identities map[[sha256.Size]byte]*Identity
+}
+
+// NewAPIKeyAuthenticator creates an APIKeyAuthenticator, reading the keys from the key files if needed.
+func NewAPIKeyAuthenticator(cfg APIKeysAuthConfig) (*APIKeyAuthenticator, error) {
+	a := &APIKeyAuthenticator{
+		header:     cfg.Header,
+		identities: make(map[[sha256.Size]byte]*Identity, len(cfg.Keys)),
+	}
+	if a.header == "" {
+		a.header = defaultAPIKeyHeader
+	}
+
+	for _, k := range cfg.Keys {
+		key := string(k.Key)
+		if k.KeyFile != "" {
+			b, err := os.ReadFile(k.KeyFile)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading API key file of %s", k.Name)
+			}
+			key = strings.TrimSpace(string(b))
+		}
+		if key == "" {
+			return nil, errors.Errorf("API key of %s is empty", k.Name)
+		}
+		sum := sha256.Sum256([]byte(key))
+		if _, ok := a.identities[sum]; ok {
+			return nil, errors.Errorf("API key of %s is duplicated", k.Name)
+		}
+		a.identities[sum] = &Identity{Name: k.Name, Tenants: k.Tenants}
+	}
+	return a, nil
+}
+
+func (a *APIKeyAuthenticator) AuthenticateRequest(req *http.Request) (*Identity, bool, error) {
+	key := req.Header.Get(a.header)
+	if http.CanonicalHeaderKey(a.header) == "Authorization" {
+		var ok bool
+		if key, ok = bearerToken(req); !ok {
+			return nil, false, nil
+		}
+	}
+	if key == "" {
+		return nil, false, nil
+	}
+
+	id, ok := a.identities[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, false, errors.New("invalid API key")
+	}
+	return id, true, nil
+}
+
+// OIDCAuthenticator authenticates the requests by OIDC/JWT bearer tokens, verified with the key set of the issuer.
+// The tenants the requester is allowed to access are taken from a claim of the token.
+type OIDCAuthenticator struct {
+	cfg  OIDCAuthConfig
+	jwks *jwksCache
+}
+
+// NewOIDCAuthenticator creates an OIDCAuthenticator.
+// The key set is fetched on the first request, so that the issuer doesn't need to be available on startup.
+func NewOIDCAuthenticator(logger log.Logger, cfg OIDCAuthConfig) (*OIDCAuthenticator, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = defaultOIDCUsernameClaim
+	}
+	if cfg.JWKSRefreshInterval == 0 {
+		cfg.JWKSRefreshInterval = defaultJWKSRefreshInterval
+	}
 
-		ctx := req.Context()
+	client, err := config.NewClientFromConfig(config.HTTPClientConfig{TLSConfig: cfg.TLSConfig}, "oidc")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating OIDC client")
+	}
+
+	return &OIDCAuthenticator{
+		cfg: cfg,
+		jwks: &jwksCache{
+			logger:          logger,
+			client:          client,
+			issuerURL:       cfg.IssuerURL,
+			jwksURL:         cfg.JWKSURL,
+			refreshInterval: time.Duration(cfg.JWKSRefreshInterval),
+		},
+	}, nil
+}
+
+func (a *OIDCAuthenticator) AuthenticateRequest(req *http.Request) (*Identity, bool, error) {
+	token, ok := bearerToken(req)
+	if !ok {
+		return nil, false, nil
+	}
+	// Bearer tokens which are not JWTs, or which are issued by other issuers, are left to the next authenticators,
+	// e.g. API keys or Kubernetes tokens.
+	tok, err := jwt.ParseSigned(token, oidcSignatureAlgorithms)
+	if err != nil {
+		return nil, false, nil
+	}
+	var unverified jwt.Claims
+	if err := tok.UnsafeClaimsWithoutVerification(&unverified); err != nil || unverified.Issuer != a.cfg.IssuerURL {
+		return nil, false, nil
+	}
+
+	var kid string
+	if len(tok.Headers) > 0 {
+		kid = tok.Headers[0].KeyID
+	}
+	key, err := a.jwks.key(req.Context(), kid)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var (
+		claims jwt.Claims
+		raw    map[string]interface{}
+	)
+	if err := tok.Claims(key, &claims, &raw); err != nil {
+		return nil, false, errors.Wrap(err, "verifying token")
+	}
+	if err := claims.ValidateWithLeeway(jwt.Expected{
+		Issuer:      a.cfg.IssuerURL,
+		AnyAudience: a.cfg.Audiences,
+		Time:        time.Now(),
+	}, jwt.DefaultLeeway); err != nil {
+		return nil, false, errors.Wrap(err, "validating token")
+	}
+
+	name, _ := claimValue(raw, a.cfg.UsernameClaim).(string)
+	tenants, err := claimStrings(claimValue(raw, a.cfg.TenantsClaim))
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "invalid claim %s", a.cfg.TenantsClaim)
+	}
+	return &Identity{Name: name, Tenants: tenants}, true, nil
+}
 
-		tenantId, ok := certAuthenticator.AuthenticateRequest(req)
+// claimValue returns the value of a claim, nested claims are addressed by a dot separated path.
+func claimValue(claims map[string]interface{}, name string) interface{} {
+	if v, ok := claims[name]; ok {
+		return v
+	}
+	var v interface{} = claims
+	for _, part := range strings.Split(name, ".") {
+		m, ok := v.(map[string]interface{})
 		if !ok {
-			http.Error(w, errInvalidCert.Error(), http.StatusUnauthorized)
+			return nil
+		}
+		if v, ok = m[part]; !ok {
+			return nil
 		}
+	}
+	return v
+}
 
-		requestInfo, found := requestInfoFrom(ctx)
+// claimStrings converts a string or string array claim to a list of strings.
+func claimStrings(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected value %v", e)
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unexpected value %v", v)
+	}
+}
+
+// jwksCache fetches and caches the JSON web key set of an OIDC issuer. The key set is fetched by one refresh at a
+// time outside of the lock, while the cached keys keep being served.
+type jwksCache struct {
+	logger          log.Logger
+	client          *http.Client
+	issuerURL       string
+	jwksURL         string
+	refreshInterval time.Duration
+
+	mtx       sync.Mutex
+	keys      *jose.JSONWebKeySet
+	fetchedAt time.Time
+	// refreshing is closed once the running refresh is done, it's nil if there is no refresh.
+	refreshing chan struct{}
+}
+
+// key returns the key with the given id. The key set is refreshed in the background when it's outdated, and the
+// requests wait for the refresh only when there are no keys yet, or when the key is unknown, as the issuer may
+// have rotated its keys.
+func (c *jwksCache) key(ctx context.Context, kid string) (*jose.JSONWebKey, error) {
+	c.mtx.Lock()
+	if c.keys == nil {
+		c.mtx.Unlock()
+		if err := c.wait(ctx); err != nil {
+			return nil, err
+		}
+		c.mtx.Lock()
+	} else if time.Since(c.fetchedAt) > c.refreshInterval {
+		c.refresh()
+	}
+	k := c.lookup(kid)
+	refetch := k == nil && c.keys != nil && time.Since(c.fetchedAt) > minJWKSRefetchInterval
+	c.mtx.Unlock()
+	if k != nil {
+		return k, nil
+	}
+
+	if refetch {
+		if err := c.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if k := c.lookup(kid); k != nil {
+		return k, nil
+	}
+	if c.keys == nil {
+		return nil, errors.New("the key set of the issuer is not available")
+	}
+	return nil, errors.Errorf("unknown signing key %q", kid)
+}
+
+// wait starts a refresh unless one is running, and waits until it's done.
+func (c *jwksCache) wait(ctx context.Context) error {
+	c.mtx.Lock()
+	done := c.refresh()
+	c.mtx.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "waiting for the key set of the issuer")
+	}
+}
+
+func (c *jwksCache) lookup(kid string) *jose.JSONWebKey {
+	if c.keys == nil {
+		return nil
+	}
+	if kid == "" {
+		if len(c.keys.Keys) == 1 {
+			return &c.keys.Keys[0]
+		}
+		return nil
+	}
+	if keys := c.keys.Key(kid); len(keys) > 0 {
+		return &keys[0]
+	}
+	return nil
+}
+
+// refresh starts fetching the key set unless a refresh is running, and returns a chan which is closed once it's
+// done. The previously fetched keys are kept if it fails. The lock must be held.
+func (c *jwksCache) refresh() <-chan struct{} {
+	if c.refreshing != nil {
+		return c.refreshing
+	}
+	c.fetchedAt = time.Now()
+	done := make(chan struct{})
+	c.refreshing = done
+
+	go func() {
+		// The fetch isn't bound to the request which started it, as the other requests may wait for it.
+		keys, err := c.fetch(context.Background())
+
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		if err != nil {
+			level.Warn(c.logger).Log("msg", "failed to fetch the OIDC key set", "issuer", c.issuerURL, "err", err)
+		} else {
+			c.keys = keys
+		}
+		c.refreshing = nil
+		close(done)
+	}()
+	return done
+}
+
+func (c *jwksCache) fetch(ctx context.Context) (*jose.JSONWebKeySet, error) {
+	jwksURL := c.jwksURL
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := c.get(ctx, strings.TrimSuffix(c.issuerURL, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, errors.Wrap(err, "discovering the OIDC provider")
+		}
+		if discovery.JWKSURI == "" {
+			return nil, errors.New("the OIDC provider has no jwks_uri")
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var keys jose.JSONWebKeySet
+	if err := c.get(ctx, jwksURL, &keys); err != nil {
+		return nil, errors.Wrap(err, "fetching the key set")
+	}
+	return &keys, nil
+}
+
+func (c *jwksCache) get(ctx context.Context, url string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected status code %d from %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// bearerToken returns the bearer token of the Authorization header.
+func bearerToken(req *http.Request) (string, bool) {
+	auth := req.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(auth[7:])
+	return token, token != ""
+}
+
+// AuthConfig is the configuration of the authenticator chain.
+// The authenticators are tried in order, the first one recognizing the credentials of a request authenticates it.
+type AuthConfig struct {
+	Authenticators []AuthenticatorConfig `yaml:"authenticators"`
+}
+
+// AuthenticatorConfig configures one authenticator of the chain, exactly one of the fields must be set.
+type AuthenticatorConfig struct {
+	// MTLS authenticates the requests by the client certificate, whose common name is the tenant.
+	MTLS *MTLSAuthConfig `yaml:"mtls,omitempty"`
+	// OIDC authenticates the requests by OIDC/JWT bearer tokens.
+	OIDC *OIDCAuthConfig `yaml:"oidc,omitempty"`
+	// APIKeys authenticates the requests by static API keys.
+	APIKeys *APIKeysAuthConfig `yaml:"api_keys,omitempty"`
+	// Kubernetes authenticates the requests by Kubernetes tokens with the TokenReview API,
+	// and authorizes the tenant access with SubjectAccessReviews.
+	Kubernetes *KubernetesAuthConfig `yaml:"kubernetes,omitempty"`
+}
+
+type MTLSAuthConfig struct {
+}
+
+type OIDCAuthConfig struct {
+	// IssuerURL is the expected issuer of the tokens. The key set is discovered from it if no JWKSURL is set.
+	IssuerURL string `yaml:"issuer_url"`
+	// JWKSURL is the URL of the key set the tokens are verified with.
+	JWKSURL string `yaml:"jwks_url,omitempty"`
+	// Audiences are the accepted audiences of the tokens. The audience isn't verified if empty.
+	Audiences []string `yaml:"audiences,omitempty"`
+	// UsernameClaim is the claim identifying the requester, defaults to "sub".
+	UsernameClaim string `yaml:"username_claim,omitempty"`
+	// TenantsClaim is the claim listing the tenants the requester is allowed to access, either a string or an array of strings.
+	// Nested claims are addressed by a dot separated path.
+	TenantsClaim string `yaml:"tenants_claim"`
+	// JWKSRefreshInterval is the interval the key set is refreshed at, defaults to 1h.
+	// Tokens signed with an unknown key trigger an earlier refresh.
+	JWKSRefreshInterval model.Duration `yaml:"jwks_refresh_interval,omitempty"`
+	// TLSConfig to use to connect to the issuer.
+	TLSConfig config.TLSConfig `yaml:"tls_config,omitempty"`
+}
+
+type APIKeysAuthConfig struct {
+	// Header is the request header carrying the key, defaults to "X-API-Key".
+	// If set to "Authorization", the key is taken from the bearer token.
+	Header string   `yaml:"header,omitempty"`
+	Keys   []APIKey `yaml:"keys"`
+}
+
+type APIKey struct {
+	Name    string        `yaml:"name"`
+	Key     config.Secret `yaml:"key,omitempty"`
+	KeyFile string        `yaml:"key_file,omitempty"`
+	// Tenants are the tenants the key is allowed to access, "*" allows all tenants.
+	Tenants []string `yaml:"tenants"`
+}
+
+type KubernetesAuthConfig struct {
+	// Kubeconfig is the path of the kubeconfig file, the in-cluster configuration is used if empty.
+	Kubeconfig string `yaml:"kubeconfig,omitempty"`
+	// Audiences are the accepted audiences of the tokens. The audiences of the API server are assumed if empty.
+	Audiences []string `yaml:"audiences,omitempty"`
+	// CacheTTL is the duration the token and access reviews are cached for, defaults to 1m.
+	CacheTTL model.Duration `yaml:"cache_ttl,omitempty"`
+}
+
+// ParseAuthConfig parses the raw authentication configuration content.
+func ParseAuthConfig(content []byte) (AuthConfig, error) {
+	var cfg AuthConfig
+	if err := yaml.UnmarshalStrict(content, &cfg); err != nil {
+		return AuthConfig{}, errors.Wrap(err, "parsing auth config YAML")
+	}
+
+	for i, a := range cfg.Authenticators {
+		var n int
+		for _, set := range []bool{a.MTLS != nil, a.OIDC != nil, a.APIKeys != nil, a.Kubernetes != nil} {
+			if set {
+				n++
+			}
+		}
+		if n != 1 {
+			return AuthConfig{}, errors.Errorf("authenticator %d: exactly one of mtls, oidc, api_keys and kubernetes must be set", i)
+		}
+		if a.OIDC != nil && (a.OIDC.IssuerURL == "" || a.OIDC.TenantsClaim == "") {
+			return AuthConfig{}, errors.Errorf("authenticator %d: issuer_url and tenants_claim are required for oidc", i)
+		}
+		if a.APIKeys != nil {
+			for _, k := range a.APIKeys.Keys {
+				if k.Name == "" || (k.Key == "") == (k.KeyFile == "") {
+					return AuthConfig{}, errors.Errorf("authenticator %d: API keys require a name, and either key or key_file", i)
+				}
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// NewAuthenticators creates the authenticator chain of the configuration.
+func NewAuthenticators(logger log.Logger, cfg AuthConfig) ([]Authenticator, error) {
+	var authenticators []Authenticator
+	for _, a := range cfg.Authenticators {
+		switch {
+		case a.MTLS != nil:
+			authenticators = append(authenticators, NewCertAuthenticator())
+		case a.OIDC != nil:
+			oidc, err := NewOIDCAuthenticator(log.With(logger, "authenticator", "oidc"), *a.OIDC)
+			if err != nil {
+				return nil, err
+			}
+			authenticators = append(authenticators, oidc)
+		case a.APIKeys != nil:
+			apiKeys, err := NewAPIKeyAuthenticator(*a.APIKeys)
+			if err != nil {
+				return nil, err
+			}
+			authenticators = append(authenticators, apiKeys)
+		case a.Kubernetes != nil:
+			client, err := newKubernetesClient(a.Kubernetes.Kubeconfig)
+			if err != nil {
+				return nil, err
+			}
+			authenticators = append(authenticators, NewKubernetesAuthenticator(client, *a.Kubernetes))
+		}
+	}
+	return authenticators, nil
+}
+
+// withAuthentication runs the authenticator chain on the request, and rejects it if no authenticator recognizes
+// the credentials, or if the authenticated requester is not allowed to access the tenant of the request.
+func withAuthentication(f http.HandlerFunc, authenticators []Authenticator, authentications func(result string)) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		requestInfo, found := requestInfoFrom(req.Context())
 		if !found {
-			http.Error(w, errInvalidCert.Error(), http.StatusUnauthorized)
+			authentications("unauthenticated")
+			http.Error(w, errUnauthenticated.Error(), http.StatusUnauthorized)
+			return
 		}
 
-		if tenantId != requestInfo.TenantId {
-			http.Error(w, errInvalidCert.Error(), http.StatusUnauthorized)
+		for _, authenticator := range authenticators {
+			identity, ok, err := authenticator.AuthenticateRequest(req)
+			if err != nil {
+				authentications("unauthenticated")
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if !ok {
+				continue
+			}
+
+			if requestInfo.resolveTenants != nil {
+				if err := requestInfo.resolveTenants(identity); err != nil {
+					authentications("authenticated")
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+
+			// The requester must be allowed to access all tenants of a multi-tenant read.
+			for _, tenant := range requestInfo.Tenants {
+				allowed, err := identity.authorize(req.Context(), tenant, requestInfo.Verb)
+				if err != nil {
+					authentications("error")
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if !allowed {
+					authentications("forbidden")
+					http.Error(w, fmt.Sprintf("%s is not allowed to %s tenant %s", identity.Name, requestInfo.Verb, tenant), http.StatusForbidden)
+					return
+				}
+			}
+			authentications("authenticated")
+			requestInfo.Identity = identity
+			f.ServeHTTP(w, req)
+			return
 		}
 
-		f.ServeHTTP(w, req)
+		authentications("unauthenticated")
+		http.Error(w, errUnauthenticated.Error(), http.StatusUnauthorized)
 	})
 }
 
-func withTenantsAdmission(f http.HandlerFunc, tenantsAdmissionMap *sync.Map, enable bool) http.HandlerFunc {
+func withTenantsAdmission(f http.HandlerFunc, admission *tenantsAdmission, enable bool) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 
 		requestInfo, found := requestInfoFrom(req.Context())
-		if !found || requestInfo.TenantId == "" {
+		if !found || len(requestInfo.Tenants) == 0 {
 			http.NotFound(w, req)
 			return
 		}
 		if enable {
-			if _, ok := tenantsAdmissionMap.Load(requestInfo.TenantId); !ok {
-				err := fmt.Errorf("tenant %s is not allowed to access", requestInfo.TenantId)
-				http.Error(w, err.Error(), http.StatusForbidden)
-				return
+			for _, tenant := range requestInfo.Tenants {
+				mode, err := admission.admit(tenant)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
+				// The admitted tenants are restricted by their access modes per verb.
+				if err := mode.check(tenant, requestInfo.Verb); err != nil {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
 			}
 		}
 
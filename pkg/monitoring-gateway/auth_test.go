@@ -0,0 +1,264 @@
+package monitoringgateway
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+func TestAuthenticatorChain(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var issuer string
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"jwks_uri": issuer + "/keys"})
+		case "/keys":
+			_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key.Public(), KeyID: "k1", Algorithm: string(jose.RS256), Use: "sig"}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer idp.Close()
+	issuer = idp.URL
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := func(iss string, expiry time.Time, tenants interface{}) string {
+		tok, err := jwt.Signed(signer).Claims(jwt.Claims{
+			Issuer:   iss,
+			Subject:  "alice",
+			Audience: jwt.Audience{"whizard"},
+			Expiry:   jwt.NewNumericDate(expiry),
+		}).Claims(map[string]interface{}{"whizard": map[string]interface{}{"tenants": tenants}}).Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+
+	cfg, err := ParseAuthConfig([]byte(`
+authenticators:
+- mtls: {}
+- oidc:
+    issuer_url: ` + issuer + `
+    audiences: [whizard]
+    tenants_claim: whizard.tenants
+- api_keys:
+    keys:
+    - name: grafana
+      key: secret
+      tenants: [t1, t2]
+    - name: admin
+      key: admin-secret
+      tenants: ["*"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticators, err := NewAuthenticators(nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	var identity *Identity
+	router.Path(apiTenantPrefix + epQuery).HandlerFunc(withRequestInfo(withAuthentication(func(w http.ResponseWriter, req *http.Request) {
+		info, _ := requestInfoFrom(req.Context())
+		identity = info.Identity
+	}, authenticators, func(string) {}), defaultTenantResolver))
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "t3"}}
+
+	for _, tc := range []struct {
+		name     string
+		tenant   string
+		setup    func(req *http.Request)
+		code     int
+		identity string
+	}{
+		{name: "no credentials", tenant: "t1", code: http.StatusUnauthorized},
+		{
+			name:   "client certificate",
+			tenant: "t3",
+			setup: func(req *http.Request) {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+			},
+			code:     http.StatusOK,
+			identity: "t3",
+		},
+		{
+			name:   "client certificate of another tenant",
+			tenant: "t1",
+			setup: func(req *http.Request) {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+			},
+			code: http.StatusForbidden,
+		},
+		{
+			name:     "api key",
+			tenant:   "t2",
+			setup:    func(req *http.Request) { req.Header.Set("X-API-Key", "secret") },
+			code:     http.StatusOK,
+			identity: "grafana",
+		},
+		{
+			name:     "api key with multiple tenants",
+			tenant:   "t1|t2",
+			setup:    func(req *http.Request) { req.Header.Set("X-API-Key", "secret") },
+			code:     http.StatusOK,
+			identity: "grafana",
+		},
+		{
+			name:   "api key with a tenant not allowed of multiple tenants",
+			tenant: "t1|t3",
+			setup:  func(req *http.Request) { req.Header.Set("X-API-Key", "secret") },
+			code:   http.StatusForbidden,
+		},
+		{
+			name:     "api key allowing all tenants",
+			tenant:   "t9",
+			setup:    func(req *http.Request) { req.Header.Set("X-API-Key", "admin-secret") },
+			code:     http.StatusOK,
+			identity: "admin",
+		},
+		{
+			name:   "invalid api key",
+			tenant: "t1",
+			setup:  func(req *http.Request) { req.Header.Set("X-API-Key", "wrong") },
+			code:   http.StatusUnauthorized,
+		},
+		{
+			name:   "oidc token",
+			tenant: "t1",
+			setup: func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer "+token(issuer, time.Now().Add(time.Hour), []string{"t1"}))
+			},
+			code:     http.StatusOK,
+			identity: "alice",
+		},
+		{
+			name:   "oidc token of another tenant",
+			tenant: "t2",
+			setup: func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer "+token(issuer, time.Now().Add(time.Hour), "t1"))
+			},
+			code: http.StatusForbidden,
+		},
+		{
+			name:   "expired oidc token",
+			tenant: "t1",
+			setup: func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer "+token(issuer, time.Now().Add(-time.Hour), []string{"t1"}))
+			},
+			code: http.StatusUnauthorized,
+		},
+		{
+			name:   "oidc token of another issuer",
+			tenant: "t1",
+			setup: func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer "+token("https://example.com", time.Now().Add(time.Hour), []string{"t1"}))
+			},
+			code: http.StatusUnauthorized,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			identity = nil
+			req := httptest.NewRequest(http.MethodGet, "/"+tc.tenant+"/api/v1/query", nil)
+			if tc.setup != nil {
+				tc.setup(req)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.code {
+				t.Fatalf("expected status %d, got %d: %s", tc.code, rec.Code, rec.Body.String())
+			}
+			if tc.identity != "" && (identity == nil || identity.Name != tc.identity) {
+				t.Fatalf("expected identity %s, got %v", tc.identity, identity)
+			}
+		})
+	}
+}
+
+func TestJWKSCacheRefresh(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The refreshes after the first fetch are blocked until released.
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key.Public(), KeyID: "k1", Algorithm: string(jose.RS256), Use: "sig"}}})
+	}))
+	defer idp.Close()
+
+	c := &jwksCache{logger: log.NewNopLogger(), client: idp.Client(), jwksURL: idp.URL, refreshInterval: time.Minute}
+	if _, err := c.key(context.Background(), "k1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cached keys are served while the outdated key set is refreshed.
+	c.mtx.Lock()
+	c.fetchedAt = time.Now().Add(-time.Hour)
+	c.mtx.Unlock()
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := c.key(ctx, "k1")
+		cancel()
+		if err != nil {
+			t.Fatalf("expected the cached key during the refresh: %v", err)
+		}
+	}
+
+	// The requests of an unknown key wait for the running refresh, while they don't start another one.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	c.mtx.Lock()
+	c.fetchedAt = time.Now().Add(-time.Hour)
+	c.mtx.Unlock()
+	if _, err := c.key(ctx, "k2"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the request to wait for the refresh, got %v", err)
+	}
+	close(release)
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("expected 2 fetches, got %d", n)
+	}
+}
+
+func TestParseAuthConfig(t *testing.T) {
+	for _, content := range []string{
+		"authenticators: [{}]",
+		"authenticators: [{mtls: {}, api_keys: {keys: []}}]",
+		"authenticators: [{oidc: {issuer_url: https://example.com}}]",
+		"authenticators: [{api_keys: {keys: [{name: a, key: k, key_file: f}]}}]",
+	} {
+		if _, err := ParseAuthConfig([]byte(content)); err == nil {
+			t.Fatalf("expected error for %s", content)
+		}
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
 
 	"gopkg.in/yaml.v2"
@@ -20,6 +22,18 @@ type ExternalRemoteWriteConfig struct {
 	URL           *config.URL       `yaml:"url"`
 	Headers       map[string]string `yaml:"headers,omitempty"`
 	RemoteTimeout model.Duration    `yaml:"remote_timeout,omitempty"`
+	// ProtobufMessage is the remote write protobuf message sent to the target, one of
+	// "prometheus.WriteRequest" (remote write 1.0, default) or "io.prometheus.write.v2.Request" (remote write 2.0).
+	// The received requests are transcoded if needed.
+	ProtobufMessage promconfig.RemoteWriteProtoMsg `yaml:"protobuf_message,omitempty"`
+	// QueueConfig configures the durable queue the requests are forwarded through.
+	QueueConfig QueueConfig `yaml:"queue_config,omitempty"`
+	// WriteRelabelConfigs are applied to the series before sending them to the target.
+	WriteRelabelConfigs []*relabel.Config `yaml:"write_relabel_configs,omitempty"`
+	// AllowedTenants are the tenants whose requests are sent to the target. All tenants are allowed if empty.
+	AllowedTenants []string `yaml:"allowed_tenants,omitempty"`
+	// DeniedTenants are the tenants whose requests are never sent to the target.
+	DeniedTenants []string `yaml:"denied_tenants,omitempty"`
 
 	// The HTTP basic authentication credentials for the targets.
 	BasicAuth *BasicAuth `yaml:"basic_auth,omitempty" json:"basic_auth,omitempty"`
@@ -51,12 +65,19 @@ func RegisterHTTPFlags(cmd extkingpin.FlagClause) (httpBindAddr *string, httpGra
 type QueryConfig struct {
 	DownstreamURL string
 
+	// MaxConcurrency is the maximum number of running query requests of all tenants, zero is unlimited.
+	MaxConcurrency int
+	// QueueTimeout is the maximum time of the query requests in the queue, zero is unlimited.
+	QueueTimeout *model.Duration
+
 	DownstreamTripperConfig
 }
 
 func (qc *QueryConfig) RegisterFlag(cmd extflag.FlagClause) *QueryConfig {
 	cmd.Flag("query.address", "Addresses of statically configured query API servers (repeatable). The scheme may be prefixed with 'dns+' or 'dnssrv+' to detect query API servers through respective DNS lookups.").
 		PlaceHolder("<query>").StringVar(&qc.DownstreamURL)
+	cmd.Flag("query.max-concurrency", "Maximum number of running query requests of all tenants, the requests over it are queued fairly by tenant. Zero is unlimited.").Default("0").IntVar(&qc.MaxConcurrency)
+	qc.QueueTimeout = extkingpin.ModelDuration(cmd.Flag("query.queue-timeout", "Maximum time of the query requests in the queue, they are rejected once it's exceeded. Zero is unlimited.").Default("1m"))
 	qc.DownstreamTripperConfig.TripperPathOrContent = *extflag.RegisterPathOrContent(cmd, "query.config", "YAML file that contains downstream tripper configuration. If your downstream URL is localhost or 127.0.0.1 then it is highly recommended to increase max_idle_conns_per_host to at least 100.", extflag.WithEnvSubstitution())
 
 	return qc
@@ -80,6 +101,7 @@ func (rc *RulesQueryConfig) RegisterFlag(cmd extflag.FlagClause) *RulesQueryConf
 type RemoteWriteConfig struct {
 	DownstreamURL string
 	DownstreamTripperConfig
+	MaxRequestSize int64
 }
 
 func (rwc *RemoteWriteConfig) RegisterFlag(cmd extflag.FlagClause) *RemoteWriteConfig {
@@ -87,10 +109,72 @@ func (rwc *RemoteWriteConfig) RegisterFlag(cmd extflag.FlagClause) *RemoteWriteC
 		PlaceHolder("<query>").StringVar(&rwc.DownstreamURL)
 
 	rwc.TripperPathOrContent = *extflag.RegisterPathOrContent(cmd, "remote-write.config", "YAML file that contains downstream tripper configuration. If your downstream URL is localhost or 127.0.0.1 then it is highly recommended to increase max_idle_conns_per_host to at least 100.", extflag.WithEnvSubstitution())
+	cmd.Flag("remote-write.max-request-size", "Maximum size in bytes of the write request bodies, and of the OTLP request bodies once decompressed. The requests over it are rejected with 413. Zero is unlimited.").Default("104857600").Int64Var(&rwc.MaxRequestSize)
 
 	return rwc
 }
 
+// HATrackerFlagConfig is the flag configuration of the HA tracker.
+type HATrackerFlagConfig struct {
+	Enabled         bool
+	ClusterLabel    string
+	ReplicaLabel    string
+	FailoverTimeout *model.Duration
+	UpdateTimeout   *model.Duration
+	// Store is the store of the elected replicas, either memory or lease.
+	Store          string
+	LeaseNamespace string
+	Kubeconfig     string
+}
+
+func (hc *HATrackerFlagConfig) RegisterFlag(cmd extflag.FlagClause) *HATrackerFlagConfig {
+	cmd.Flag("ha-tracker.enabled", "If true, the remote write requests of the replicas of HA clusters, e.g. HA pairs of Prometheus, are deduplicated by accepting the samples of an elected replica per cluster only.").Default("false").BoolVar(&hc.Enabled)
+	cmd.Flag("ha-tracker.cluster-label", "Label identifying the HA cluster of the series.").Default(DefaultHAClusterLabel).StringVar(&hc.ClusterLabel)
+	cmd.Flag("ha-tracker.replica-label", "Label identifying the replica of the series in the HA cluster, it's removed from the accepted series.").Default(DefaultHAReplicaLabel).StringVar(&hc.ReplicaLabel)
+	hc.FailoverTimeout = extkingpin.ModelDuration(cmd.Flag("ha-tracker.failover-timeout", "Time after the last accepted request of the elected replica, another replica is elected after.").Default("30s"))
+	hc.UpdateTimeout = extkingpin.ModelDuration(cmd.Flag("ha-tracker.update-timeout", "Interval the received time of the elected replica is updated in the store at, it must be lower than 'ha-tracker.failover-timeout'.").Default("15s"))
+	cmd.Flag("ha-tracker.store", "Store of the elected replicas, either memory or lease. The lease store shares them across the gateway replicas by Kubernetes Leases.").Default(HAStoreMemory).EnumVar(&hc.Store, HAStoreMemory, HAStoreLease)
+	cmd.Flag("ha-tracker.lease-namespace", "Namespace of the Leases of the lease store.").Envar("POD_NAMESPACE").StringVar(&hc.LeaseNamespace)
+	cmd.Flag("ha-tracker.kubeconfig", "Path of the kubeconfig file of the lease store, the in-cluster configuration is used if empty.").StringVar(&hc.Kubeconfig)
+
+	return hc
+}
+
+// TenantWatcherFlagConfig is the flag configuration of the TenantWatcher.
+type TenantWatcherFlagConfig struct {
+	Enabled    bool
+	Selector   string
+	Kubeconfig string
+}
+
+func (tc *TenantWatcherFlagConfig) RegisterFlag(cmd extflag.FlagClause) *TenantWatcherFlagConfig {
+	cmd.Flag("tenant.admission-control-kubernetes", "If true, the admitted tenants are watched from the Tenant resources of Kubernetes instead of the configuration file, which admits the new tenants without the sync delay of the mounted ConfigMaps.").Default("false").BoolVar(&tc.Enabled)
+	cmd.Flag("tenant.admission-control-kubernetes-selector", "Label selector of the Tenant resources admitted, e.g. monitoring.whizard.io/service=<namespace>.<name>. All tenants are admitted if empty.").Default("").StringVar(&tc.Selector)
+	cmd.Flag("tenant.admission-control-kubeconfig", "Path of the kubeconfig file the Tenant resources are watched with, the in-cluster configuration is used if empty.").StringVar(&tc.Kubeconfig)
+
+	return tc
+}
+
+// OTLPGRPCConfig is the flag configuration of the OTLP/gRPC receiver.
+type OTLPGRPCConfig struct {
+	// BindAddress is the listen address of the receiver, it's disabled if empty.
+	BindAddress string
+	GracePeriod *model.Duration
+	TLSCert     string
+	TLSKey      string
+	TLSClientCA string
+}
+
+func (oc *OTLPGRPCConfig) RegisterFlag(cmd extflag.FlagClause) *OTLPGRPCConfig {
+	cmd.Flag("otlp.grpc-address", "Listen host:port of the OTLP/gRPC metrics receiver, e.g. 0.0.0.0:4317. The receiver is disabled if empty.").Default("").StringVar(&oc.BindAddress)
+	oc.GracePeriod = extkingpin.ModelDuration(cmd.Flag("otlp.grpc-grace-period", "Time to wait after an interrupt received for the OTLP/gRPC receiver.").Default("2m"))
+	cmd.Flag("otlp.grpc-server-tls-cert", "TLS certificate of the OTLP/gRPC receiver, TLS is disabled if empty.").Default("").StringVar(&oc.TLSCert)
+	cmd.Flag("otlp.grpc-server-tls-key", "TLS key of the OTLP/gRPC receiver.").Default("").StringVar(&oc.TLSKey)
+	cmd.Flag("otlp.grpc-server-tls-client-ca", "TLS CA to verify the client certificates of the OTLP/gRPC receiver against, which the cert tenant source resolves the tenants from. The client certificates are not verified if empty.").Default("").StringVar(&oc.TLSClientCA)
+
+	return oc
+}
+
 // DownstreamTripperConfig stores the http.Transport configuration for query's HTTP downstream tripper.
 type DownstreamTripperConfig struct {
 	IdleConnTimeout       model.Duration          `yaml:"idle_conn_timeout"`
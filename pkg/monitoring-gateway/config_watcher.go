@@ -8,9 +8,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/pkg/errors"
@@ -28,26 +26,38 @@ var (
 
 type AdmissionControlConfig struct {
 	Tenants []string `json:"tenants,omitempty"`
+	// AccessModes are the access modes of the tenants, the tenants without access modes are active.
+	AccessModes map[string]AccessMode `json:"accessModes,omitempty"`
+	// Rules admit or deny the tenants by patterns. The deny rules take precedence over the listed tenants,
+	// and the allow rules are evaluated in order for the tenants not listed.
+	Rules []AdmissionRule `json:"rules,omitempty"`
+}
+
+// AccessMode returns the access mode of the tenant.
+func (c AdmissionControlConfig) AccessMode(tenant string) AccessMode {
+	if mode, ok := c.AccessModes[tenant]; ok && mode != "" {
+		return mode
+	}
+	return AccessModeActive
+}
+
+// Validate returns an error if the configuration is not valid.
+func (c AdmissionControlConfig) Validate() error {
+	_, err := newAdmissionMatcher(c)
+	return err
 }
 
 // ConfigWatcher is able to watch a file containing a configuration
 // for updates.
 type ConfigWatcher struct {
-	ch       chan AdmissionControlConfig
-	path     string
-	interval time.Duration
-	logger   log.Logger
-	watcher  *fsnotify.Watcher
-
-	successGauge         prometheus.Gauge
-	lastSuccessTimeGauge prometheus.Gauge
-	changesCounter       prometheus.Counter
-	errorCounter         prometheus.Counter
-	refreshCounter       prometheus.Counter
-	tenantsGauge         prometheus.Gauge
-
-	// lastLoadedConfigHash is the hash of the last successfully loaded configuration.
-	lastLoadedConfigHash float64
+	ch     chan AdmissionControlConfig
+	path   string
+	logger log.Logger
+	fw     *FileWatcher
+	// ctx is the context of Run, which cancels sending the configuration updates.
+	ctx context.Context
+
+	tenantsGauge prometheus.Gauge
 }
 
 // NewConfigWatcher creates a new ConfigWatcher.
@@ -56,98 +66,33 @@ func NewConfigWatcher(logger log.Logger, reg prometheus.Registerer, path string,
 		logger = log.NewNopLogger()
 	}
 
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, errors.Wrap(err, "creating file watcher")
-	}
-	if err := watcher.Add(path); err != nil {
-		return nil, errors.Wrapf(err, "adding path %s to file watcher", path)
-	}
-
 	c := &ConfigWatcher{
-		ch:       make(chan AdmissionControlConfig),
-		path:     path,
-		interval: time.Duration(interval),
-		logger:   logger,
-		watcher:  watcher,
+		ch:     make(chan AdmissionControlConfig),
+		path:   path,
+		logger: logger,
+		ctx:    context.Background(),
 
-		successGauge: promauto.With(reg).NewGauge(
-			prometheus.GaugeOpts{
-				Name: "whizard_tenant_admission_config_last_reload_successful",
-				Help: "Whether the last configuration file reload attempt was successful.",
-			}),
-		lastSuccessTimeGauge: promauto.With(reg).NewGauge(
-			prometheus.GaugeOpts{
-				Name: "whizard_tenant_admission_config_last_reload_success_timestamp_seconds",
-				Help: "Timestamp of the last successful configuration file reload.",
-			}),
-		changesCounter: promauto.With(reg).NewCounter(
-			prometheus.CounterOpts{
-				Name: "whizard_tenant_admission_config_file_changes_total",
-				Help: "The number of times the configuration file has changed.",
-			}),
-		errorCounter: promauto.With(reg).NewCounter(
-			prometheus.CounterOpts{
-				Name: "whizard_tenant_admission_config_file_errors_total",
-				Help: "The number of errors watching the configuration file.",
-			}),
-		refreshCounter: promauto.With(reg).NewCounter(
-			prometheus.CounterOpts{
-				Name: "whizard_tenant_admission_config_file_refreshes_total",
-				Help: "The number of refreshes of the configuration file.",
-			}),
 		tenantsGauge: promauto.With(reg).NewGauge(
 			prometheus.GaugeOpts{
 				Name: "whizard_tenant_admission_tenants",
 				Help: "The number of tenants allowed.",
 			}),
 	}
+
+	fw, err := NewFileWatcher(logger, reg, "tenant_admission", path, interval, c.reload)
+	if err != nil {
+		return nil, err
+	}
+	c.fw = fw
 	return c, nil
 }
 
 // Run starts the ConfigWatcher until the given context is canceled.
 func (cw *ConfigWatcher) Run(ctx context.Context) {
-	defer cw.Stop()
-
-	cw.refresh(ctx)
+	defer close(cw.ch)
 
-	ticker := time.NewTicker(cw.interval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-
-		case event := <-cw.watcher.Events:
-			// fsnotify sometimes sends a bunch of events without name or operation.
-			// It's unclear what they are and why they are sent - filter them out.
-			if event.Name == "" {
-				break
-			}
-			// Everything but a CHMOD requires rereading.
-			// If the file was removed, we can't read it, so skip.
-			if event.Op^(fsnotify.Chmod|fsnotify.Remove) == 0 {
-				break
-			}
-			// Changes to a file can spawn various sequences of events with
-			// different combinations of operations. For all practical purposes
-			// this is inaccurate.
-			// The most reliable solution is to reload everything if anything happens.
-			cw.refresh(ctx)
-
-		case <-ticker.C:
-			// Setting a new watch after an update might fail. Make sure we don't lose
-			// those files forever.
-			cw.refresh(ctx)
-
-		case err := <-cw.watcher.Errors:
-			if err != nil {
-				cw.errorCounter.Inc()
-				level.Error(cw.logger).Log("msg", "error watching file", "err", err)
-			}
-		}
-	}
+	cw.ctx = ctx
+	_ = cw.fw.Run(ctx)
 }
 
 // C returns a chan that gets configuration updates.
@@ -157,73 +102,34 @@ func (cw *ConfigWatcher) C() <-chan AdmissionControlConfig {
 
 // ValidateConfig returns an error if the configuration that's being watched is not valid.
 func (cw *ConfigWatcher) ValidateConfig() error {
-	_, _, err := loadConfig(cw.logger, cw.path)
+	_, err := loadConfig(cw.logger, cw.path)
 	return err
 }
 
 // Stop shuts down the config watcher.
 func (cw *ConfigWatcher) Stop() {
-	level.Debug(cw.logger).Log("msg", "stopping configuration watcher...", "path", cw.path)
-
-	done := make(chan struct{})
-	defer close(done)
-
-	// Closing the watcher will deadlock unless all events and errors are drained.
-	go func() {
-		for {
-			select {
-			case <-cw.watcher.Errors:
-			case <-cw.watcher.Events:
-			// Drain all events and errors.
-			case <-done:
-				return
-			}
-		}
-	}()
-	if err := cw.watcher.Close(); err != nil {
-		level.Error(cw.logger).Log("msg", "error closing file watcher", "path", cw.path, "err", err)
-	}
-
+	cw.fw.Stop()
 	close(cw.ch)
-	level.Debug(cw.logger).Log("msg", "configuration watcher stopped")
 }
 
-// refresh reads the configured file and sends the configuration on the channel.
-func (cw *ConfigWatcher) refresh(ctx context.Context) {
-	cw.refreshCounter.Inc()
-
-	config, cfgHash, err := loadConfig(cw.logger, cw.path)
+// reload parses the changed configuration file and sends the configuration on the channel.
+func (cw *ConfigWatcher) reload(content []byte) error {
+	config, err := parseConfigContent(content)
 	if err != nil {
-		cw.errorCounter.Inc()
-		level.Error(cw.logger).Log("msg", "failed to load configuration file", "err", err, "path", cw.path)
-		return
+		return err
 	}
-
-	// If there was no change to the configuration, return early.
-	if cw.lastLoadedConfigHash == cfgHash {
-		return
-	}
-
-	cw.changesCounter.Inc()
-
-	// Save the last known configuration.
-	cw.lastLoadedConfigHash = cfgHash
-
-	cw.successGauge.Set(1)
-	cw.lastSuccessTimeGauge.SetToCurrentTime()
-
 	cw.tenantsGauge.Set(float64(len(config.Tenants)))
 
-	level.Debug(cw.logger).Log("msg", "refreshed config")
 	select {
-	case <-ctx.Done():
-		return
+	case <-cw.ctx.Done():
+		return cw.ctx.Err()
 	case cw.ch <- config:
-		return
+		return nil
 	}
 }
 
-func ConfigFromWatcher(ctx context.Context, updates chan<- AdmissionControlConfig, cw *ConfigWatcher) error {
+// ConfigFromWatcher runs the source and forwards its configuration updates until the given context is canceled.
+func ConfigFromWatcher(ctx context.Context, updates chan<- AdmissionControlConfig, cw AdmissionConfigSource) error {
 	defer close(updates)
 	go cw.Run(ctx)
 
@@ -243,27 +149,32 @@ func ConfigFromWatcher(ctx context.Context, updates chan<- AdmissionControlConfi
 // ParseConfig parses the raw configuration content and returns a TenantConfig.
 func ParseConfig(content []byte) (AdmissionControlConfig, error) {
 	var config AdmissionControlConfig
-	err := json.Unmarshal(content, &config)
-	return config, err
+	if err := json.Unmarshal(content, &config); err != nil {
+		return config, err
+	}
+	return config, config.Validate()
 }
 
 // loadConfig loads raw configuration content and returns a configuration.
-func loadConfig(logger log.Logger, path string) (AdmissionControlConfig, float64, error) {
+func loadConfig(logger log.Logger, path string) (AdmissionControlConfig, error) {
 	cfgContent, err := readFile(logger, path)
 	if err != nil {
-		return AdmissionControlConfig{}, 0, errors.Wrap(err, "failed to read configuration file")
+		return AdmissionControlConfig{}, errors.Wrap(err, "failed to read configuration file")
 	}
+	return parseConfigContent(cfgContent)
+}
 
-	if len(cfgContent) == 0 {
-		return AdmissionControlConfig{}, 0, errors.Wrap(errEmptyConfigurationFile, "configuration file is empty")
+// parseConfigContent parses the content of a configuration file, which must not be empty.
+func parseConfigContent(content []byte) (AdmissionControlConfig, error) {
+	if len(content) == 0 {
+		return AdmissionControlConfig{}, errors.Wrap(errEmptyConfigurationFile, "configuration file is empty")
 	}
 
-	config, err := ParseConfig(cfgContent)
+	config, err := ParseConfig(content)
 	if err != nil {
-		return AdmissionControlConfig{}, 0, errors.Wrapf(errParseConfigurationFile, "failed to parse configuration file: %v", err)
+		return AdmissionControlConfig{}, errors.Wrapf(errParseConfigurationFile, "failed to parse configuration file: %v", err)
 	}
-
-	return config, hashAsMetricValue(cfgContent), nil
+	return config, nil
 }
 
 // readFile reads the configuration file and returns content of configuration file.
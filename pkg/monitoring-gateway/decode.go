@@ -0,0 +1,123 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/prompb"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+)
+
+// errRequestTooLarge is returned when a request body exceeds the maximum request size, once decompressed.
+var errRequestTooLarge = errors.New("the request body exceeds the maximum request size")
+
+// decodeErrorStatus returns the response status of a request whose body can't be decoded.
+func decodeErrorStatus(err error) int {
+	if errors.Is(err, errRequestTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// decodeWriteRequest decodes a snappy-compressed remote write request body.
+func decodeWriteRequest(body []byte) (*prompb.WriteRequest, error) {
+	reqBuf, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "decompressing remote write request")
+	}
+
+	var wreq prompb.WriteRequest
+	if err := wreq.Unmarshal(reqBuf); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling remote write request")
+	}
+	return &wreq, nil
+}
+
+// encodeWriteRequest encodes a remote write request to a snappy-compressed body.
+func encodeWriteRequest(wreq *prompb.WriteRequest) ([]byte, error) {
+	reqBuf, err := wreq.Marshal()
+	if err != nil {
+		return nil, errors.Wrap(err, "marshalling remote write request")
+	}
+	return snappy.Encode(nil, reqBuf), nil
+}
+
+// decodeReadRequest decodes a snappy-compressed remote read request body.
+func decodeReadRequest(body []byte) (*prompb.ReadRequest, error) {
+	reqBuf, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "decompressing remote read request")
+	}
+
+	var rreq prompb.ReadRequest
+	if err := rreq.Unmarshal(reqBuf); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling remote read request")
+	}
+	return &rreq, nil
+}
+
+// encodeReadRequest encodes a remote read request to a snappy-compressed body.
+func encodeReadRequest(rreq *prompb.ReadRequest) ([]byte, error) {
+	reqBuf, err := rreq.Marshal()
+	if err != nil {
+		return nil, errors.Wrap(err, "marshalling remote read request")
+	}
+	return snappy.Encode(nil, reqBuf), nil
+}
+
+// decompressOTLPRequest decompresses an OTLP/HTTP request body according to its content encoding. The decompressed
+// body is limited to maxSize bytes, zero is unlimited.
+func decompressOTLPRequest(body []byte, header http.Header, maxSize int64) ([]byte, error) {
+	if header.Get("Content-Encoding") != "gzip" {
+		return body, nil
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "decompressing OTLP request")
+	}
+	defer gr.Close()
+
+	var r io.Reader = gr
+	if maxSize > 0 {
+		r = io.LimitReader(gr, maxSize+1)
+	}
+	if body, err = io.ReadAll(r); err != nil {
+		return nil, errors.Wrap(err, "decompressing OTLP request")
+	}
+	if maxSize > 0 && int64(len(body)) > maxSize {
+		return nil, errRequestTooLarge
+	}
+	return body, nil
+}
+
+// decodeOTLPRequest decodes an OTLP/HTTP metrics export request body, in protobuf or JSON encoding.
+func decodeOTLPRequest(body []byte, header http.Header, maxSize int64) (pmetricotlp.ExportRequest, error) {
+	req := pmetricotlp.NewExportRequest()
+
+	body, err := decompressOTLPRequest(body, header, maxSize)
+	if err != nil {
+		return req, err
+	}
+
+	switch header.Get("Content-Type") {
+	case "application/json":
+		err = req.UnmarshalJSON(body)
+	default:
+		err = req.UnmarshalProto(body)
+	}
+	return req, errors.Wrap(err, "unmarshalling OTLP request")
+}
+
+// countOTLPSamples returns the number of data points in an OTLP/HTTP metrics export request body.
+func countOTLPSamples(body []byte, header http.Header, maxSize int64) (int, error) {
+	req, err := decodeOTLPRequest(body, header, maxSize)
+	if err != nil {
+		return 0, err
+	}
+	return req.Metrics().DataPointCount(), nil
+}
@@ -0,0 +1,536 @@
+package monitoringgateway
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promconfig "github.com/prometheus/prometheus/config"
+)
+
+const (
+	recordHeaderSize = 8
+
+	dropReasonQueueFull = "queue_full"
+	dropReasonCorrupted = "corrupted"
+)
+
+var (
+	errQueueClosed  = errors.New("queue closed")
+	errQueuePaused  = errors.New("queue paused")
+	castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// queueEntry is a remote write request stored in a diskQueue.
+type queueEntry struct {
+	seg *queueSegment
+	// seq is the order the entry is read in, which is the order of the queue.
+	seq uint64
+
+	enqueued time.Time
+	msg      promconfig.RemoteWriteProtoMsg
+	tenant   string
+	body     []byte
+}
+
+// queueSegment is a file of a diskQueue, which holds a sequence of records.
+type queueSegment struct {
+	index   int
+	size    int64
+	entries int // number of records written
+	read    int // number of records handed out
+	done    int // number of records acknowledged
+	removed bool
+}
+
+// diskQueue is a bounded FIFO queue of remote write requests, persisted to a sequence of segment files.
+// Each record is prefixed by its length and CRC32 checksum. A segment is removed once all of its records
+// are acknowledged, so that the records which are not acknowledged before a restart are read again.
+// When the queue exceeds its maximum size, the oldest segments are dropped.
+// The delivery is at least once, as the records of a segment may be read again after a restart.
+//
+// The records are written to the page cache when they are appended, and synced to disk when their segment is
+// rolled or the queue is closed. So they survive the restarts of the process, while the records of the segment
+// being written to may be lost if the node crashes.
+type diskQueue struct {
+	dir         string
+	segmentSize int64
+	maxSize     int64
+
+	lengthGauge prometheus.Gauge
+	sizeGauge   prometheus.Gauge
+	dropped     func(reason string, n int)
+
+	mtx      sync.Mutex
+	cond     *sync.Cond
+	closed   bool
+	paused   bool
+	segments []*queueSegment // oldest first, the last one is written to
+	size     int64
+	head     *os.File
+
+	// released are the entries handed out but not processed, which are read again before the segments,
+	// in the order they are read first.
+	released []*queueEntry
+	readSeq  uint64
+
+	reading    *queueSegment
+	readFile   *os.File
+	readBuffer *bufio.Reader
+}
+
+// openDiskQueue opens the queue in dir, recovering the records of the existing segments.
+func openDiskQueue(dir string, segmentSize, maxSize int64, lengthGauge, sizeGauge prometheus.Gauge, dropped func(reason string, n int)) (*diskQueue, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, errors.Wrapf(err, "creating queue directory %s", dir)
+	}
+
+	q := &diskQueue{
+		dir:         dir,
+		segmentSize: segmentSize,
+		maxSize:     maxSize,
+		lengthGauge: lengthGauge,
+		sizeGauge:   sizeGauge,
+		dropped:     dropped,
+	}
+	q.cond = sync.NewCond(&q.mtx)
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading queue directory %s", dir)
+	}
+	var indexes []int
+	for _, f := range files {
+		index, err := strconv.Atoi(f.Name())
+		if err != nil || f.IsDir() {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	for _, index := range indexes {
+		seg, err := q.recoverSegment(index)
+		if err != nil {
+			return nil, err
+		}
+		if seg.entries == 0 {
+			if err := os.Remove(q.segmentPath(index)); err != nil {
+				return nil, errors.Wrap(err, "removing empty segment")
+			}
+			continue
+		}
+		q.segments = append(q.segments, seg)
+		q.size += seg.size
+	}
+
+	// Existing segments are never appended to, so that a torn write is not followed by new records.
+	next := 0
+	if len(indexes) > 0 {
+		next = indexes[len(indexes)-1] + 1
+	}
+	if err := q.createHead(next); err != nil {
+		return nil, err
+	}
+	q.updateGauges()
+	return q, nil
+}
+
+func (q *diskQueue) segmentPath(index int) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%08d", index))
+}
+
+// recoverSegment counts the valid records of a segment, and truncates the segment after the last valid record.
+func (q *diskQueue) recoverSegment(index int) (*queueSegment, error) {
+	f, err := os.Open(q.segmentPath(index))
+	if err != nil {
+		return nil, errors.Wrap(err, "opening segment")
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading segment")
+	}
+
+	seg := &queueSegment{index: index}
+	r := bufio.NewReader(f)
+	for {
+		n, err := skipRecord(r, info.Size()-seg.size)
+		if err != nil {
+			break
+		}
+		seg.size += n
+		seg.entries++
+	}
+
+	if err := os.Truncate(q.segmentPath(index), seg.size); err != nil {
+		return nil, errors.Wrap(err, "truncating segment")
+	}
+	return seg, nil
+}
+
+func (q *diskQueue) createHead(index int) error {
+	f, err := os.OpenFile(q.segmentPath(index), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return errors.Wrap(err, "creating segment")
+	}
+	q.head = f
+	q.segments = append(q.segments, &queueSegment{index: index})
+	return nil
+}
+
+// roll closes the segment being written to, and starts a new one.
+func (q *diskQueue) roll() error {
+	if err := q.head.Sync(); err != nil {
+		return errors.Wrap(err, "syncing segment")
+	}
+	if err := q.head.Close(); err != nil {
+		return errors.Wrap(err, "closing segment")
+	}
+	return q.createHead(q.segments[len(q.segments)-1].index + 1)
+}
+
+// Append adds a remote write request to the queue, dropping the oldest segments if the queue is full.
+func (q *diskQueue) Append(tenant string, msg promconfig.RemoteWriteProtoMsg, body []byte) error {
+	rec := encodeRecord(time.Now(), tenant, msg, body)
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.closed {
+		return errQueueClosed
+	}
+	if int64(len(rec)) > q.maxSize {
+		q.dropped(dropReasonQueueFull, 1)
+		return nil
+	}
+
+	for q.size+int64(len(rec)) > q.maxSize {
+		if len(q.segments) == 1 {
+			if err := q.roll(); err != nil {
+				return err
+			}
+		}
+		q.dropSegment(q.segments[0])
+	}
+
+	head := q.segments[len(q.segments)-1]
+	if head.size > 0 && head.size+int64(len(rec)) > q.segmentSize {
+		if err := q.roll(); err != nil {
+			return err
+		}
+		head = q.segments[len(q.segments)-1]
+	}
+
+	if _, err := q.head.Write(rec); err != nil {
+		return errors.Wrap(err, "writing record")
+	}
+	head.size += int64(len(rec))
+	head.entries++
+	q.size += int64(len(rec))
+
+	q.updateGauges()
+	q.cond.Signal()
+	return nil
+}
+
+// dropSegment removes a segment regardless of the records which are not yet read.
+// The records being processed are still acknowledged, but it has no effect.
+func (q *diskQueue) dropSegment(seg *queueSegment) {
+	if n := seg.entries - seg.read; n > 0 {
+		q.dropped(dropReasonQueueFull, n)
+	}
+	q.removeSegment(seg)
+}
+
+func (q *diskQueue) removeSegment(seg *queueSegment) {
+	if q.reading == seg {
+		q.closeReader()
+	}
+	for i, s := range q.segments {
+		if s == seg {
+			q.segments = append(q.segments[:i], q.segments[i+1:]...)
+			break
+		}
+	}
+	seg.removed = true
+	q.size -= seg.size
+	_ = os.Remove(q.segmentPath(seg.index))
+}
+
+func (q *diskQueue) closeReader() {
+	if q.readFile != nil {
+		_ = q.readFile.Close()
+	}
+	q.reading, q.readFile, q.readBuffer = nil, nil, nil
+}
+
+// Next blocks until a request is available, or the queue is closed or paused.
+// The returned entry must be either acknowledged once it's processed, or released.
+func (q *diskQueue) Next() (*queueEntry, error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for {
+		if q.closed {
+			return nil, errQueueClosed
+		}
+		if q.paused {
+			return nil, errQueuePaused
+		}
+
+		if len(q.released) > 0 {
+			e := q.released[0]
+			q.released = q.released[1:]
+			if e.seg.removed {
+				continue
+			}
+			return e, nil
+		}
+
+		var seg *queueSegment
+		for _, s := range q.segments {
+			if s.read < s.entries {
+				seg = s
+				break
+			}
+		}
+		if seg == nil {
+			q.cond.Wait()
+			continue
+		}
+
+		if q.reading != seg {
+			q.closeReader()
+			f, err := os.Open(q.segmentPath(seg.index))
+			if err != nil {
+				return nil, errors.Wrap(err, "opening segment")
+			}
+			q.reading, q.readFile, q.readBuffer = seg, f, bufio.NewReader(f)
+		}
+
+		e, err := readRecord(q.readBuffer, seg.size)
+		if err != nil {
+			// The rest of the segment can not be read, so it's dropped.
+			n := seg.entries - seg.read
+			q.dropped(dropReasonCorrupted, n)
+			seg.read += n
+			seg.done += n
+			q.closeReader()
+			q.removeDone()
+			continue
+		}
+		e.seg = seg
+		e.seq = q.readSeq
+		q.readSeq++
+		seg.read++
+		return e, nil
+	}
+}
+
+// Ack acknowledges that an entry is processed, so that its segment can be removed.
+func (q *diskQueue) Ack(e *queueEntry) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if e.seg.removed {
+		return
+	}
+	e.seg.done++
+	q.removeDone()
+}
+
+// Release hands an entry which is not processed back to the queue, so that it's read again. The released entries
+// are read again in the queue order, regardless of the order they are released in.
+func (q *diskQueue) Release(e *queueEntry) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if e.seg.removed {
+		return
+	}
+	i := sort.Search(len(q.released), func(i int) bool { return q.released[i].seq > e.seq })
+	q.released = slices.Insert(q.released, i, e)
+	q.cond.Signal()
+}
+
+// Pause unblocks the readers, and makes Next return errQueuePaused until Resume is called.
+// The requests are still appended while the queue is paused.
+func (q *diskQueue) Pause() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.paused = true
+	q.cond.Broadcast()
+}
+
+// Resume resumes reading the queue.
+func (q *diskQueue) Resume() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.paused = false
+	q.cond.Broadcast()
+}
+
+// SetLimits updates the segment size and the maximum size of the queue, which apply to the next appended requests.
+func (q *diskQueue) SetLimits(segmentSize, maxSize int64) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.segmentSize, q.maxSize = segmentSize, maxSize
+}
+
+// Length returns the number of requests which are not acknowledged.
+func (q *diskQueue) Length() int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	return q.length()
+}
+
+func (q *diskQueue) length() int {
+	var length int
+	for _, s := range q.segments {
+		length += s.entries - s.done
+	}
+	return length
+}
+
+// removeDone removes the oldest segments whose records are all acknowledged.
+func (q *diskQueue) removeDone() {
+	for len(q.segments) > 1 {
+		seg := q.segments[0]
+		if seg.done < seg.entries {
+			break
+		}
+		q.removeSegment(seg)
+	}
+	q.updateGauges()
+}
+
+func (q *diskQueue) updateGauges() {
+	q.lengthGauge.Set(float64(q.length()))
+	q.sizeGauge.Set(float64(q.size))
+}
+
+// Close unblocks the readers and syncs the queue to disk. The records which are not acknowledged are kept.
+func (q *diskQueue) Close() error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	q.cond.Broadcast()
+	q.closeReader()
+
+	if err := q.head.Sync(); err != nil {
+		return errors.Wrap(err, "syncing segment")
+	}
+	return q.head.Close()
+}
+
+// encodeRecord encodes a request to a record, which is prefixed by the length and the checksum of the payload.
+func encodeRecord(enqueued time.Time, tenant string, msg promconfig.RemoteWriteProtoMsg, body []byte) []byte {
+	rec := make([]byte, recordHeaderSize, recordHeaderSize+3*binary.MaxVarintLen64+len(tenant)+len(msg)+len(body))
+	rec = binary.AppendVarint(rec, enqueued.UnixMilli())
+	rec = binary.AppendUvarint(rec, uint64(len(tenant)))
+	rec = append(rec, tenant...)
+	rec = binary.AppendUvarint(rec, uint64(len(msg)))
+	rec = append(rec, msg...)
+	rec = append(rec, body...)
+
+	payload := rec[recordHeaderSize:]
+	binary.BigEndian.PutUint32(rec[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(rec[4:8], crc32.Checksum(payload, castagnoliTable))
+	return rec
+}
+
+// readPayload reads the payload of the next record, and verifies its checksum. The length of the header is not
+// trusted for the allocation, the records larger than maxSize are corrupted.
+func readPayload(r *bufio.Reader, maxSize int64) ([]byte, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if int64(length) > maxSize-recordHeaderSize {
+		return nil, errors.Errorf("record length %d exceeds the segment size", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(payload, castagnoliTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errors.New("record checksum mismatch")
+	}
+	return payload, nil
+}
+
+// skipRecord reads the next record, and returns its size.
+func skipRecord(r *bufio.Reader, maxSize int64) (int64, error) {
+	payload, err := readPayload(r, maxSize)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := decodeRecord(payload); err != nil {
+		return 0, err
+	}
+	return int64(recordHeaderSize + len(payload)), nil
+}
+
+func readRecord(r *bufio.Reader, maxSize int64) (*queueEntry, error) {
+	payload, err := readPayload(r, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRecord(payload)
+}
+
+func decodeRecord(payload []byte) (*queueEntry, error) {
+	errInvalid := errors.New("invalid record")
+
+	ts, n := binary.Varint(payload)
+	if n <= 0 {
+		return nil, errInvalid
+	}
+	payload = payload[n:]
+
+	readString := func() (string, error) {
+		l, n := binary.Uvarint(payload)
+		if n <= 0 || uint64(len(payload)-n) < l {
+			return "", errInvalid
+		}
+		s := string(payload[n : n+int(l)])
+		payload = payload[n+int(l):]
+		return s, nil
+	}
+	tenant, err := readString()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := readString()
+	if err != nil {
+		return nil, err
+	}
+
+	return &queueEntry{
+		enqueued: time.UnixMilli(ts),
+		tenant:   tenant,
+		msg:      promconfig.RemoteWriteProtoMsg(msg),
+		body:     payload,
+	}, nil
+}
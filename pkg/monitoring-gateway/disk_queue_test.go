@@ -0,0 +1,129 @@
+package monitoringgateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	promconfig "github.com/prometheus/prometheus/config"
+)
+
+func TestDiskQueue(t *testing.T) {
+	dir := t.TempDir()
+	dropped := map[string]int{}
+	open := func() *diskQueue {
+		q, err := openDiskQueue(dir, 64, 1024, prometheus.NewGauge(prometheus.GaugeOpts{Name: "length"}), prometheus.NewGauge(prometheus.GaugeOpts{Name: "size"}),
+			func(reason string, n int) { dropped[reason] += n })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return q
+	}
+
+	q := open()
+	for _, tenant := range []string{"a", "b", "c"} {
+		if err := q.Append(tenant, promconfig.RemoteWriteProtoMsgV1, make([]byte, 40)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first request is acknowledged, while the second is read but not acknowledged.
+	e, err := q.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.tenant != "a" || e.msg != promconfig.RemoteWriteProtoMsgV1 || len(e.body) != 40 {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	q.Ack(e)
+	if _, err := q.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A torn write at the end of a segment is truncated.
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"))
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{0, 0, 1})
+	f.Close()
+
+	q = open()
+	defer q.Close()
+	for _, tenant := range []string{"b", "c"} {
+		e, err := q.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.tenant != tenant {
+			t.Fatalf("expected tenant %s, got %s", tenant, e.tenant)
+		}
+		q.Ack(e)
+	}
+
+	// The oldest requests are dropped once the queue is full.
+	for i := 0; i < 30; i++ {
+		if err := q.Append("d", promconfig.RemoteWriteProtoMsgV1, make([]byte, 40)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if q.size > q.maxSize {
+		t.Fatalf("queue size %d exceeds the max size %d", q.size, q.maxSize)
+	}
+	if dropped[dropReasonQueueFull] == 0 {
+		t.Fatal("expected dropped requests")
+	}
+
+	// A corrupted length is rejected before the payload is allocated.
+	rec := encodeRecord(time.Now(), "e", promconfig.RemoteWriteProtoMsgV1, make([]byte, 40))
+	binary.BigEndian.PutUint32(rec[0:4], math.MaxUint32)
+	if _, err := readRecord(bufio.NewReader(bytes.NewReader(rec)), int64(len(rec))); err == nil {
+		t.Fatal("expected the record of the corrupted length to be rejected")
+	}
+}
+
+func TestDiskQueueRelease(t *testing.T) {
+	q, err := openDiskQueue(t.TempDir(), 1024, 4096, prometheus.NewGauge(prometheus.GaugeOpts{Name: "length"}), prometheus.NewGauge(prometheus.GaugeOpts{Name: "size"}),
+		func(string, int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	entries := map[string]*queueEntry{}
+	for _, tenant := range []string{"a", "b", "c"} {
+		if err := q.Append(tenant, promconfig.RemoteWriteProtoMsgV1, make([]byte, 40)); err != nil {
+			t.Fatal(err)
+		}
+		e, err := q.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[e.tenant] = e
+	}
+
+	// The released entries are read again in the queue order.
+	for _, tenant := range []string{"c", "a", "b"} {
+		q.Release(entries[tenant])
+	}
+	for _, tenant := range []string{"a", "b", "c"} {
+		e, err := q.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.tenant != tenant {
+			t.Fatalf("expected tenant %s, got %s", tenant, e.tenant)
+		}
+		q.Ack(e)
+	}
+}
@@ -0,0 +1,191 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// federateLookbackDelta is the time the latest samples of the federated series are selected within,
+// the default lookback delta of Prometheus.
+const federateLookbackDelta = 5 * time.Minute
+
+// federate selects the latest samples of the tenant enforced match[] selectors from the query target,
+// and renders them in the text or OpenMetrics exposition format negotiated by the scraper.
+func (h *Handler) federate(w http.ResponseWriter, req *http.Request) {
+	if h.queryProxy == nil {
+		http.Error(w, "The query target is not configured for the server", http.StatusNotAcceptable)
+		return
+	}
+
+	q := req.URL.Query()
+	if len(q[matchersParam]) == 0 {
+		http.Error(w, "no match[] parameter provided", http.StatusBadRequest)
+		return
+	}
+
+	requestInfo, _ := requestInfoFrom(req.Context())
+	if err := injectMatcher(q, newTenantEnforcer(h.options.TenantLabelName, requestInfo.Tenants), matchersParam); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limits := h.limits.QueryLimits(requestInfo.Tenants)
+	ctx := req.Context()
+	if limits.QueryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(limits.QueryTimeout))
+		defer cancel()
+	}
+
+	vector, err := h.latestSamples(ctx, q[matchersParam])
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			h.writeQueryError(w, requestInfo.TenantId, &apiError{
+				status:      http.StatusServiceUnavailable,
+				errorType:   errorTypeTimeout,
+				err:         fmt.Errorf("the federation exceeded the timeout %s", limits.QueryTimeout),
+				limitReason: queryLimitReasonTimeout,
+			})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if limits.MaxFederateSeries > 0 && len(vector) > limits.MaxFederateSeries {
+		h.writeQueryError(w, requestInfo.TenantId, &apiError{
+			status:      http.StatusUnprocessableEntity,
+			errorType:   errorTypeExecution,
+			err:         fmt.Errorf("the federation returned %d series, which exceeds the limit %d", len(vector), limits.MaxFederateSeries),
+			limitReason: queryLimitReasonFederateSeries,
+		})
+		return
+	}
+
+	var (
+		buf    bytes.Buffer
+		format = expfmt.NegotiateIncludingOpenMetrics(req.Header)
+		enc    = expfmt.NewEncoder(&buf, format)
+	)
+	for _, mf := range metricFamilies(vector) {
+		if err := enc.Encode(mf); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if limits.MaxFederateBytes > 0 && buf.Len() > limits.MaxFederateBytes {
+		h.writeQueryError(w, requestInfo.TenantId, &apiError{
+			status:      http.StatusUnprocessableEntity,
+			errorType:   errorTypeExecution,
+			err:         fmt.Errorf("the federation output of %d bytes exceeds the limit %d", buf.Len(), limits.MaxFederateBytes),
+			limitReason: queryLimitReasonFederateBytes,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", string(format))
+	_, _ = w.Write(buf.Bytes())
+}
+
+// latestSamples returns the latest samples of the series of the selectors within the lookback delta, with their
+// original timestamps like the federation of Prometheus. An instant query would stamp the samples with the evaluation
+// time instead, so that the selectors are queried as range vectors, once per selector.
+func (h *Handler) latestSamples(ctx context.Context, selectors []string) (model.Vector, error) {
+	var (
+		vector model.Vector
+		seen   = make(map[model.Fingerprint]struct{})
+	)
+	for _, selector := range selectors {
+		var data struct {
+			ResultType string       `json:"resultType"`
+			Result     model.Matrix `json:"result"`
+		}
+		query := fmt.Sprintf("%s[%s]", selector, model.Duration(federateLookbackDelta))
+		if err := h.queryData(ctx, apiGlobalPrefix+epQuery, url.Values{queryParam: {query}}, &data); err != nil {
+			return nil, err
+		}
+		if data.ResultType != model.ValMatrix.String() {
+			return nil, errors.Errorf("unexpected result type %s of the query", data.ResultType)
+		}
+
+		for _, stream := range data.Result {
+			// The series matched by several selectors are federated once.
+			fp := stream.Metric.Fingerprint()
+			if _, ok := seen[fp]; ok {
+				continue
+			}
+			seen[fp] = struct{}{}
+
+			s := &model.Sample{Metric: stream.Metric}
+			if n := len(stream.Values); n > 0 {
+				s.Value, s.Timestamp = stream.Values[n-1].Value, stream.Values[n-1].Timestamp
+			}
+			if n := len(stream.Histograms); n > 0 && stream.Histograms[n-1].Timestamp > s.Timestamp {
+				s.Histogram, s.Timestamp = stream.Histograms[n-1].Histogram, stream.Histograms[n-1].Timestamp
+			}
+			vector = append(vector, s)
+		}
+	}
+	return vector, nil
+}
+
+// metricFamilies groups the float samples of the vector into untyped metric families sorted by name.
+// The native histograms are omitted, since the exposition formats can't represent them.
+func metricFamilies(vector model.Vector) []*dto.MetricFamily {
+	families := make(map[string]*dto.MetricFamily)
+	for _, s := range vector {
+		if s.Histogram != nil {
+			continue
+		}
+		name := string(s.Metric[model.MetricNameLabel])
+		mf, ok := families[name]
+		if !ok {
+			mf = &dto.MetricFamily{Name: &name, Type: dto.MetricType_UNTYPED.Enum()}
+			families[name] = mf
+		}
+
+		m := &dto.Metric{}
+		for ln, lv := range s.Metric {
+			if ln == model.MetricNameLabel {
+				continue
+			}
+			name, value := string(ln), string(lv)
+			m.Label = append(m.Label, &dto.LabelPair{Name: &name, Value: &value})
+		}
+		sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+
+		value, ts := float64(s.Value), int64(s.Timestamp)
+		m.Untyped = &dto.Untyped{Value: &value}
+		m.TimestampMs = &ts
+		mf.Metric = append(mf.Metric, m)
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]*dto.MetricFamily, 0, len(names))
+	for _, name := range names {
+		out = append(out, families[name])
+	}
+	return out
+}
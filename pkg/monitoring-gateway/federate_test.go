@@ -0,0 +1,107 @@
+package monitoringgateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestFederate(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		queries = append(queries, req.URL.Query().Get(queryParam))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"__name__":"up","tenant_id":"t1","job":"b"},"values":[[1699999970,"0"],[1700000000,"1"]]},
+			{"metric":{"__name__":"up","tenant_id":"t1","job":"a"},"values":[[1700000000,"0"]]},
+			{"metric":{"__name__":"go_goroutines","tenant_id":"t1","job":"a"},"values":[[1699999985,"42"]]}
+		]}}`))
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	limits := NewLimits(prometheus.NewRegistry())
+	limits.SetConfig(LimitsConfig{
+		Tenants: map[string]TenantLimits{
+			"t2": {MaxFederateSeries: 2},
+			"t3": {MaxFederateBytes: 64},
+		},
+	})
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantLabelName: "tenant_id",
+		QueryProxy:      NewSingleHostReverseProxy(target, http.DefaultTransport),
+		Limits:          limits,
+	})
+
+	federate := func(tenant string, accept string, matchers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/"+tenant+"/federate?"+url.Values{matchersParam: matchers}.Encode(), nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := federate("t1", "", `up`, `{__name__=~"go_.+"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// The selectors are queried as range vectors, so that the latest samples keep their original timestamps,
+	// and the series matched by several selectors are federated once.
+	if diff := cmp.Diff([]string{`{__name__="up",tenant_id="t1"}[5m]`, `{__name__=~"go_.+",tenant_id="t1"}[5m]`}, queries); diff != "" {
+		t.Fatal(diff)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+	if diff := cmp.Diff(`# TYPE go_goroutines untyped
+go_goroutines{job="a",tenant_id="t1"} 42 1699999985000
+# TYPE up untyped
+up{job="b",tenant_id="t1"} 1 1700000000000
+up{job="a",tenant_id="t1"} 0 1700000000000
+`, rec.Body.String()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	rec = federate("t1", "application/openmetrics-text;version=1.0.0", `up`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+	if diff := cmp.Diff(`# TYPE go_goroutines unknown
+go_goroutines{job="a",tenant_id="t1"} 42.0 1.699999985e+09
+# TYPE up unknown
+up{job="b",tenant_id="t1"} 1.0 1.7e+09
+up{job="a",tenant_id="t1"} 0.0 1.7e+09
+# EOF
+`, rec.Body.String()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		tenant   string
+		matchers []string
+		code     int
+	}{
+		{name: "no matchers", tenant: "t1", code: http.StatusBadRequest},
+		{name: "invalid matcher", tenant: "t1", matchers: []string{`up{`}, code: http.StatusBadRequest},
+		{name: "too many series", tenant: "t2", matchers: []string{`up`}, code: http.StatusUnprocessableEntity},
+		{name: "too many bytes", tenant: "t3", matchers: []string{`up`}, code: http.StatusUnprocessableEntity},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := federate(tc.tenant, "", tc.matchers...)
+			if rec.Code != tc.code {
+				t.Fatalf("expected status %d, got %d: %s", tc.code, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package monitoringgateway
+
+import (
+	"context"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+)
+
+// FileWatcher is able to watch a configuration file for updates
+// and hands over the changed content to a reload function.
+type FileWatcher struct {
+	path     string
+	interval time.Duration
+	logger   log.Logger
+	watcher  *fsnotify.Watcher
+	reload   func(content []byte) error
+
+	successGauge         prometheus.Gauge
+	lastSuccessTimeGauge prometheus.Gauge
+	changesCounter       prometheus.Counter
+	errorCounter         prometheus.Counter
+	refreshCounter       prometheus.Counter
+
+	// lastLoadedConfigHash is the hash of the last successfully loaded configuration.
+	lastLoadedConfigHash float64
+}
+
+// NewFileWatcher creates a new FileWatcher. The name is used to prefix the exposed metrics.
+func NewFileWatcher(logger log.Logger, reg prometheus.Registerer, name, path string, interval model.Duration, reload func(content []byte) error) (*FileWatcher, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "creating file watcher")
+	}
+	if err := watcher.Add(path); err != nil {
+		return nil, errors.Wrapf(err, "adding path %s to file watcher", path)
+	}
+
+	return &FileWatcher{
+		path:     path,
+		interval: time.Duration(interval),
+		logger:   logger,
+		watcher:  watcher,
+		reload:   reload,
+
+		successGauge: promauto.With(reg).NewGauge(
+			prometheus.GaugeOpts{
+				Name: "whizard_" + name + "_config_last_reload_successful",
+				Help: "Whether the last configuration file reload attempt was successful.",
+			}),
+		lastSuccessTimeGauge: promauto.With(reg).NewGauge(
+			prometheus.GaugeOpts{
+				Name: "whizard_" + name + "_config_last_reload_success_timestamp_seconds",
+				Help: "Timestamp of the last successful configuration file reload.",
+			}),
+		changesCounter: promauto.With(reg).NewCounter(
+			prometheus.CounterOpts{
+				Name: "whizard_" + name + "_config_file_changes_total",
+				Help: "The number of times the configuration file has changed.",
+			}),
+		errorCounter: promauto.With(reg).NewCounter(
+			prometheus.CounterOpts{
+				Name: "whizard_" + name + "_config_file_errors_total",
+				Help: "The number of errors watching or loading the configuration file.",
+			}),
+		refreshCounter: promauto.With(reg).NewCounter(
+			prometheus.CounterOpts{
+				Name: "whizard_" + name + "_config_file_refreshes_total",
+				Help: "The number of refreshes of the configuration file.",
+			}),
+	}, nil
+}
+
+// Run starts the FileWatcher until the given context is canceled.
+func (fw *FileWatcher) Run(ctx context.Context) error {
+	defer fw.Stop()
+
+	fw.refresh()
+
+	ticker := time.NewTicker(fw.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event := <-fw.watcher.Events:
+			// fsnotify sometimes sends a bunch of events without name or operation.
+			if event.Name == "" {
+				break
+			}
+			// Everything but a CHMOD requires rereading.
+			if event.Op^(fsnotify.Chmod|fsnotify.Remove) == 0 {
+				break
+			}
+			fw.refresh()
+
+		case <-ticker.C:
+			// Setting a new watch after an update might fail. Make sure we don't lose
+			// those files forever.
+			fw.refresh()
+
+		case err := <-fw.watcher.Errors:
+			if err != nil {
+				fw.errorCounter.Inc()
+				level.Error(fw.logger).Log("msg", "error watching file", "err", err)
+			}
+		}
+	}
+}
+
+// Stop shuts down the file watcher.
+func (fw *FileWatcher) Stop() {
+	level.Debug(fw.logger).Log("msg", "stopping file watcher...", "path", fw.path)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// Closing the watcher will deadlock unless all events and errors are drained.
+	go func() {
+		for {
+			select {
+			case <-fw.watcher.Errors:
+			case <-fw.watcher.Events:
+			// Drain all events and errors.
+			case <-done:
+				return
+			}
+		}
+	}()
+	if err := fw.watcher.Close(); err != nil {
+		level.Error(fw.logger).Log("msg", "error closing file watcher", "path", fw.path, "err", err)
+	}
+
+	level.Debug(fw.logger).Log("msg", "file watcher stopped")
+}
+
+// refresh reads the watched file and reloads it if the content has changed.
+func (fw *FileWatcher) refresh() {
+	fw.refreshCounter.Inc()
+
+	content, err := readFile(fw.logger, fw.path)
+	if err != nil {
+		fw.errorCounter.Inc()
+		level.Error(fw.logger).Log("msg", "failed to read configuration file", "err", err, "path", fw.path)
+		return
+	}
+
+	cfgHash := hashAsMetricValue(content)
+	// If there was no change to the configuration, return early.
+	if fw.lastLoadedConfigHash == cfgHash {
+		return
+	}
+	fw.changesCounter.Inc()
+
+	if err := fw.reload(content); err != nil {
+		fw.errorCounter.Inc()
+		fw.successGauge.Set(0)
+		level.Error(fw.logger).Log("msg", "failed to reload configuration file", "err", err, "path", fw.path)
+		return
+	}
+
+	fw.lastLoadedConfigHash = cfgHash
+	fw.successGauge.Set(1)
+	fw.lastSuccessTimeGauge.SetToCurrentTime()
+
+	level.Debug(fw.logger).Log("msg", "reloaded configuration file", "path", fw.path)
+}
@@ -0,0 +1,496 @@
+package monitoringgateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	DefaultHAClusterLabel = "cluster"
+	DefaultHAReplicaLabel = "__replica__"
+
+	// HAStoreMemory keeps the elected replicas in the memory of the gateway, which fits a single gateway replica.
+	HAStoreMemory = "memory"
+	// HAStoreLease shares the elected replicas across the gateway replicas by Kubernetes Leases.
+	HAStoreLease = "lease"
+
+	haLeaseNamePrefix        = "whizard-ha-"
+	haLeaseTenantAnnotation  = "monitoring.whizard.io/tenant"
+	haLeaseClusterAnnotation = "monitoring.whizard.io/cluster"
+
+	maxHAStoreConflicts = 3
+)
+
+// HATrackerConfig is the configuration of the HA tracker.
+type HATrackerConfig struct {
+	// ClusterLabel is the label identifying the HA cluster the series are written from.
+	ClusterLabel string
+	// ReplicaLabel is the label identifying the replica in the HA cluster, which is removed from the accepted series.
+	ReplicaLabel string
+	// FailoverTimeout is the time after the last accepted request of the elected replica, a new replica is elected after.
+	FailoverTimeout time.Duration
+	// UpdateTimeout is the interval the received time of the elected replica is updated in the store at.
+	// It has to be lower than the failover timeout.
+	UpdateTimeout time.Duration
+}
+
+// HAReplica is the elected replica of an HA cluster.
+type HAReplica struct {
+	Replica    string
+	ReceivedAt time.Time
+
+	// version is the version of the stored replica, to detect the concurrent changes by the other gateway replicas.
+	version string
+}
+
+// HAStore stores the elected replicas of the HA clusters of the tenants.
+type HAStore interface {
+	// Get returns the elected replica of the cluster of the tenant, or nil if there is none.
+	Get(ctx context.Context, tenant, cluster string) (*HAReplica, error)
+	// CompareAndSwap stores the next elected replica, if the stored one is still prev, or there is none if prev is nil.
+	// It returns false if the stored replica was changed concurrently.
+	CompareAndSwap(ctx context.Context, tenant, cluster string, prev, next *HAReplica) (bool, error)
+	// DeleteExpired deletes the elected replicas received before the given time, unless they're changed concurrently.
+	DeleteExpired(ctx context.Context, before time.Time) error
+}
+
+type haKey struct {
+	tenant  string
+	cluster string
+}
+
+// HATracker deduplicates the samples written by the replicas of the HA clusters, e.g. HA pairs of Prometheus.
+// It elects one replica per cluster of a tenant, whose samples are accepted, while the samples of the other replicas
+// are dropped. Another replica is elected once the elected one has not written for the failover timeout.
+type HATracker struct {
+	logger log.Logger
+	cfg    HATrackerConfig
+	store  HAStore
+
+	mtx sync.Mutex
+	// elected caches the elected replicas, so that the store is only requested once the update timeout passes.
+	elected map[haKey]HAReplica
+
+	electedReplicaChanges *prometheus.CounterVec
+	deduplicatedSamples   *prometheus.CounterVec
+}
+
+// NewHATracker creates an HATracker sharing the elected replicas by the store.
+func NewHATracker(logger log.Logger, reg prometheus.Registerer, cfg HATrackerConfig, store HAStore) (*HATracker, error) {
+	if cfg.ClusterLabel == "" || cfg.ReplicaLabel == "" {
+		return nil, errors.New("the cluster and replica labels of the HA tracker must be set")
+	}
+	if cfg.UpdateTimeout >= cfg.FailoverTimeout {
+		return nil, errors.Errorf("the HA tracker update timeout %s must be lower than the failover timeout %s", cfg.UpdateTimeout, cfg.FailoverTimeout)
+	}
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	return &HATracker{
+		logger:  logger,
+		cfg:     cfg,
+		store:   store,
+		elected: make(map[haKey]HAReplica),
+
+		electedReplicaChanges: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_ha_tracker_elected_replica_changes_total",
+				Help: "Total number of elected replica changes of the HA clusters, labeled by tenant.",
+			},
+			[]string{"tenant"},
+		),
+		deduplicatedSamples: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_ha_tracker_deduplicated_samples_total",
+				Help: "Total number of samples dropped from the non-elected replicas of the HA clusters, labeled by tenant.",
+			},
+			[]string{"tenant"},
+		),
+	}, nil
+}
+
+// NewHATrackerFromFlags creates the HATracker of the flag configuration, or nil if it's disabled.
+func NewHATrackerFromFlags(logger log.Logger, reg prometheus.Registerer, hc *HATrackerFlagConfig) (*HATracker, error) {
+	if !hc.Enabled {
+		return nil, nil
+	}
+
+	cfg := HATrackerConfig{
+		ClusterLabel:    hc.ClusterLabel,
+		ReplicaLabel:    hc.ReplicaLabel,
+		FailoverTimeout: time.Duration(*hc.FailoverTimeout),
+		UpdateTimeout:   time.Duration(*hc.UpdateTimeout),
+	}
+	store := NewMemoryHAStore()
+	if hc.Store == HAStoreLease {
+		if hc.LeaseNamespace == "" {
+			return nil, errors.New("the lease store of the HA tracker requires 'ha-tracker.lease-namespace'")
+		}
+		client, err := newKubernetesClient(hc.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		store = NewLeaseHAStore(client, hc.LeaseNamespace, cfg.FailoverTimeout)
+	}
+	return NewHATracker(logger, reg, cfg, store)
+}
+
+// checkReplica returns true if the samples of the replica of the cluster of the tenant are accepted,
+// electing the replica if there is no elected replica, or the elected replica has failed over.
+func (t *HATracker) checkReplica(ctx context.Context, tenant, cluster, replica string, now time.Time) (bool, error) {
+	key := haKey{tenant: tenant, cluster: cluster}
+
+	t.mtx.Lock()
+	cached, ok := t.elected[key]
+	t.mtx.Unlock()
+	if ok {
+		age := now.Sub(cached.ReceivedAt)
+		if cached.Replica == replica && age < t.cfg.UpdateTimeout {
+			return true, nil
+		}
+		if cached.Replica != replica && age < t.cfg.FailoverTimeout {
+			return false, nil
+		}
+	}
+
+	for range maxHAStoreConflicts {
+		cur, err := t.store.Get(ctx, tenant, cluster)
+		if err != nil {
+			return false, err
+		}
+		if cur != nil {
+			age := now.Sub(cur.ReceivedAt)
+			if cur.Replica != replica && age < t.cfg.FailoverTimeout {
+				t.cache(key, *cur)
+				return false, nil
+			}
+			if cur.Replica == replica && age < t.cfg.UpdateTimeout {
+				t.cache(key, *cur)
+				return true, nil
+			}
+		}
+
+		next := &HAReplica{Replica: replica, ReceivedAt: now}
+		swapped, err := t.store.CompareAndSwap(ctx, tenant, cluster, cur, next)
+		if err != nil {
+			return false, err
+		}
+		if !swapped {
+			continue
+		}
+		if cur != nil && cur.Replica != replica {
+			t.electedReplicaChanges.WithLabelValues(tenant).Inc()
+			level.Info(t.logger).Log("msg", "elected a new replica of the HA cluster", "tenant", tenant, "cluster", cluster, "replica", replica, "previous", cur.Replica)
+		}
+		t.cache(key, *next)
+		return true, nil
+	}
+	return false, errors.Errorf("the elected replica of the HA cluster %s of tenant %s changed concurrently", cluster, tenant)
+}
+
+func (t *HATracker) cache(key haKey, r HAReplica) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.elected[key] = r
+}
+
+// Run deletes the elected replicas of the clusters without requests for the failover timeout, from the cache and
+// the store, until the given context is canceled. Another replica is elected for them anyway, so that they're
+// the same as the clusters without elected replicas.
+func (t *HATracker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(t.cfg.FailoverTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			if err := t.deleteExpired(ctx, now); err != nil {
+				level.Warn(t.logger).Log("msg", "failed to delete the expired replicas of the HA clusters", "err", err)
+			}
+		}
+	}
+}
+
+func (t *HATracker) deleteExpired(ctx context.Context, now time.Time) error {
+	before := now.Add(-t.cfg.FailoverTimeout)
+
+	t.mtx.Lock()
+	for key, r := range t.elected {
+		if r.ReceivedAt.Before(before) {
+			delete(t.elected, key)
+		}
+	}
+	t.mtx.Unlock()
+
+	return t.store.DeleteExpired(ctx, before)
+}
+
+// deduplicateRemoteWrite applies the HA tracker to a remote write request body of the tenant.
+// The request is identified by the cluster and replica labels of its first series. The request of the elected replica
+// is kept without the replica label, while the requests of the other replicas are accepted and dropped, in which case
+// false is returned.
+func (h *Handler) deduplicateRemoteWrite(w http.ResponseWriter, req *http.Request, tenant string, b *writeRequestBody) bool {
+	t := h.options.HATracker
+	if t == nil || tenant == "" {
+		return true
+	}
+	if err := b.decode(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	var cluster, replica string
+	switch {
+	case b.v2 != nil:
+		if len(b.v2.Timeseries) > 0 {
+			refs := b.v2.Timeseries[0].LabelsRefs
+			for i := 0; i+1 < len(refs); i += 2 {
+				switch b.v2.Symbols[refs[i]] {
+				case t.cfg.ClusterLabel:
+					cluster = b.v2.Symbols[refs[i+1]]
+				case t.cfg.ReplicaLabel:
+					replica = b.v2.Symbols[refs[i+1]]
+				}
+			}
+		}
+	default:
+		if len(b.v1.Timeseries) > 0 {
+			for _, l := range b.v1.Timeseries[0].Labels {
+				switch l.Name {
+				case t.cfg.ClusterLabel:
+					cluster = l.Value
+				case t.cfg.ReplicaLabel:
+					replica = l.Value
+				}
+			}
+		}
+	}
+
+	// The requests not written by HA clusters are accepted as is.
+	if cluster == "" || replica == "" {
+		return true
+	}
+
+	elected, err := t.checkReplica(req.Context(), tenant, cluster, replica, time.Now())
+	if err != nil {
+		level.Warn(h.logger).Log("msg", "failed to check the replica of the HA cluster", "tenant", tenant, "cluster", cluster, "replica", replica, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !elected {
+		stats, _ := b.stats()
+		t.deduplicatedSamples.WithLabelValues(tenant).Add(float64(stats.samples + stats.histograms))
+		w.WriteHeader(http.StatusAccepted)
+		return false
+	}
+
+	switch {
+	case b.v2 != nil:
+		removeReplicaLabelV2(b.v2, t.cfg.ReplicaLabel)
+	default:
+		removeReplicaLabel(b.v1, t.cfg.ReplicaLabel)
+	}
+	b.modified = true
+	return true
+}
+
+// removeReplicaLabel removes the replica label from the series, so that the series of the replicas are the same.
+func removeReplicaLabel(wreq *prompb.WriteRequest, replicaLabel string) {
+	for i, ts := range wreq.Timeseries {
+		ls := ts.Labels[:0]
+		for _, l := range ts.Labels {
+			if l.Name != replicaLabel {
+				ls = append(ls, l)
+			}
+		}
+		wreq.Timeseries[i].Labels = ls
+	}
+}
+
+// removeReplicaLabelV2 is the remote write 2.0 counterpart of removeReplicaLabel.
+// The symbols are kept, as they may be referenced by other labels.
+func removeReplicaLabelV2(wreq *writev2.Request, replicaLabel string) {
+	for i, ts := range wreq.Timeseries {
+		refs := ts.LabelsRefs[:0]
+		for j := 0; j+1 < len(ts.LabelsRefs); j += 2 {
+			if wreq.Symbols[ts.LabelsRefs[j]] != replicaLabel {
+				refs = append(refs, ts.LabelsRefs[j], ts.LabelsRefs[j+1])
+			}
+		}
+		wreq.Timeseries[i].LabelsRefs = refs
+	}
+}
+
+// memoryHAStore is the HAStore keeping the elected replicas in memory.
+type memoryHAStore struct {
+	mtx      sync.Mutex
+	replicas map[haKey]HAReplica
+	version  int
+}
+
+// NewMemoryHAStore creates an HAStore keeping the elected replicas in memory, which isn't shared by the gateway replicas.
+func NewMemoryHAStore() HAStore {
+	return &memoryHAStore{replicas: make(map[haKey]HAReplica)}
+}
+
+func (s *memoryHAStore) Get(_ context.Context, tenant, cluster string) (*HAReplica, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	r, ok := s.replicas[haKey{tenant: tenant, cluster: cluster}]
+	if !ok {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+func (s *memoryHAStore) CompareAndSwap(_ context.Context, tenant, cluster string, prev, next *HAReplica) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	key := haKey{tenant: tenant, cluster: cluster}
+	cur, ok := s.replicas[key]
+	if ok != (prev != nil) || (ok && cur.version != prev.version) {
+		return false, nil
+	}
+	s.version++
+	r := *next
+	r.version = strconv.Itoa(s.version)
+	s.replicas[key] = r
+	return true, nil
+}
+
+func (s *memoryHAStore) DeleteExpired(_ context.Context, before time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for key, r := range s.replicas {
+		if r.ReceivedAt.Before(before) {
+			delete(s.replicas, key)
+		}
+	}
+	return nil
+}
+
+// leaseHAStore is the HAStore keeping the elected replicas in Kubernetes Leases, a Lease per cluster of a tenant.
+// The Lease is held by the elected replica, and renewed at its received time.
+type leaseHAStore struct {
+	client          kubernetes.Interface
+	namespace       string
+	failoverTimeout time.Duration
+}
+
+// NewLeaseHAStore creates an HAStore keeping the elected replicas in the Leases of the namespace,
+// so that they're shared by the gateway replicas.
+func NewLeaseHAStore(client kubernetes.Interface, namespace string, failoverTimeout time.Duration) HAStore {
+	return &leaseHAStore{client: client, namespace: namespace, failoverTimeout: failoverTimeout}
+}
+
+// haLeaseName returns the name of the Lease of the cluster of the tenant, hashed to be a valid name.
+func haLeaseName(tenant, cluster string) string {
+	sum := sha256.Sum256([]byte(tenant + "/" + cluster))
+	return haLeaseNamePrefix + hex.EncodeToString(sum[:])[:16]
+}
+
+func (s *leaseHAStore) Get(ctx context.Context, tenant, cluster string) (*HAReplica, error) {
+	lease, err := s.client.CoordinationV1().Leases(s.namespace).Get(ctx, haLeaseName(tenant, cluster), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "getting the lease of the HA cluster")
+	}
+
+	r := &HAReplica{version: lease.ResourceVersion}
+	if lease.Spec.HolderIdentity != nil {
+		r.Replica = *lease.Spec.HolderIdentity
+	}
+	if lease.Spec.RenewTime != nil {
+		r.ReceivedAt = lease.Spec.RenewTime.Time
+	}
+	return r, nil
+}
+
+func (s *leaseHAStore) CompareAndSwap(ctx context.Context, tenant, cluster string, prev, next *HAReplica) (bool, error) {
+	var (
+		holder        = next.Replica
+		renewTime     = metav1.NewMicroTime(next.ReceivedAt)
+		leaseDuration = int32(math.Ceil(s.failoverTimeout.Seconds()))
+		lease         = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      haLeaseName(tenant, cluster),
+				Namespace: s.namespace,
+				Annotations: map[string]string{
+					haLeaseTenantAnnotation:  tenant,
+					haLeaseClusterAnnotation: cluster,
+				},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &leaseDuration,
+				RenewTime:            &renewTime,
+			},
+		}
+		err error
+	)
+	if prev == nil {
+		_, err = s.client.CoordinationV1().Leases(s.namespace).Create(ctx, lease, metav1.CreateOptions{})
+	} else {
+		lease.ResourceVersion = prev.version
+		_, err = s.client.CoordinationV1().Leases(s.namespace).Update(ctx, lease, metav1.UpdateOptions{})
+	}
+
+	switch {
+	case apierrors.IsAlreadyExists(err), apierrors.IsConflict(err), apierrors.IsNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, errors.Wrapf(err, "storing the lease of the HA cluster %s of tenant %s", cluster, tenant)
+	}
+	return true, nil
+}
+
+func (s *leaseHAStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	leases, err := s.client.CoordinationV1().Leases(s.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "listing the leases of the HA clusters")
+	}
+
+	for _, lease := range leases.Items {
+		if !strings.HasPrefix(lease.Name, haLeaseNamePrefix) || lease.Annotations[haLeaseTenantAnnotation] == "" {
+			continue
+		}
+		if lease.Spec.RenewTime != nil && !lease.Spec.RenewTime.Time.Before(before) {
+			continue
+		}
+		// The lease renewed concurrently by another gateway replica is kept by the precondition.
+		err := s.client.CoordinationV1().Leases(s.namespace).Delete(ctx, lease.Name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion},
+		})
+		if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+			return errors.Wrapf(err, "deleting the lease %s of the HA cluster", lease.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/prompb"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestHATracker(t *testing.T) {
+	for name, store := range map[string]HAStore{
+		"memory": NewMemoryHAStore(),
+		"lease":  NewLeaseHAStore(fake.NewClientset(), "default", 30*time.Second),
+	} {
+		t.Run(name, func(t *testing.T) {
+			tracker, err := NewHATracker(nil, prometheus.NewRegistry(), HATrackerConfig{
+				ClusterLabel:    DefaultHAClusterLabel,
+				ReplicaLabel:    DefaultHAReplicaLabel,
+				FailoverTimeout: 30 * time.Second,
+				UpdateTimeout:   10 * time.Second,
+			}, store)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, now := context.Background(), time.Now()
+			check := func(replica string, at time.Duration, expected bool) {
+				t.Helper()
+				elected, err := tracker.checkReplica(ctx, "t1", "c1", replica, now.Add(at))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if elected != expected {
+					t.Fatalf("expected replica %s elected %v at %s", replica, expected, at)
+				}
+			}
+
+			check("a", 0, true)
+			check("b", time.Second, false)
+			// The received time of the elected replica is updated after the update timeout.
+			check("a", 15*time.Second, true)
+			check("b", 40*time.Second, false)
+			// Another replica is elected once the elected replica has not written for the failover timeout.
+			check("b", 50*time.Second, true)
+			check("a", 51*time.Second, false)
+
+			// The elected replicas are shared by the trackers of the store.
+			other, err := NewHATracker(nil, prometheus.NewRegistry(), tracker.cfg, store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if elected, err := other.checkReplica(ctx, "t1", "c1", "a", now.Add(52*time.Second)); err != nil || elected {
+				t.Fatalf("expected replica a not elected, got %v: %v", elected, err)
+			}
+
+			if v := testutil.ToFloat64(tracker.electedReplicaChanges.WithLabelValues("t1")); v != 1 {
+				t.Fatalf("expected 1 elected replica change, got %v", v)
+			}
+
+			// The elected replicas are kept until the failover timeout, and deleted after it.
+			if err := tracker.deleteExpired(ctx, now.Add(70*time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			if r, err := store.Get(ctx, "t1", "c1"); err != nil || r == nil {
+				t.Fatalf("expected the elected replica kept, got %v: %v", r, err)
+			}
+			if err := tracker.deleteExpired(ctx, now.Add(90*time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			if r, err := store.Get(ctx, "t1", "c1"); err != nil || r != nil {
+				t.Fatalf("expected the elected replica deleted, got %v: %v", r, err)
+			}
+			if len(tracker.elected) != 0 {
+				t.Fatalf("expected the cached replicas deleted, got %v", tracker.elected)
+			}
+			check("a", 91*time.Second, true)
+		})
+	}
+
+	if _, err := NewHATracker(nil, prometheus.NewRegistry(), HATrackerConfig{
+		ClusterLabel:    DefaultHAClusterLabel,
+		ReplicaLabel:    DefaultHAReplicaLabel,
+		FailoverTimeout: 10 * time.Second,
+		UpdateTimeout:   10 * time.Second,
+	}, NewMemoryHAStore()); err == nil {
+		t.Fatal("expected error for the update timeout not lower than the failover timeout")
+	}
+}
+
+func TestDeduplicateRemoteWrite(t *testing.T) {
+	var received [][]prompb.Label
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		wreq, err := decodeWriteRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, ts := range wreq.Timeseries {
+			received = append(received, ts.Labels)
+		}
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	tracker, err := NewHATracker(nil, prometheus.NewRegistry(), HATrackerConfig{
+		ClusterLabel:    DefaultHAClusterLabel,
+		ReplicaLabel:    DefaultHAReplicaLabel,
+		FailoverTimeout: time.Minute,
+		UpdateTimeout:   time.Second,
+	}, NewMemoryHAStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantHeader:     "WHIZARD-TENANT",
+		TenantLabelName:  "tenant_id",
+		RemoteWriteProxy: NewSingleHostReverseProxy(target, http.DefaultTransport),
+		HATracker:        tracker,
+	})
+
+	write := func(ls ...prompb.Label) int {
+		body, err := encodeWriteRequest(&prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+			{Labels: ls, Samples: []prompb.Sample{{Value: 1, Timestamp: 1}}},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/t1/api/v1/receive", bytes.NewReader(body))
+		req.Header.Set("Content-Encoding", "snappy")
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	up := prompb.Label{Name: "__name__", Value: "up"}
+	cluster := prompb.Label{Name: DefaultHAClusterLabel, Value: "c1"}
+
+	if code := write(up, cluster, prompb.Label{Name: DefaultHAReplicaLabel, Value: "a"}); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if code := write(up, cluster, prompb.Label{Name: DefaultHAReplicaLabel, Value: "b"}); code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d", code)
+	}
+	// The series not written by HA clusters are accepted as is.
+	if code := write(up, prompb.Label{Name: DefaultHAReplicaLabel, Value: "b"}); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+
+	if diff := cmp.Diff([][]prompb.Label{
+		{up, cluster},
+		{up, {Name: DefaultHAReplicaLabel, Value: "b"}},
+	}, received); diff != "" {
+		t.Fatal(diff)
+	}
+	if v := testutil.ToFloat64(tracker.deduplicatedSamples.WithLabelValues("t1")); v != 1 {
+		t.Fatalf("expected 1 deduplicated sample, got %v", v)
+	}
+}
@@ -7,9 +7,8 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -19,7 +18,6 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/route"
-	"github.com/prometheus/prometheus/model/labels"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
 	"github.com/thanos-io/thanos/pkg/ui"
 )
@@ -32,11 +30,20 @@ const (
 	epQueryRange  = "/query_range"
 	epSeries      = "/series"
 	epLabels      = "/labels"
-	epLabelValues = "/label/*path"
+	epLabelValues = "/label/{label_name}/values"
 	epReceive     = "/receive"
 	epOTLP        = "/otlp"
 	epRules       = "/rules"
 	epAlerts      = "/alerts"
+	epRead        = "/read"
+
+	epBuildInfo      = "/status/buildinfo"
+	epMetadata       = "/metadata"
+	epQueryExemplars = "/query_exemplars"
+	epFormatQuery    = "/format_query"
+	epParseQuery     = "/parse_query"
+
+	epFederate = "/{tenant_id}/federate"
 
 	epQueryUI = "/-/ui"
 )
@@ -44,15 +51,45 @@ const (
 type Options struct {
 	TenantHeader    string
 	TenantLabelName string
+	// TenantResolver resolves the tenants of the requests, they are resolved from the path prefix if nil.
+	TenantResolver *TenantResolver
 
-	QueryProxy        *httputil.ReverseProxy
-	RulesQueryProxy   *httputil.ReverseProxy
-	RemoteWriteProxy  *httputil.ReverseProxy
-	ExternalRWClients []*remoteWriteClient
+	QueryProxy           *httputil.ReverseProxy
+	RulesQueryProxy      *httputil.ReverseProxy
+	RemoteWriteProxy     *httputil.ReverseProxy
+	ExternalRemoteWriter *ExternalRemoteWriter
 
-	CertAuthenticator       *CertAuthenticator
+	// Authenticators is the authenticator chain the tenant requests are authenticated with.
+	// The requests are not authenticated if empty.
+	Authenticators          []Authenticator
 	EnabledTenantsAdmission bool
 	EnabledQueryUI          bool
+	// StrictRulesTenancy drops the rules without the tenant label from the rules responses,
+	// which are kept for all tenants otherwise.
+	StrictRulesTenancy bool
+
+	Limits *Limits
+	// HATracker deduplicates the remote write requests of the replicas of the HA clusters, if set.
+	HATracker *HATracker
+	// OTLPTenantAttribute is the resource attribute the tenants of the OTLP requests are mapped from, if set.
+	// The resource metrics of the requests are split by tenant then. The mapped tenants are set by the clients,
+	// so that the Authenticators must be configured to authorize them.
+	OTLPTenantAttribute string
+	// MaxRequestSize is the maximum size of the write request bodies, and of the OTLP request bodies once
+	// decompressed, in bytes. Zero is unlimited.
+	MaxRequestSize int64
+
+	// MaxQueryConcurrency is the maximum number of running query requests of all tenants, zero is unlimited.
+	// The requests over it, or over the concurrency limits of the tenants, are queued fairly by tenant.
+	MaxQueryConcurrency int
+	// QueryQueueTimeout is the maximum time of the query requests in the queue, zero is unlimited.
+	QueryQueueTimeout time.Duration
+
+	// EnabledUsageMetrics exports the per-tenant usage metrics of the write and query requests.
+	EnabledUsageMetrics bool
+	// UsageMetricsMaxTenants is the maximum number of tenants labeled in the usage metrics, zero is unlimited.
+	// The usage of the other tenants is accounted to the "__overflow__" tenant.
+	UsageMetricsMaxTenants int
 }
 
 type Handler struct {
@@ -61,14 +98,20 @@ type Handler struct {
 	options *Options
 	router  *mux.Router
 
-	tenantsAdmissionMap *sync.Map
+	tenantsAdmission *tenantsAdmission
+	tenantResolver   *TenantResolver
 
-	queryProxy        *httputil.ReverseProxy
-	rulesQueryProxy   *httputil.ReverseProxy
-	remoteWriteProxy  *httputil.ReverseProxy
-	externalRWClients []*remoteWriteClient
+	queryProxy           *httputil.ReverseProxy
+	rulesQueryProxy      *httputil.ReverseProxy
+	remoteWriteProxy     *httputil.ReverseProxy
+	externalRemoteWriter *ExternalRemoteWriter
 
-	remoteWriteRequestsCounter *prometheus.CounterVec
+	limits         *Limits
+	queryScheduler *queryScheduler
+	usage          *usageMetrics
+
+	discardedSamplesCounter *prometheus.CounterVec
+	authenticationsCounter  *prometheus.CounterVec
 }
 
 func NewHandler(logger log.Logger, reg *prometheus.Registry, o *Options) *Handler {
@@ -77,28 +120,41 @@ func NewHandler(logger log.Logger, reg *prometheus.Registry, o *Options) *Handle
 	}
 
 	h := &Handler{
-		logger:              logger,
-		options:             o,
-		router:              mux.NewRouter(),
-		tenantsAdmissionMap: &sync.Map{},
-		reg:                 reg,
-		queryProxy:          o.QueryProxy,
-		rulesQueryProxy:     o.RulesQueryProxy,
-		remoteWriteProxy:    o.RemoteWriteProxy,
-		externalRWClients:   o.ExternalRWClients,
-
-		remoteWriteRequestsCounter: promauto.With(reg).NewCounterVec(
+		logger:               logger,
+		options:              o,
+		router:               mux.NewRouter(),
+		tenantsAdmission:     newTenantsAdmission(reg),
+		tenantResolver:       o.TenantResolver,
+		reg:                  reg,
+		queryProxy:           o.QueryProxy,
+		rulesQueryProxy:      o.RulesQueryProxy,
+		remoteWriteProxy:     o.RemoteWriteProxy,
+		externalRemoteWriter: o.ExternalRemoteWriter,
+		limits:               o.Limits,
+		queryScheduler:       newQueryScheduler(reg, o.MaxQueryConcurrency, o.QueryQueueTimeout),
+
+		discardedSamplesCounter: promauto.With(reg).NewCounterVec(
 			prometheus.CounterOpts{
-				Name: "whizard_external_remote_write_requests_total",
-				Help: "Total number of remote write results, labeled by endpoint and code.",
+				Name: "whizard_gateway_discarded_samples_total",
+				Help: "Total number of samples discarded by the remote write validation, labeled by tenant and reason.",
 			},
-			[]string{"endpoint", "code"},
+			[]string{"tenant", "reason"},
 		),
+		authenticationsCounter: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_authentications_total",
+				Help: "Total number of authenticated tenant requests, labeled by result.",
+			},
+			[]string{"result"},
+		),
+	}
+	if h.tenantResolver == nil {
+		h.tenantResolver = defaultTenantResolver
+	}
+	if o.EnabledUsageMetrics {
+		h.usage = newUsageMetrics(reg, o.UsageMetricsMaxTenants)
 	}
 
-	// do provide /api/v1/alerts because thanos does not support alerts filtering as of v0.28.0
-	// please filtering alerts by /api/v1/rules
-	// h.router.Get(epAlerts, h.wrap(h.matcher(matchersParam)))
 	h.addGlobalProxyHandler()
 	h.addTenantQueryHandler()
 	h.addTenantRemoteWriteHandler()
@@ -112,30 +168,69 @@ func NewHandler(logger log.Logger, reg *prometheus.Registry, o *Options) *Handle
 }
 
 func (h *Handler) addTenantQueryHandler() {
-	h.router.Path(apiTenantPrefix+epQuery).Methods(http.MethodGet, http.MethodPost).HandlerFunc(h.wrap(h.query))
-	h.router.Path(apiTenantPrefix+epQueryRange).Methods(http.MethodGet, http.MethodPost).HandlerFunc(h.wrap(h.query))
-	h.router.Path(apiTenantPrefix + epSeries).Methods(http.MethodGet).HandlerFunc(h.wrap(h.matcher(matchersParam)))
-	h.router.Path(apiTenantPrefix + epLabels).Methods(http.MethodGet).HandlerFunc(h.wrap(h.matcher(matchersParam)))
-	h.router.Path(apiTenantPrefix + epLabelValues).Methods(http.MethodGet).HandlerFunc(h.wrap(h.matcher(matchersParam)))
-	h.router.Path(apiTenantPrefix + epRules).Methods(http.MethodGet).HandlerFunc(h.wrap(h.matcher(matchersParam)))
+	h.router.Path(apiTenantPrefix+epQuery).Methods(http.MethodGet, http.MethodPost).HandlerFunc(h.wrap(h.schedule(h.query)))
+	h.router.Path(apiTenantPrefix+epQueryRange).Methods(http.MethodGet, http.MethodPost).HandlerFunc(h.wrap(h.schedule(h.query)))
+	h.router.Path(apiTenantPrefix + epSeries).Methods(http.MethodGet).HandlerFunc(h.wrap(h.schedule(h.matcher(matchersParam))))
+	h.router.Path(apiTenantPrefix + epLabels).Methods(http.MethodGet).HandlerFunc(h.wrap(h.schedule(h.matcher(matchersParam))))
+	h.router.Path(apiTenantPrefix + epLabelValues).Methods(http.MethodGet).HandlerFunc(h.wrap(h.schedule(h.matcher(matchersParam))))
+	h.router.Path(apiTenantPrefix + epRules).Methods(http.MethodGet).HandlerFunc(h.wrap(h.schedule(h.rules)))
+	h.router.Path(apiTenantPrefix + epAlerts).Methods(http.MethodGet).HandlerFunc(h.wrap(h.schedule(h.alerts)))
+	h.router.Path(apiTenantPrefix + epRead).Methods(http.MethodPost).HandlerFunc(h.wrap(h.schedule(h.remoteRead)))
+	h.router.Path(epFederate).Methods(http.MethodGet).HandlerFunc(h.wrap(h.schedule(h.federate)))
+
+	h.router.Path(apiTenantPrefix+epQueryExemplars).Methods(http.MethodGet, http.MethodPost).HandlerFunc(h.wrap(h.schedule(h.query)))
+	h.router.Path(apiTenantPrefix + epMetadata).Methods(http.MethodGet).HandlerFunc(h.wrap(h.schedule(h.metadata)))
+	h.router.Path(apiTenantPrefix + epBuildInfo).Methods(http.MethodGet).HandlerFunc(h.wrap(h.forward))
+	h.router.Path(apiTenantPrefix+epFormatQuery).Methods(http.MethodGet, http.MethodPost).HandlerFunc(h.wrap(h.forward))
+	h.router.Path(apiTenantPrefix+epParseQuery).Methods(http.MethodGet, http.MethodPost).HandlerFunc(h.wrap(h.forward))
 }
 
 // addTenantRemoteWriteHandler adds a handler for receiving remote write requests, and supports forwarding them to external remote write targets.
 func (h *Handler) addTenantRemoteWriteHandler() {
 	h.router.Path(apiTenantPrefix + epReceive).Methods(http.MethodPost).HandlerFunc(h.wrap(h.remoteWrite))
+
+	// The pushed metrics in the exposition formats are written as the remote write requests.
+	for _, ep := range []string{epPush, epPushJob, epPushInstance} {
+		h.router.Path(apiTenantPrefix+ep).Methods(http.MethodPost, http.MethodPut).HandlerFunc(h.wrap(h.push))
+	}
 }
 
 func (h *Handler) addTenantOTLPHandler() {
-	h.router.Path(apiTenantPrefix + epOTLP).Methods(http.MethodPost).HandlerFunc(h.wrap(h.otlpReceive))
+	h.router.Path(apiTenantPrefix + epOTLP).Methods(http.MethodPost).HandlerFunc(h.otlpHandler(h.wrap(h.otlpReceive)))
 }
 
+// otlpHandler splits the OTLP requests by the tenant resource attribute before f if it's set.
+func (h *Handler) otlpHandler(f http.HandlerFunc) http.HandlerFunc {
+	if h.options.OTLPTenantAttribute == "" {
+		return f
+	}
+	return h.splitOTLP(f)
+}
+
+// addGlobalProxyHandler adds the handlers of the global /api/v1 routes. The requests are handled as the tenant requests
+// if their tenants are resolvable by the other sources than the path, e.g. the tenant headers, otherwise they are proxied as is.
+// The other global endpoints are not tenant aware, so that they are not exposed if the authenticators are configured.
 func (h *Handler) addGlobalProxyHandler() {
 	if h.remoteWriteProxy != nil {
-		h.router.Path(apiGlobalPrefix + epReceive).HandlerFunc(h.remoteWrite)
-		h.router.Path(apiGlobalPrefix + epOTLP).HandlerFunc(h.remoteWriteProxy.ServeHTTP)
+		h.router.Path(apiGlobalPrefix + epReceive).HandlerFunc(h.wrapGlobal(h.remoteWrite, h.remoteWrite))
+		h.router.Path(apiGlobalPrefix + epOTLP).HandlerFunc(h.otlpHandler(h.wrapGlobal(h.otlpReceive, h.remoteWriteProxy.ServeHTTP)))
 	}
 	if h.queryProxy != nil {
-		h.router.PathPrefix(apiGlobalPrefix).HandlerFunc(h.queryProxy.ServeHTTP)
+		h.router.Path(apiGlobalPrefix+epQuery).Methods(http.MethodGet, http.MethodPost).HandlerFunc(h.wrapGlobal(h.schedule(h.query), h.queryProxy.ServeHTTP))
+		h.router.Path(apiGlobalPrefix+epQueryRange).Methods(http.MethodGet, http.MethodPost).HandlerFunc(h.wrapGlobal(h.schedule(h.query), h.queryProxy.ServeHTTP))
+		h.router.Path(apiGlobalPrefix + epSeries).Methods(http.MethodGet).HandlerFunc(h.wrapGlobal(h.schedule(h.matcher(matchersParam)), h.queryProxy.ServeHTTP))
+		h.router.Path(apiGlobalPrefix + epLabels).Methods(http.MethodGet).HandlerFunc(h.wrapGlobal(h.schedule(h.matcher(matchersParam)), h.queryProxy.ServeHTTP))
+		h.router.Path(apiGlobalPrefix + epLabelValues).Methods(http.MethodGet).HandlerFunc(h.wrapGlobal(h.schedule(h.matcher(matchersParam)), h.queryProxy.ServeHTTP))
+		h.router.Path(apiGlobalPrefix + epRules).Methods(http.MethodGet).HandlerFunc(h.wrapGlobal(h.schedule(h.rules), h.queryProxy.ServeHTTP))
+		h.router.Path(apiGlobalPrefix + epAlerts).Methods(http.MethodGet).HandlerFunc(h.wrapGlobal(h.schedule(h.alerts), h.queryProxy.ServeHTTP))
+		h.router.Path(apiGlobalPrefix + epRead).Methods(http.MethodPost).HandlerFunc(h.wrapGlobal(h.schedule(h.remoteRead), h.queryProxy.ServeHTTP))
+		h.router.Path(apiGlobalPrefix+epQueryExemplars).Methods(http.MethodGet, http.MethodPost).HandlerFunc(h.wrapGlobal(h.schedule(h.query), h.queryProxy.ServeHTTP))
+		h.router.Path(apiGlobalPrefix + epMetadata).Methods(http.MethodGet).HandlerFunc(h.wrapGlobal(h.schedule(h.metadata), h.queryProxy.ServeHTTP))
+		if len(h.options.Authenticators) > 0 {
+			h.router.PathPrefix(apiGlobalPrefix).HandlerFunc(http.NotFound)
+		} else {
+			h.router.PathPrefix(apiGlobalPrefix).HandlerFunc(h.queryProxy.ServeHTTP)
+		}
 	}
 }
 
@@ -163,29 +258,32 @@ func (h *Handler) queryUIHander(prefix string, queryHanler, uiHandler http.Handl
 
 func (h *Handler) SetAdmissionControlHandler(c AdmissionControlConfig) error {
 	if h.options.EnabledTenantsAdmission {
-		v, ok := h.tenantsAdmissionMap.Load("/-/")
-		if !ok || v == nil {
-			level.Info(h.logger).Log("msg", "starting tenants admission control")
-			h.tenantsAdmissionMap.Store("/-/", c.Tenants)
-			for _, tenant := range c.Tenants {
-				h.tenantsAdmissionMap.Store(tenant, true)
-				level.Info(h.logger).Log("msg", fmt.Sprintf("tenant %s join admission queue", tenant))
-			}
-			return nil
+		m, err := newAdmissionMatcher(c)
+		if err != nil {
+			return err
 		}
-		tenants := v.([]string)
-		addTenantset := difference(c.Tenants, tenants)
-		for _, tenant := range addTenantset {
-			h.tenantsAdmissionMap.Store(tenant, true)
-			level.Info(h.logger).Log("msg", fmt.Sprintf("tenant %s join admission queue", tenant))
 
+		prev := h.tenantsAdmission.matcher.Swap(m)
+		if prev == nil {
+			level.Info(h.logger).Log("msg", "starting tenants admission control")
+			prev = &admissionMatcher{}
 		}
-		rmTenantset := difference(tenants, c.Tenants)
-		for _, tenant := range rmTenantset {
-			h.tenantsAdmissionMap.Delete(tenant)
+		for _, tenant := range difference(prev.names, m.names) {
 			level.Info(h.logger).Log("msg", fmt.Sprintf("tenant %s is removed from the access queue", tenant))
 		}
-		h.tenantsAdmissionMap.Store("/-/", c.Tenants)
+		for _, tenant := range m.names {
+			mode := m.tenants[tenant]
+			prevMode, ok := prev.tenants[tenant]
+			switch {
+			case !ok:
+				level.Info(h.logger).Log("msg", fmt.Sprintf("tenant %s join admission queue", tenant), "mode", mode)
+			case prevMode != mode:
+				level.Info(h.logger).Log("msg", fmt.Sprintf("tenant %s access mode changed", tenant), "mode", mode)
+			}
+		}
+		if len(c.Rules) > 0 {
+			level.Info(h.logger).Log("msg", "tenants admission rules loaded", "allow", len(m.allow), "deny", len(m.deny))
+		}
 	}
 
 	return nil
@@ -196,11 +294,38 @@ func (h *Handler) Router() *mux.Router {
 }
 
 func (h *Handler) wrap(f http.HandlerFunc) http.HandlerFunc {
-	if h.options.CertAuthenticator != nil {
-		f = withAuthorization(f, h.options.CertAuthenticator)
+	return h.wrapWithResolver(f, h.tenantResolver)
+}
+
+// wrapWithResolver wraps f as a tenant handler, whose tenants are resolved by the resolver.
+func (h *Handler) wrapWithResolver(f http.HandlerFunc, resolver *TenantResolver) http.HandlerFunc {
+	// The tenants may be resolved from the authenticated identity, so the admission follows the authentication.
+	f = withTenantsAdmission(f, h.tenantsAdmission, h.options.EnabledTenantsAdmission)
+	if len(h.options.Authenticators) > 0 {
+		f = withAuthentication(f, h.options.Authenticators, func(result string) {
+			h.authenticationsCounter.WithLabelValues(result).Inc()
+		})
 	}
 
-	return withRequestInfo(withTenantsAdmission(f, h.tenantsAdmissionMap, h.options.EnabledTenantsAdmission))
+	return withRequestInfo(f, resolver)
+}
+
+// wrapGlobal wraps f as a tenant handler for the global routes, the requests whose tenants are not resolvable
+// are handled by fallback instead. The fallback is unauthenticated, so that such requests are refused if the
+// authenticators are configured.
+func (h *Handler) wrapGlobal(f, fallback http.HandlerFunc) http.HandlerFunc {
+	wrapped := h.wrap(f)
+	return func(w http.ResponseWriter, req *http.Request) {
+		if tenants, deferred, err := h.tenantResolver.resolve(req, nil); err == nil && len(tenants) == 0 && !deferred {
+			if len(h.options.Authenticators) > 0 {
+				http.Error(w, "the tenant of the request is not resolvable", http.StatusUnauthorized)
+				return
+			}
+			fallback(w, req)
+			return
+		}
+		wrapped(w, req)
+	}
 }
 
 func (h *Handler) query(w http.ResponseWriter, req *http.Request) {
@@ -229,14 +354,22 @@ func (h *Handler) query(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	requestInfo, _ := requestInfoFrom(ctx)
 
-	// Set errorOnReplace to false to directly replace the existing tenant with the new TenantId without reporting an error.
-	enforcer := injectproxy.NewPromQLEnforcer(false, &labels.Matcher{
-		Type:  labels.MatchEqual,
-		Name:  h.options.TenantLabelName,
-		Value: requestInfo.TenantId,
-	})
+	limits := h.limits.QueryLimits(requestInfo.Tenants)
+	if err := checkQueryRange(limits, req.URL.Path, func(name string) string {
+		if v := postForm.Get(name); v != "" {
+			return v
+		}
+		return query.Get(name)
+	}); err != nil {
+		h.writeQueryError(w, requestInfo.TenantId, err)
+		return
+	}
+	capQueryTimeout(limits, query)
+	timeoutCapped := postForm.Get(timeoutParam) != "" && capQueryTimeout(limits, postForm)
+
+	enforcer := newTenantEnforcer(h.options.TenantLabelName, requestInfo.Tenants)
 
-	q, found, err := enforceQueryValues(enforcer, query)
+	q, _, err := enforceQueryValues(enforcer, query)
 	if err != nil {
 		if errors.Is(err, injectproxy.ErrIllegalLabelMatcher) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -250,9 +383,7 @@ func (h *Handler) query(w http.ResponseWriter, req *http.Request) {
 		}
 		return
 	}
-	if found {
-		req.URL.RawQuery = q
-	}
+	req.URL.RawQuery = q
 
 	if postForm != nil {
 		q, found, err := enforceQueryValues(enforcer, postForm)
@@ -269,14 +400,16 @@ func (h *Handler) query(w http.ResponseWriter, req *http.Request) {
 			}
 			return
 		}
-		if found {
+		if found || timeoutCapped {
 			_ = req.Body.Close()
 			req.Body = io.NopCloser(strings.NewReader(q))
 			req.ContentLength = int64(len(q))
 		}
 	}
 
-	h.queryProxy.ServeHTTP(w, req)
+	proxy, req, cancel := h.limitQueryResponse(h.queryProxy, req, limits)
+	defer cancel()
+	proxy.ServeHTTP(w, req)
 }
 
 func (h *Handler) matcher(matchersParam string) http.HandlerFunc {
@@ -289,14 +422,31 @@ func (h *Handler) matcher(matchersParam string) http.HandlerFunc {
 		ctx := req.Context()
 		requestInfo, _ := requestInfoFrom(ctx)
 
-		matcher := &labels.Matcher{
-			Type:  labels.MatchEqual,
-			Name:  h.options.TenantLabelName,
-			Value: requestInfo.TenantId,
-		}
+		enforcer := newTenantEnforcer(h.options.TenantLabelName, requestInfo.Tenants)
 		q := req.URL.Query()
 
-		if err := injectMatcher(q, matcher, matchersParam); err != nil {
+		form := q.Get
+		if req.Method == http.MethodPost {
+			if err := req.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			form = req.Form.Get
+		}
+
+		// The time range limits apply to the series, labels and label values requests,
+		// while the other query limits apply to the series requests only.
+		limits := h.limits.QueryLimits(requestInfo.Tenants)
+		if err := checkQueryRange(limits, req.URL.Path, form); err != nil {
+			h.writeQueryError(w, requestInfo.TenantId, err)
+			return
+		}
+		if !strings.HasSuffix(req.URL.Path, epSeries) {
+			limits = TenantLimits{}
+		}
+
+		if err := injectMatcher(q, enforcer, matchersParam); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		req.URL.RawQuery = q.Encode()
@@ -305,7 +455,8 @@ func (h *Handler) matcher(matchersParam string) http.HandlerFunc {
 				return
 			}
 			q = req.PostForm
-			if err := injectMatcher(q, matcher, matchersParam); err != nil {
+			if err := injectMatcher(q, enforcer, matchersParam); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 			_ = req.Body.Close()
@@ -313,12 +464,9 @@ func (h *Handler) matcher(matchersParam string) http.HandlerFunc {
 			req.ContentLength = int64(len(q))
 		}
 
-		if (strings.HasSuffix(req.URL.Path, "/rules") || strings.HasSuffix(req.URL.Path, "/alerts")) &&
-			h.rulesQueryProxy != nil {
-			h.rulesQueryProxy.ServeHTTP(w, req)
-			return
-		}
-		h.queryProxy.ServeHTTP(w, req)
+		proxy, req, cancel := h.limitQueryResponse(h.queryProxy, req, limits)
+		defer cancel()
+		proxy.ServeHTTP(w, req)
 	}
 }
 
@@ -330,48 +478,73 @@ func (h *Handler) remoteWrite(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	requestInfo, found := requestInfoFrom(ctx)
 
+	msg, err := remoteWriteProtoMsg(req.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
 	if found && requestInfo.TenantId != "" {
 		req.Header.Set(h.options.TenantHeader, requestInfo.TenantId)
 	}
 
-	// Forward the request to multiple targets in parallel.
-	// If either forwarding fails, the errors are responded. This may result in repeated sending same data to one target.
-	body, err := io.ReadAll(req.Body)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	body, ok := h.readRequestBody(w, req)
+	if !ok {
 		return
 	}
-	defer req.Body.Close()
+
+	// The body is decoded once by the steps needing the decoded request.
+	b := newWriteRequestBody(body, msg)
+	var validationErrs []error
+	if found {
+		if !h.deduplicateRemoteWrite(w, req, requestInfo.TenantId, b) {
+			return
+		}
+		if validationErrs, ok = h.validateRemoteWrite(w, requestInfo.TenantId, b); !ok {
+			return
+		}
+		if body, err = b.bytes(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// The global remote writes without tenants are neither limited nor accounted.
+	if found {
+		if !h.admitIngestion(w, requestInfo.TenantId, body, func() (int, error) {
+			stats, err := b.stats()
+			return stats.samples + stats.histograms, err
+		}) {
+			return
+		}
+		h.accountWrite(requestInfo.TenantId, func() (usageStats, error) {
+			return writeRequestUsage(b)
+		})
+	}
 
 	proxy := *h.remoteWriteProxy // 浅拷贝
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
 		originalDirector(req)
 		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
 	}
-	proxy.ServeHTTP(w, req)
-
-	var wg sync.WaitGroup
-	var tenantHeader = make(http.Header)
-	if tenantId := req.Header.Get(h.options.TenantHeader); tenantId != "" {
-		tenantHeader.Set(h.options.TenantHeader, tenantId)
-	}
-
-	for _, writeClient := range h.externalRWClients {
-		wg.Add(1)
-		ep := writeClient.Endpoint()
-
-		go func(writeClient *remoteWriteClient) {
-			defer wg.Done()
-			result := writeClient.Send(ctx, body, tenantHeader)
-			if result.err != nil {
-				level.Error(h.logger).Log("msg", "failed to forward request", "endpoint", ep, "err", result.err)
-			}
-			h.remoteWriteRequestsCounter.WithLabelValues(ep, strconv.Itoa(int(result.code))).Inc()
-		}(writeClient)
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		// The requests written downstream are forwarded to the external targets in background, without delaying the response.
+		if h.externalRemoteWriter != nil && resp.StatusCode/100 == 2 {
+			h.externalRemoteWriter.Enqueue(req.Header.Get(h.options.TenantHeader), msg, body)
+		}
+		// Remote write 2.0 clients rely on the written headers, which may be missing with older downstreams.
+		if err := setWrittenStats(resp, b); err != nil {
+			return err
+		}
+		if len(validationErrs) > 0 {
+			// The valid series are forwarded, while the rejected series are reported to the client.
+			return rejectPartially(resp, validationErrs)
+		}
+		return nil
 	}
-	wg.Wait()
-
+	proxy.ServeHTTP(w, req)
 }
 
 func (h *Handler) otlpReceive(w http.ResponseWriter, req *http.Request) {
@@ -387,9 +560,77 @@ func (h *Handler) otlpReceive(w http.ResponseWriter, req *http.Request) {
 		req.Header.Set(h.options.TenantHeader, requestInfo.TenantId)
 	}
 
+	if found && (h.limits != nil || h.usage != nil) {
+		body, ok := h.readRequestBody(w, req)
+		if !ok {
+			return
+		}
+		if !h.admitIngestion(w, requestInfo.TenantId, body, func() (int, error) {
+			return countOTLPSamples(body, req.Header, h.options.MaxRequestSize)
+		}) {
+			return
+		}
+		h.accountWrite(requestInfo.TenantId, func() (usageStats, error) {
+			return otlpRequestUsage(body, req.Header, h.options.MaxRequestSize)
+		})
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+
 	h.remoteWriteProxy.ServeHTTP(w, req)
 }
 
+// readRequestBody reads the write request body up to the maximum request size,
+// and responds the error if the body can't be read or is over the size.
+func (h *Handler) readRequestBody(w http.ResponseWriter, req *http.Request) ([]byte, bool) {
+	r := req.Body
+	if h.options.MaxRequestSize > 0 {
+		r = http.MaxBytesReader(w, r, h.options.MaxRequestSize)
+	}
+	defer r.Close()
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		var mbErr *http.MaxBytesError
+		if errors.As(err, &mbErr) {
+			http.Error(w, errRequestTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return body, true
+}
+
+// admitIngestion applies the ingestion rate limits of the tenant to a write request body,
+// and responds the rejection if the request is over the limits.
+// The samples are only counted if the sample rate of the tenant is limited.
+func (h *Handler) admitIngestion(w http.ResponseWriter, tenant string, body []byte, countSamples func() (int, error)) bool {
+	if h.limits == nil || tenant == "" {
+		return true
+	}
+
+	var samples int
+	if h.limits.samplesLimited(tenant) {
+		var err error
+		if samples, err = countSamples(); err != nil {
+			http.Error(w, err.Error(), decodeErrorStatus(err))
+			return false
+		}
+	}
+
+	if err := h.limits.AllowIngestion(tenant, len(body), samples); err != nil {
+		var rlErr *rateLimitError
+		if errors.As(err, &rlErr) {
+			writeRateLimitError(w, rlErr)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
+
 func NewSingleHostReverseProxy(target *url.URL, transport http.RoundTripper) *httputil.ReverseProxy {
 	proxy := httputil.NewSingleHostReverseProxy(target)
 
@@ -1,9 +1,15 @@
 package monitoringgateway
 
 import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func TestDifference(t *testing.T) {
@@ -17,3 +23,74 @@ func TestDifference(t *testing.T) {
 		t.Fatal(diff)
 	}
 }
+
+func TestMaxRequestSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	newHandler := func(otlpTenantAttribute string) *Handler {
+		limits := NewLimits(prometheus.NewRegistry())
+		limits.SetConfig(LimitsConfig{Tenants: map[string]TenantLimits{"t1": {SampleRate: 1000}}})
+		return NewHandler(nil, prometheus.NewRegistry(), &Options{
+			TenantHeader:        "WHIZARD-TENANT",
+			TenantLabelName:     "tenant_id",
+			RemoteWriteProxy:    NewSingleHostReverseProxy(target, http.DefaultTransport),
+			Limits:              limits,
+			OTLPTenantAttribute: otlpTenantAttribute,
+			MaxRequestSize:      1024,
+		})
+	}
+
+	// The gzip body is much smaller than the maximum request size, while it's decompressed over it.
+	var gzipBody bytes.Buffer
+	gw := gzip.NewWriter(&gzipBody)
+	_, _ = gw.Write(make([]byte, 64<<10))
+	_ = gw.Close()
+	if gzipBody.Len() > 1024 {
+		t.Fatalf("unexpected gzip body size %d", gzipBody.Len())
+	}
+
+	for name, tc := range map[string]struct {
+		handler *Handler
+		path    string
+		header  http.Header
+		body    []byte
+	}{
+		"remote write": {
+			handler: newHandler(""),
+			path:    "/t1/api/v1/receive",
+			header:  http.Header{"Content-Type": []string{"application/x-protobuf"}},
+			body:    make([]byte, 2048),
+		},
+		"push": {
+			handler: newHandler(""),
+			path:    "/t1/api/v1/push/job/batch",
+			body:    make([]byte, 2048),
+		},
+		"otlp": {
+			handler: newHandler(""),
+			path:    "/t1/api/v1/otlp",
+			header:  http.Header{"Content-Type": []string{"application/x-protobuf"}, "Content-Encoding": []string{"gzip"}},
+			body:    gzipBody.Bytes(),
+		},
+		"otlp tenant attribute": {
+			handler: newHandler("k8s.cluster.name"),
+			path:    "/t1/api/v1/otlp",
+			header:  http.Header{"Content-Type": []string{"application/x-protobuf"}, "Content-Encoding": []string{"gzip"}},
+			body:    gzipBody.Bytes(),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.path, bytes.NewReader(tc.body))
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
+			rec := httptest.NewRecorder()
+			tc.handler.Router().ServeHTTP(rec, req)
+			if rec.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("expected status 413, got %d: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
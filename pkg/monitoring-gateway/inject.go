@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/prometheus-community/prom-label-proxy/injectproxy"
@@ -17,7 +19,85 @@ const (
 	targetMatchersParam = "match_target[]"
 )
 
-func enforceQueryValues(e *injectproxy.PromQLEnforcer, v url.Values) (values string, hasQuery bool, err error) {
+// tenantEnforcer enforces the tenants of a request on the queries and matchers.
+// A single tenant is enforced by an equality matcher, replacing any tenant matcher of the query.
+// Multiple tenants are enforced by a regex matcher, and the tenant matchers of the query must select a subset of them.
+type tenantEnforcer struct {
+	labelName string
+	tenants   []string
+	matcher   *labels.Matcher
+	promql    *injectproxy.PromQLEnforcer
+}
+
+func newTenantEnforcer(labelName string, tenants []string) *tenantEnforcer {
+	matcher := &labels.Matcher{
+		Type:  labels.MatchEqual,
+		Name:  labelName,
+		Value: strings.Join(tenants, tenantSeparator),
+	}
+	if len(tenants) > 1 {
+		quoted := make([]string, 0, len(tenants))
+		for _, t := range tenants {
+			quoted = append(quoted, regexp.QuoteMeta(t))
+		}
+		matcher = labels.MustNewMatcher(labels.MatchRegexp, labelName, strings.Join(quoted, "|"))
+	}
+
+	return &tenantEnforcer{
+		labelName: labelName,
+		tenants:   tenants,
+		matcher:   matcher,
+		// Set errorOnReplace to false to directly replace the existing tenant with the new TenantId without reporting an error.
+		promql: injectproxy.NewPromQLEnforcer(false, matcher),
+	}
+}
+
+// validateMatchers rejects the tenant matchers not selecting a subset of the tenants of a multi-tenant request.
+func (e *tenantEnforcer) validateMatchers(ms []*labels.Matcher) error {
+	if len(e.tenants) <= 1 {
+		return nil
+	}
+
+	for _, m := range ms {
+		if m.Name != e.labelName {
+			continue
+		}
+
+		var values []string
+		switch m.Type {
+		case labels.MatchEqual:
+			values = []string{m.Value}
+		case labels.MatchRegexp:
+			frm, err := labels.NewFastRegexMatcher(m.Value)
+			if err == nil {
+				values = frm.SetMatches()
+			}
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("%w: label matcher %q is not a subset of the tenants %s", injectproxy.ErrIllegalLabelMatcher, m.String(), e.matcher.Value)
+		}
+		for _, v := range values {
+			if !slices.Contains(e.tenants, v) {
+				return fmt.Errorf("%w: label matcher %q is not a subset of the tenants %s", injectproxy.ErrIllegalLabelMatcher, m.String(), e.matcher.Value)
+			}
+		}
+	}
+	return nil
+}
+
+// validateNode validates the tenant matchers of all selectors of the expression.
+func (e *tenantEnforcer) validateNode(node parser.Node) error {
+	var err error
+	parser.Inspect(node, func(n parser.Node, _ []parser.Node) error {
+		if vs, ok := n.(*parser.VectorSelector); ok && err == nil {
+			err = e.validateMatchers(vs.LabelMatchers)
+		}
+		return err
+	})
+	return err
+}
+
+func enforceQueryValues(e *tenantEnforcer, v url.Values) (values string, hasQuery bool, err error) {
 	// If no values were given or no query is present,
 	// e.g. because the query came in the POST body
 	// but the URL query string was passed, then finish early.
@@ -30,7 +110,11 @@ func enforceQueryValues(e *injectproxy.PromQLEnforcer, v url.Values) (values str
 		return "", true, queryParseError
 	}
 
-	if err := e.EnforceNode(expr); err != nil {
+	if err := e.validateNode(expr); err != nil {
+		return "", true, err
+	}
+
+	if err := e.promql.EnforceNode(expr); err != nil {
 
 		if errors.Is(err, injectproxy.ErrIllegalLabelMatcher) {
 			return "", true, err
@@ -67,10 +151,10 @@ func newEnforceLabelError(err error) enforceLabelError {
 	return enforceLabelError{msg: fmt.Sprintf("error enforcing label %q", err.Error())}
 }
 
-func injectMatcher(q url.Values, matcher *labels.Matcher, matchersParam string) error {
+func injectMatcher(q url.Values, e *tenantEnforcer, matchersParam string) error {
 	matchers := q[matchersParam]
 	if len(matchers) == 0 {
-		q.Set(matchersParam, matchersToString(matcher))
+		q.Set(matchersParam, matchersToString(e.matcher))
 	} else {
 		// Inject label to existing matchers.
 		for i, m := range matchers {
@@ -78,7 +162,10 @@ func injectMatcher(q url.Values, matcher *labels.Matcher, matchersParam string)
 			if err != nil {
 				return err
 			}
-			matchers[i] = matchersToString(append(ms, matcher)...)
+			if err := e.validateMatchers(ms); err != nil {
+				return err
+			}
+			matchers[i] = matchersToString(append(ms, e.matcher)...)
 		}
 		q[matchersParam] = matchers
 	}
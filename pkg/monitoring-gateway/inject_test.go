@@ -0,0 +1,117 @@
+package monitoringgateway
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus-community/prom-label-proxy/injectproxy"
+)
+
+func TestEnforceQueryValues(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		tenants []string
+		query   string
+		want    string
+		illegal bool
+	}{
+		{
+			name:    "single tenant",
+			tenants: []string{"t1"},
+			query:   `up{tenant_id="t2"}`,
+			want:    `up{tenant_id="t1"}`,
+		},
+		{
+			name:    "multiple tenants",
+			tenants: []string{"t1", "t2"},
+			query:   `sum(rate(http_requests_total[5m]))`,
+			want:    `sum(rate(http_requests_total{tenant_id=~"t1|t2"}[5m]))`,
+		},
+		{
+			name:    "multiple tenants with a subset matcher",
+			tenants: []string{"t1", "t2"},
+			query:   `up{tenant_id="t1"} or up{tenant_id=~"t1|t2"}`,
+			want:    `up{tenant_id="t1",tenant_id=~"t1|t2"} or up{tenant_id=~"t1|t2"}`,
+		},
+		{
+			name:    "multiple tenants with the regex characters quoted",
+			tenants: []string{"t.1", "t2"},
+			query:   `up`,
+			want:    `up{tenant_id=~"t\\.1|t2"}`,
+		},
+		{
+			name:    "multiple tenants with another tenant",
+			tenants: []string{"t1", "t2"},
+			query:   `up{tenant_id="t3"}`,
+			illegal: true,
+		},
+		{
+			name:    "multiple tenants with a superset regex",
+			tenants: []string{"t1", "t2"},
+			query:   `up / on() group_left up{tenant_id=~".+"}`,
+			illegal: true,
+		},
+		{
+			name:    "multiple tenants with a negative matcher",
+			tenants: []string{"t1", "t2"},
+			query:   `up{tenant_id!="t1"}`,
+			illegal: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v := url.Values{queryParam: []string{tc.query}}
+			_, _, err := enforceQueryValues(newTenantEnforcer("tenant_id", tc.tenants), v)
+			if tc.illegal {
+				if !errors.Is(err, injectproxy.ErrIllegalLabelMatcher) {
+					t.Fatalf("expected illegal label matcher error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := v.Get(queryParam); got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestInjectMatcher(t *testing.T) {
+	e := newTenantEnforcer("tenant_id", []string{"t1", "t2"})
+
+	q := url.Values{}
+	if err := injectMatcher(q, e, matchersParam); err != nil {
+		t.Fatal(err)
+	}
+	if got := q.Get(matchersParam); got != `{tenant_id=~"t1|t2"}` {
+		t.Fatalf("unexpected matchers %s", got)
+	}
+
+	q = url.Values{matchersParam: []string{`up{tenant_id="t2"}`}}
+	if err := injectMatcher(q, e, matchersParam); err != nil {
+		t.Fatal(err)
+	}
+	if got := q.Get(matchersParam); got != `{tenant_id="t2",__name__="up",tenant_id=~"t1|t2"}` {
+		t.Fatalf("unexpected matchers %s", got)
+	}
+
+	q = url.Values{matchersParam: []string{`up{tenant_id=~"t2|t3"}`}}
+	if err := injectMatcher(q, e, matchersParam); !errors.Is(err, injectproxy.ErrIllegalLabelMatcher) {
+		t.Fatalf("expected illegal label matcher error, got %v", err)
+	}
+}
+
+func TestParseTenants(t *testing.T) {
+	tenants, err := parseTenants("t1|t2|t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tenants) != 2 || tenants[0] != "t1" || tenants[1] != "t2" {
+		t.Fatalf("unexpected tenants %v", tenants)
+	}
+	if _, err := parseTenants("t1||t2"); err == nil {
+		t.Fatal("expected error for an empty tenant")
+	}
+}
@@ -0,0 +1,196 @@
+package monitoringgateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// TenantsAPIGroup and TenantsResource form the virtual resource the tenant access is authorized on,
+	// with the tenant as the resource name and the verbs read and write.
+	TenantsAPIGroup = "monitoring.whizard.io"
+	TenantsResource = "tenants"
+
+	defaultKubernetesAuthCacheTTL = model.Duration(time.Minute)
+	maxKubernetesAuthCacheEntries = 10000
+)
+
+// KubernetesAuthenticator authenticates the requests by Kubernetes bearer tokens with the TokenReview API,
+// and authorizes the tenant access with SubjectAccessReviews, so that it can be granted by plain Kubernetes RBAC.
+// The reviews are cached for the configured TTL.
+type KubernetesAuthenticator struct {
+	client    kubernetes.Interface
+	audiences []string
+
+	tokens    *ttlCache[*Identity]
+	decisions *ttlCache[bool]
+}
+
+// NewKubernetesAuthenticator creates a KubernetesAuthenticator with the given client.
+func NewKubernetesAuthenticator(client kubernetes.Interface, cfg KubernetesAuthConfig) *KubernetesAuthenticator {
+	ttl := time.Duration(cfg.CacheTTL)
+	if ttl == 0 {
+		ttl = time.Duration(defaultKubernetesAuthCacheTTL)
+	}
+	return &KubernetesAuthenticator{
+		client:    client,
+		audiences: cfg.Audiences,
+		tokens:    newTTLCache[*Identity](ttl, maxKubernetesAuthCacheEntries),
+		decisions: newTTLCache[bool](ttl, maxKubernetesAuthCacheEntries),
+	}
+}
+
+// newKubernetesClient creates a client from the kubeconfig, or from the in-cluster configuration if empty.
+func newKubernetesClient(kubeconfig string) (kubernetes.Interface, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading kubernetes client configuration")
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
+
+func (a *KubernetesAuthenticator) AuthenticateRequest(req *http.Request) (*Identity, bool, error) {
+	token, ok := bearerToken(req)
+	if !ok {
+		return nil, false, nil
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+	identity, found := a.tokens.get(key)
+	if !found {
+		review, err := a.client.AuthenticationV1().TokenReviews().Create(req.Context(), &authenticationv1.TokenReview{
+			Spec: authenticationv1.TokenReviewSpec{
+				Token:     token,
+				Audiences: a.audiences,
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, false, errors.Wrap(err, "reviewing token")
+		}
+		if review.Status.Authenticated {
+			user := review.Status.User
+			identity = &Identity{
+				Name:       user.Username,
+				UID:        user.UID,
+				Groups:     user.Groups,
+				Extra:      make(map[string][]string, len(user.Extra)),
+				Authorizer: a,
+			}
+			for k, v := range user.Extra {
+				identity.Extra[k] = v
+			}
+		}
+		// Unauthenticated tokens are cached as well, so that they don't cause a review per request.
+		a.tokens.set(key, identity)
+	}
+
+	// Tokens not recognized by the API server are left to the next authenticators.
+	if identity == nil {
+		return nil, false, nil
+	}
+	return identity, true, nil
+}
+
+// Authorize implements the Authorizer interface with SubjectAccessReviews on the tenant.
+func (a *KubernetesAuthenticator) Authorize(ctx context.Context, identity *Identity, tenant, verb string) (bool, error) {
+	groups := append([]string(nil), identity.Groups...)
+	sort.Strings(groups)
+	key := strings.Join([]string{identity.Name, identity.UID, strings.Join(groups, ","), tenant, verb}, "\x00")
+	if allowed, found := a.decisions.get(key); found {
+		return allowed, nil
+	}
+
+	extra := make(map[string]authorizationv1.ExtraValue, len(identity.Extra))
+	for k, v := range identity.Extra {
+		extra[k] = v
+	}
+	review, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Group:    TenantsAPIGroup,
+				Resource: TenantsResource,
+				Name:     tenant,
+				Verb:     verb,
+			},
+			User:   identity.Name,
+			UID:    identity.UID,
+			Groups: identity.Groups,
+			Extra:  extra,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, errors.Wrap(err, "reviewing access")
+	}
+
+	a.decisions.set(key, review.Status.Allowed)
+	return review.Status.Allowed, nil
+}
+
+// ttlCache is a size bounded cache, whose entries expire after a fixed TTL.
+type ttlCache[V any] struct {
+	mtx        sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]ttlCacheEntry[V]
+	now        func() time.Time
+}
+
+type ttlCacheEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+func newTTLCache[V any](ttl time.Duration, maxEntries int) *ttlCache[V] {
+	return &ttlCache[V]{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]ttlCacheEntry[V]),
+		now:        time.Now,
+	}
+}
+
+func (c *ttlCache[V]) get(key string) (V, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || c.now().After(e.expires) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+func (c *ttlCache[V]) set(key string, value V) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= c.maxEntries {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		// Start over if all entries are live, rather than growing without bounds.
+		if len(c.entries) >= c.maxEntries {
+			c.entries = make(map[string]ttlCacheEntry[V])
+		}
+	}
+	c.entries[key] = ttlCacheEntry[V]{value: value, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,76 @@
+package monitoringgateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestKubernetesAuthenticator(t *testing.T) {
+	var tokenReviews, accessReviews int
+	client := fake.NewClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		tokenReviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "agent-token" {
+			review.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User: authenticationv1.UserInfo{
+					Username: "system:serviceaccount:monitoring:agent",
+					Groups:   []string{"system:serviceaccounts"},
+				},
+			}
+		}
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		accessReviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		review.Status.Allowed = attrs.Group == TenantsAPIGroup && attrs.Resource == TenantsResource &&
+			attrs.Name == "t1" && attrs.Verb == verbWrite && review.Spec.User == "system:serviceaccount:monitoring:agent"
+		return true, review, nil
+	})
+
+	authenticators := []Authenticator{NewKubernetesAuthenticator(client, KubernetesAuthConfig{})}
+	router := mux.NewRouter()
+	ok := func(w http.ResponseWriter, req *http.Request) {}
+	router.Path(apiTenantPrefix + epReceive).HandlerFunc(withRequestInfo(withAuthentication(ok, authenticators, func(string) {}), defaultTenantResolver))
+	router.Path(apiTenantPrefix + epQuery).HandlerFunc(withRequestInfo(withAuthentication(ok, authenticators, func(string) {}), defaultTenantResolver))
+
+	for _, tc := range []struct {
+		path  string
+		token string
+		code  int
+	}{
+		{path: "/t1/api/v1/receive", token: "agent-token", code: http.StatusOK},
+		{path: "/t1/api/v1/receive", token: "agent-token", code: http.StatusOK},
+		{path: "/t1/api/v1/query", token: "agent-token", code: http.StatusForbidden},
+		{path: "/t2/api/v1/receive", token: "agent-token", code: http.StatusForbidden},
+		{path: "/t1/api/v1/receive", token: "unknown-token", code: http.StatusUnauthorized},
+		{path: "/t1/api/v1/receive", token: "unknown-token", code: http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.code {
+			t.Fatalf("%s with %s: expected status %d, got %d: %s", tc.path, tc.token, tc.code, rec.Code, rec.Body.String())
+		}
+	}
+
+	// The reviews are cached, so that the repeated requests don't cause new reviews.
+	if tokenReviews != 2 {
+		t.Fatalf("expected 2 token reviews, got %d", tokenReviews)
+	}
+	if accessReviews != 3 {
+		t.Fatalf("expected 3 access reviews, got %d", accessReviews)
+	}
+}
@@ -0,0 +1,396 @@
+package monitoringgateway
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	limitReasonRequests = "requests"
+	limitReasonBytes    = "bytes"
+	limitReasonSamples  = "samples"
+
+	// limiterIdleTimeout is the time after which the limiters of the tenants without write requests are evicted.
+	limiterIdleTimeout = 10 * time.Minute
+)
+
+// TenantLimits defines the limits applied to a single tenant.
+// A zero rate disables the corresponding limit.
+type TenantLimits struct {
+	// RequestRate is the maximum number of write requests per second.
+	RequestRate float64 `yaml:"request_rate,omitempty"`
+	// RequestBurst is the maximum number of write requests allowed in a single burst.
+	// Defaults to the request rate.
+	RequestBurst int `yaml:"request_burst,omitempty"`
+	// IngestionRate is the maximum number of received (compressed) bytes per second.
+	IngestionRate float64 `yaml:"ingestion_rate_bytes,omitempty"`
+	// IngestionBurstSize is the maximum number of bytes allowed in a single burst.
+	// Defaults to the ingestion rate.
+	IngestionBurstSize int `yaml:"ingestion_burst_size_bytes,omitempty"`
+	// SampleRate is the maximum number of samples per second.
+	SampleRate float64 `yaml:"sample_rate,omitempty"`
+	// SampleBurst is the maximum number of samples allowed in a single burst.
+	// Defaults to the sample rate.
+	SampleBurst int `yaml:"sample_burst,omitempty"`
+
+	// MaxLabelNamesPerSeries is the maximum number of labels per series, including the metric name.
+	MaxLabelNamesPerSeries int `yaml:"max_label_names_per_series,omitempty"`
+	// MaxLabelNameLength is the maximum length of a label name.
+	MaxLabelNameLength int `yaml:"max_label_name_length,omitempty"`
+	// MaxLabelValueLength is the maximum length of a label value.
+	MaxLabelValueLength int `yaml:"max_label_value_length,omitempty"`
+	// MetricNameValidationScheme rejects series without a metric name, or with metric and label names
+	// not valid according to the scheme, one of "legacy" or "utf8".
+	MetricNameValidationScheme model.ValidationScheme `yaml:"metric_name_validation_scheme,omitempty"`
+	// RejectOldSamplesMaxAge rejects samples older than the given age.
+	RejectOldSamplesMaxAge model.Duration `yaml:"reject_old_samples_max_age,omitempty"`
+	// CreationGracePeriod rejects samples newer than the given duration in the future.
+	CreationGracePeriod model.Duration `yaml:"creation_grace_period,omitempty"`
+
+	// MaxQueryLength is the maximum time range of the range queries and series requests, including the ranges
+	// of the selectors, subqueries and offsets of the queries.
+	MaxQueryLength model.Duration `yaml:"max_query_length,omitempty"`
+	// MinQueryStep is the minimum resolution step of the range queries.
+	MinQueryStep model.Duration `yaml:"min_query_step,omitempty"`
+	// MaxQuerySeries is the maximum number of series returned by the queries and series requests.
+	MaxQuerySeries int `yaml:"max_query_series,omitempty"`
+	// QueryTimeout is the maximum evaluation time of the queries, lower timeouts of the requests are kept.
+	QueryTimeout model.Duration `yaml:"query_timeout,omitempty"`
+	// MaxConcurrentQueries is the maximum number of running query requests, the requests over it are queued.
+	MaxConcurrentQueries int `yaml:"max_concurrent_queries,omitempty"`
+	// MaxQueuedQueries is the maximum number of queued query requests, the requests over it are rejected.
+	MaxQueuedQueries int `yaml:"max_queued_queries,omitempty"`
+	// MaxFederateSeries is the maximum number of series returned by the federate requests.
+	MaxFederateSeries int `yaml:"max_federate_series,omitempty"`
+	// MaxFederateBytes is the maximum size in bytes of the exposition returned by the federate requests.
+	MaxFederateBytes int `yaml:"max_federate_bytes,omitempty"`
+}
+
+// validationEnabled returns true if any of the remote write validation rules is configured.
+func (l TenantLimits) validationEnabled() bool {
+	return l.MaxLabelNamesPerSeries > 0 ||
+		l.MaxLabelNameLength > 0 ||
+		l.MaxLabelValueLength > 0 ||
+		l.MetricNameValidationScheme != model.UnsetValidation ||
+		l.RejectOldSamplesMaxAge > 0 ||
+		l.CreationGracePeriod > 0
+}
+
+// LimitsConfig is the configuration of the per-tenant limits.
+// Tenant entries are merged on top of the defaults, so only the overridden fields need to be set.
+type LimitsConfig struct {
+	Defaults TenantLimits            `yaml:"defaults,omitempty"`
+	Tenants  map[string]TenantLimits `yaml:"tenants,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *LimitsConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw struct {
+		Defaults TenantLimits             `yaml:"defaults,omitempty"`
+		Tenants  map[string]yaml.MapSlice `yaml:"tenants,omitempty"`
+	}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	c.Defaults = raw.Defaults
+	c.Tenants = make(map[string]TenantLimits, len(raw.Tenants))
+	for tenant, overrides := range raw.Tenants {
+		limits := raw.Defaults
+		if len(overrides) > 0 {
+			buff, err := yaml.Marshal(overrides)
+			if err != nil {
+				return err
+			}
+			if err := yaml.UnmarshalStrict(buff, &limits); err != nil {
+				return errors.Wrapf(err, "parsing limits of tenant %s", tenant)
+			}
+		}
+		c.Tenants[tenant] = limits
+	}
+	return nil
+}
+
+// ParseLimitsConfig parses the raw limits configuration content.
+func ParseLimitsConfig(content []byte) (LimitsConfig, error) {
+	var config LimitsConfig
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return LimitsConfig{}, errors.Wrap(err, "parsing limits config YAML")
+	}
+	return config, nil
+}
+
+// Limits holds the per-tenant limits and enforces the ingestion rate limits.
+type Limits struct {
+	mtx      sync.RWMutex
+	config   LimitsConfig
+	limiters map[string]*tenantLimiter
+	// lastEviction is the unix nano time of the last eviction of the idle limiters.
+	lastEviction atomic.Int64
+
+	limitsGauge         *prometheus.GaugeVec
+	rateLimitedCounter  *prometheus.CounterVec
+	queryLimitedCounter *prometheus.CounterVec
+}
+
+type tenantLimiter struct {
+	requests *rate.Limiter
+	bytes    *rate.Limiter
+	samples  *rate.Limiter
+	// lastUsed is the unix nano time the limiter was last used.
+	lastUsed atomic.Int64
+}
+
+// idle returns true if the limiter is not used since the idle timeout and its buckets are full,
+// so that a new limiter of the tenant is the same.
+func (tl *tenantLimiter) idle(now time.Time) bool {
+	if now.Sub(time.Unix(0, tl.lastUsed.Load())) < limiterIdleTimeout {
+		return false
+	}
+	for _, limiter := range []*rate.Limiter{tl.requests, tl.bytes, tl.samples} {
+		if limiter.Limit() != rate.Inf && limiter.TokensAt(now) < float64(limiter.Burst()) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewLimits creates a new Limits without any limits configured.
+func NewLimits(reg prometheus.Registerer) *Limits {
+	return &Limits{
+		limiters: make(map[string]*tenantLimiter),
+
+		limitsGauge: promauto.With(reg).NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "whizard_gateway_tenant_limits",
+				Help: "The limits applied to the tenant, labeled by tenant and limit.",
+			},
+			[]string{"tenant", "limit"},
+		),
+		rateLimitedCounter: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_rate_limited_requests_total",
+				Help: "Total number of write requests rejected by the ingestion rate limits, labeled by tenant and reason.",
+			},
+			[]string{"tenant", "reason"},
+		),
+		queryLimitedCounter: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_query_limited_requests_total",
+				Help: "Total number of query requests rejected by the query limits, labeled by tenant and reason.",
+			},
+			[]string{"tenant", "reason"},
+		),
+	}
+}
+
+// SetConfig replaces the limits configuration and updates the limiters of the known tenants.
+func (l *Limits) SetConfig(config LimitsConfig) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.config = config
+
+	now := time.Now()
+	for tenant, limiter := range l.limiters {
+		limits := l.tenantLimitsLocked(tenant)
+		setLimit(limiter.requests, now, limits.RequestRate, limits.RequestBurst)
+		setLimit(limiter.bytes, now, limits.IngestionRate, limits.IngestionBurstSize)
+		setLimit(limiter.samples, now, limits.SampleRate, limits.SampleBurst)
+		l.exportLimits(tenant, limits)
+	}
+}
+
+// TenantLimits returns the limits of the given tenant.
+func (l *Limits) TenantLimits(tenant string) TenantLimits {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+
+	return l.tenantLimitsLocked(tenant)
+}
+
+func (l *Limits) tenantLimitsLocked(tenant string) TenantLimits {
+	if limits, ok := l.config.Tenants[tenant]; ok {
+		return limits
+	}
+	return l.config.Defaults
+}
+
+// samplesLimited returns true if the sample rate of the tenant is limited,
+// so that the callers only decode payloads when it's needed.
+func (l *Limits) samplesLimited(tenant string) bool {
+	return l.TenantLimits(tenant).SampleRate > 0
+}
+
+// AllowIngestion checks a write request of the given size against the ingestion rate limits of the tenant.
+// It returns a *rateLimitError if the request exceeds any of the limits, in which case no tokens are consumed.
+func (l *Limits) AllowIngestion(tenant string, bytes, samples int) error {
+	limiter := l.limiter(tenant)
+
+	now := time.Now()
+	checks := []struct {
+		reason  string
+		limiter *rate.Limiter
+		n       int
+	}{
+		{reason: limitReasonRequests, limiter: limiter.requests, n: 1},
+		{reason: limitReasonBytes, limiter: limiter.bytes, n: bytes},
+		{reason: limitReasonSamples, limiter: limiter.samples, n: samples},
+	}
+
+	var reservations []*rate.Reservation
+	cancel := func() {
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+	}
+
+	for _, c := range checks {
+		if c.n <= 0 || c.limiter.Limit() == rate.Inf {
+			continue
+		}
+
+		r := c.limiter.ReserveN(now, c.n)
+		if !r.OK() {
+			// The request is larger than the burst and can never be admitted as is.
+			cancel()
+			l.rateLimitedCounter.WithLabelValues(tenant, c.reason).Inc()
+			return &rateLimitError{tenant: tenant, reason: c.reason, burst: c.limiter.Burst()}
+		}
+		reservations = append(reservations, r)
+
+		if delay := r.DelayFrom(now); delay > 0 {
+			cancel()
+			l.rateLimitedCounter.WithLabelValues(tenant, c.reason).Inc()
+			return &rateLimitError{tenant: tenant, reason: c.reason, retryAfter: delay}
+		}
+	}
+
+	return nil
+}
+
+func (l *Limits) limiter(tenant string) *tenantLimiter {
+	now := time.Now()
+	if last := l.lastEviction.Load(); now.Sub(time.Unix(0, last)) >= limiterIdleTimeout &&
+		l.lastEviction.CompareAndSwap(last, now.UnixNano()) {
+		l.evictIdleLimiters(now)
+	}
+
+	l.mtx.RLock()
+	limiter, ok := l.limiters[tenant]
+	l.mtx.RUnlock()
+	if ok {
+		limiter.lastUsed.Store(now.UnixNano())
+		return limiter
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if limiter, ok := l.limiters[tenant]; ok {
+		limiter.lastUsed.Store(now.UnixNano())
+		return limiter
+	}
+	limits := l.tenantLimitsLocked(tenant)
+	limiter = &tenantLimiter{
+		requests: newLimiter(limits.RequestRate, limits.RequestBurst),
+		bytes:    newLimiter(limits.IngestionRate, limits.IngestionBurstSize),
+		samples:  newLimiter(limits.SampleRate, limits.SampleBurst),
+	}
+	limiter.lastUsed.Store(now.UnixNano())
+	l.limiters[tenant] = limiter
+	l.exportLimits(tenant, limits)
+
+	return limiter
+}
+
+// evictIdleLimiters removes the idle limiters and the exported limits of their tenants,
+// so that the limiters of the tenants no longer writing don't pile up.
+func (l *Limits) evictIdleLimiters(now time.Time) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	for tenant, limiter := range l.limiters {
+		if limiter.idle(now) {
+			delete(l.limiters, tenant)
+			l.limitsGauge.DeletePartialMatch(prometheus.Labels{"tenant": tenant})
+		}
+	}
+}
+
+func (l *Limits) exportLimits(tenant string, limits TenantLimits) {
+	l.limitsGauge.WithLabelValues(tenant, "request_rate").Set(limits.RequestRate)
+	l.limitsGauge.WithLabelValues(tenant, "request_burst").Set(float64(limitBurst(limits.RequestRate, limits.RequestBurst)))
+	l.limitsGauge.WithLabelValues(tenant, "ingestion_rate_bytes").Set(limits.IngestionRate)
+	l.limitsGauge.WithLabelValues(tenant, "ingestion_burst_size_bytes").Set(float64(limitBurst(limits.IngestionRate, limits.IngestionBurstSize)))
+	l.limitsGauge.WithLabelValues(tenant, "sample_rate").Set(limits.SampleRate)
+	l.limitsGauge.WithLabelValues(tenant, "sample_burst").Set(float64(limitBurst(limits.SampleRate, limits.SampleBurst)))
+}
+
+func newLimiter(r float64, burst int) *rate.Limiter {
+	if r <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(r), limitBurst(r, burst))
+}
+
+func setLimit(limiter *rate.Limiter, now time.Time, r float64, burst int) {
+	if r <= 0 {
+		limiter.SetLimitAt(now, rate.Inf)
+		limiter.SetBurstAt(now, 0)
+		return
+	}
+	limiter.SetLimitAt(now, rate.Limit(r))
+	limiter.SetBurstAt(now, limitBurst(r, burst))
+}
+
+// limitBurst returns the burst of a limit, which defaults to the rate.
+func limitBurst(r float64, burst int) int {
+	if burst > 0 || r <= 0 {
+		return burst
+	}
+	return int(math.Max(1, math.Ceil(r)))
+}
+
+// rateLimitError is returned when a tenant exceeds its ingestion rate limits.
+type rateLimitError struct {
+	tenant     string
+	reason     string
+	retryAfter time.Duration
+	// burst is the burst the request is larger than, in which case it can't be retried.
+	burst int
+}
+
+func (e *rateLimitError) Error() string {
+	if e.burst > 0 {
+		return fmt.Sprintf("the request of tenant %s exceeds the ingestion burst limit %d of %s", e.tenant, e.burst, e.reason)
+	}
+	return fmt.Sprintf("tenant %s exceeded the ingestion rate limit of %s, retry after %s", e.tenant, e.reason, e.retryAfter.Round(time.Millisecond))
+}
+
+// writeRateLimitError responds a 429 with the Retry-After header in seconds, or a 413 if the request is larger
+// than the burst, so that the clients don't retry it.
+func writeRateLimitError(w http.ResponseWriter, err *rateLimitError) {
+	if err.burst > 0 {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	seconds := int(math.Ceil(err.retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
@@ -0,0 +1,122 @@
+package monitoringgateway
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseLimitsConfig(t *testing.T) {
+	cfg, err := ParseLimitsConfig([]byte(`
+defaults:
+  request_rate: 10
+  sample_rate: 1000
+tenants:
+  a:
+    sample_rate: 50
+  b: {}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(cfg.Tenants["a"], TenantLimits{RequestRate: 10, SampleRate: 50}); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(cfg.Tenants["b"], cfg.Defaults); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if _, err := ParseLimitsConfig([]byte("tenants:\n  a:\n    unknown: 1\n")); err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+}
+
+func TestLimitsAllowIngestion(t *testing.T) {
+	l := NewLimits(prometheus.NewRegistry())
+	l.SetConfig(LimitsConfig{
+		Tenants: map[string]TenantLimits{
+			"a": {RequestRate: 1, RequestBurst: 2, SampleRate: 100},
+			"c": {SampleRate: 100},
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := l.AllowIngestion("a", 10, 10); err != nil {
+			t.Fatalf("request %d: unexpected error: %v", i, err)
+		}
+	}
+
+	var rlErr *rateLimitError
+	if err := l.AllowIngestion("a", 10, 10); !errors.As(err, &rlErr) || rlErr.reason != limitReasonRequests {
+		t.Fatalf("expected requests rate limit error, got %v", err)
+	}
+	if err := l.AllowIngestion("c", 10, 1000); !errors.As(err, &rlErr) || rlErr.burst != 100 {
+		t.Fatalf("expected the burst limit error, got %v", err)
+	}
+	// The requests larger than the burst are rejected without being retried.
+	rec := httptest.NewRecorder()
+	writeRateLimitError(rec, rlErr)
+	if rec.Code != http.StatusRequestEntityTooLarge || rec.Header().Get("Retry-After") != "" {
+		t.Fatalf("expected a 413 without Retry-After, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	rec = httptest.NewRecorder()
+	writeRateLimitError(rec, &rateLimitError{tenant: "a", reason: limitReasonRequests, retryAfter: 1500 * time.Millisecond})
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Fatalf("expected a 429 with Retry-After, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// Tenants without limits are not throttled.
+	for i := 0; i < 100; i++ {
+		if err := l.AllowIngestion("b", 1<<20, 1<<20); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reloading the config updates the limiters of known tenants.
+	l.SetConfig(LimitsConfig{})
+	if err := l.AllowIngestion("a", 10, 10); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimitsEvictIdleLimiters(t *testing.T) {
+	l := NewLimits(prometheus.NewRegistry())
+	l.SetConfig(LimitsConfig{Defaults: TenantLimits{RequestRate: 1, RequestBurst: 2}})
+
+	if err := l.AllowIngestion("a", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.AllowIngestion("b", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	// The limiters in use are kept.
+	l.evictIdleLimiters(time.Now())
+	if len(l.limiters) != 2 {
+		t.Fatalf("expected 2 limiters, got %d", len(l.limiters))
+	}
+
+	// The limiters of the idle tenants are evicted once their buckets are full.
+	l.limiters["b"].lastUsed.Store(time.Now().Add(-2 * limiterIdleTimeout).UnixNano())
+	l.evictIdleLimiters(time.Now().Add(limiterIdleTimeout / 2))
+	if _, ok := l.limiters["b"]; ok || len(l.limiters) != 1 {
+		t.Fatalf("expected the limiter of tenant b evicted, got %v", l.limiters)
+	}
+	if n := testutil.CollectAndCount(l.limitsGauge); n != 6 {
+		t.Fatalf("expected the limits of tenant a only, got %d series", n)
+	}
+
+	// A new limiter of the evicted tenant is created on use.
+	if err := l.AllowIngestion("b", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.limiters) != 2 {
+		t.Fatalf("expected 2 limiters, got %d", len(l.limiters))
+	}
+}
@@ -0,0 +1,131 @@
+package monitoringgateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+const (
+	metricParam = "metric"
+	limitParam  = "limit"
+)
+
+// metadata serves the metric metadata of the tenants. The metadata are not labeled by tenant,
+// so that they're filtered to the metric names of the tenant series.
+func (h *Handler) metadata(w http.ResponseWriter, req *http.Request) {
+	if h.queryProxy == nil {
+		http.Error(w, "The query target is not configured for the server", http.StatusNotAcceptable)
+		return
+	}
+
+	q := req.URL.Query()
+	limit := -1
+	if s := q.Get(limitParam); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil {
+			writeAPIError(w, &apiError{status: http.StatusBadRequest, errorType: errorTypeBadData, err: errors.New("limit must be a number")})
+			return
+		}
+	}
+
+	requestInfo, _ := requestInfoFrom(req.Context())
+	names, err := h.metricNames(req.Context(), newTenantEnforcer(h.options.TenantLabelName, requestInfo.Tenants), q.Get(metricParam))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	// The limit applies to the filtered metrics, so that it's not passed to the query target.
+	q.Del(limitParam)
+	req.URL.RawQuery = q.Encode()
+
+	filterResponseData(h.queryProxy, func(data map[string]json.RawMessage) error {
+		metrics := make([]string, 0, len(data))
+		for metric := range data {
+			if _, ok := names[metric]; !ok {
+				delete(data, metric)
+				continue
+			}
+			metrics = append(metrics, metric)
+		}
+		if limit >= 0 && len(metrics) > limit {
+			sort.Strings(metrics)
+			for _, metric := range metrics[limit:] {
+				delete(data, metric)
+			}
+		}
+		return nil
+	}).ServeHTTP(w, req)
+}
+
+// metricNames returns the metric names of the tenant series, optionally only the given metric.
+func (h *Handler) metricNames(ctx context.Context, e *tenantEnforcer, metric string) (map[string]struct{}, error) {
+	v := url.Values{}
+	if metric != "" {
+		v.Set(matchersParam, matchersToString(labels.MustNewMatcher(labels.MatchEqual, model.MetricNameLabel, metric)))
+	}
+	if err := injectMatcher(v, e, matchersParam); err != nil {
+		return nil, err
+	}
+
+	var values []string
+	if err := h.queryData(ctx, apiGlobalPrefix+"/label/"+model.MetricNameLabel+"/values", v, &values); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{}, len(values))
+	for _, name := range values {
+		names[name] = struct{}{}
+	}
+	return names, nil
+}
+
+// queryData requests the API path of the query target with the director and transport of the query proxy,
+// and decodes the data of the success response into data.
+func (h *Handler) queryData(ctx context.Context, path string, v url.Values, data any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path+"?"+v.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	h.queryProxy.Director(req)
+
+	transport := h.queryProxy.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to request the query target")
+	}
+	defer resp.Body.Close()
+
+	var apiResp struct {
+		Status string          `json:"status"`
+		Error  string          `json:"error"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return errors.Wrapf(err, "failed to decode the response of status %d", resp.StatusCode)
+	}
+	if apiResp.Status != "success" {
+		return errors.Errorf("failed to request the query target: %s", apiResp.Error)
+	}
+	return errors.Wrap(json.Unmarshal(apiResp.Data, data), "failed to decode the response data")
+}
+
+// forward proxies the requests not touching the tenant data to the query target as is.
+func (h *Handler) forward(w http.ResponseWriter, req *http.Request) {
+	if h.queryProxy == nil {
+		http.Error(w, "The query target is not configured for the server", http.StatusNotAcceptable)
+		return
+	}
+	h.queryProxy.ServeHTTP(w, req)
+}
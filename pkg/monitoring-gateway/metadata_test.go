@@ -0,0 +1,102 @@
+package monitoringgateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCompatibilityEndpoints(t *testing.T) {
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		requests = append(requests, req)
+		w.Header().Set("Content-Type", "application/json")
+
+		switch req.URL.Path {
+		case "/api/v1/label/__name__/values":
+			if req.Form.Get(matchersParam) == `{__name__="go_goroutines",tenant_id="t1"}` {
+				_, _ = w.Write([]byte(`{"status":"success","data":["go_goroutines"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"status":"success","data":["go_goroutines","up"]}`))
+		case "/api/v1/metadata":
+			_, _ = w.Write([]byte(`{"status":"success","data":{
+				"up":[{"type":"gauge","help":"up","unit":""}],
+				"go_goroutines":[{"type":"gauge","help":"goroutines","unit":""}],
+				"node_load1":[{"type":"gauge","help":"load","unit":""}]
+			}}`))
+		default:
+			_, _ = w.Write([]byte(`{"status":"success","data":[]}`))
+		}
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantLabelName: "tenant_id",
+		QueryProxy:      NewSingleHostReverseProxy(target, http.DefaultTransport),
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		requests = nil
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		return rec
+	}
+	metrics := func(rec *httptest.ResponseRecorder) []string {
+		var resp struct {
+			Data map[string]json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for name := range resp.Data {
+			names = append(names, name)
+		}
+		return names
+	}
+
+	t.Run("metadata", func(t *testing.T) {
+		rec := get("/t1/api/v1/metadata?limit=1")
+		if diff := cmp.Diff([]string{"go_goroutines"}, metrics(rec)); diff != "" {
+			t.Fatal(diff)
+		}
+		if m := requests[0].Form.Get(matchersParam); m != `{tenant_id="t1"}` {
+			t.Fatalf("unexpected matcher %s", m)
+		}
+		if requests[1].Form.Has(limitParam) {
+			t.Fatal("expected the limit not to be passed to the query target")
+		}
+
+		rec = get("/t1/api/v1/metadata?metric=go_goroutines")
+		if diff := cmp.Diff([]string{"go_goroutines"}, metrics(rec)); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("query exemplars", func(t *testing.T) {
+		get("/t1/api/v1/query_exemplars?" + url.Values{queryParam: {`up{job="a"}`}, startParam: {"0"}, endParam: {"60"}}.Encode())
+		if q := requests[0].Form.Get(queryParam); q != `up{job="a",tenant_id="t1"}` {
+			t.Fatalf("unexpected query %s", q)
+		}
+	})
+
+	for _, path := range []string{"/t1/api/v1/status/buildinfo", "/t1/api/v1/format_query?query=up", "/t1/api/v1/parse_query?query=up"} {
+		t.Run(path, func(t *testing.T) {
+			get(path)
+			if expected, _ := url.Parse(path[len("/t1"):]); requests[0].URL.Path != expected.Path {
+				t.Fatalf("expected path %s, got %s", expected.Path, requests[0].URL.Path)
+			}
+		})
+	}
+}
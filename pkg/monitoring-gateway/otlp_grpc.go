@@ -0,0 +1,112 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// OTLPGRPCServer receives the OTLP/gRPC metrics export requests. They are handled as the OTLP/HTTP requests
+// of the handler, so that they are authenticated, admitted, limited and forwarded the same way.
+// The tenants are resolved from the request metadata by the tenant headers, and from the client certificate
+// of the peer, or mapped from the tenant resource attribute of the handler.
+type OTLPGRPCServer struct {
+	pmetricotlp.UnimplementedGRPCServer
+
+	handler http.HandlerFunc
+}
+
+// NewOTLPGRPCServer returns an OTLP/gRPC receiver of the handler.
+func NewOTLPGRPCServer(h *Handler) *OTLPGRPCServer {
+	// The metadata are handled as the headers, and the client certificate of the peer as the HTTP one.
+	resolver := &TenantResolver{
+		Sources: []TenantSource{TenantSourceHeader, TenantSourceCert},
+		Headers: h.tenantResolver.Headers,
+	}
+	if len(resolver.Headers) == 0 {
+		resolver.Headers = []string{h.options.TenantHeader, OrgIDHeader}
+	}
+	if h.tenantResolver.resolvesFrom(TenantSourceClaim) {
+		resolver.Sources = append(resolver.Sources, TenantSourceClaim)
+	}
+
+	// Unlike the global HTTP routes, the requests without tenants are rejected rather than proxied as is.
+	return &OTLPGRPCServer{handler: h.otlpHandler(h.wrapWithResolver(h.otlpReceive, resolver))}
+}
+
+// Register registers the receiver as the OTLP metrics service of the gRPC server.
+func (s *OTLPGRPCServer) Register(srv *grpc.Server) {
+	pmetricotlp.RegisterGRPCServer(srv, s)
+}
+
+func (s *OTLPGRPCServer) Export(ctx context.Context, req pmetricotlp.ExportRequest) (pmetricotlp.ExportResponse, error) {
+	resp := pmetricotlp.NewExportResponse()
+
+	body, err := req.MarshalProto()
+	if err != nil {
+		return resp, status.Error(codes.Internal, err.Error())
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, apiGlobalPrefix+epOTLP, bytes.NewReader(body))
+	if err != nil {
+		return resp, status.Error(codes.Internal, err.Error())
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, vs := range md {
+		// The pseudo headers and the gRPC headers aren't meaningful to the HTTP handler.
+		if strings.HasPrefix(k, ":") || strings.HasPrefix(k, "grpc-") || k == "content-type" {
+			continue
+		}
+		httpReq.Header[http.CanonicalHeaderKey(k)] = vs
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	if p, ok := peer.FromContext(ctx); ok {
+		httpReq.RemoteAddr = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			httpReq.TLS = &info.State
+		}
+	}
+
+	w := &bufferedResponseWriter{header: http.Header{}}
+	s.handler(w, httpReq)
+
+	if w.statusCode() >= 300 {
+		return resp, status.Error(grpcCode(w.statusCode()), strings.TrimSpace(w.body.String()))
+	}
+	// The export responses report the partial success, if any.
+	if presp, ok := w.exportResponse(); ok {
+		resp = presp
+	}
+	return resp, nil
+}
+
+// grpcCode returns the gRPC status code of an HTTP status code, the retryable statuses are kept retryable
+// as required by the OTLP specification.
+func grpcCode(statusCode int) codes.Code {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotAcceptable, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+	if statusCode >= 500 {
+		return codes.Internal
+	}
+	return codes.Unknown
+}
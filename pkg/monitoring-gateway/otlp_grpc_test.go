@@ -0,0 +1,110 @@
+package monitoringgateway
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestOTLPGRPCServer(t *testing.T) {
+	var (
+		tenants    []string
+		statusCode = http.StatusOK
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		if req.URL.Path != "/api/v1/otlp" {
+			t.Errorf("unexpected path %s", req.URL.Path)
+		}
+		if _, err := decodeOTLPRequest(body, req.Header, 0); err != nil {
+			t.Errorf("unexpected request body: %v", err)
+		}
+		tenants = append(tenants, req.Header.Get("WHIZARD-TENANT"))
+		w.WriteHeader(statusCode)
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantHeader:        "WHIZARD-TENANT",
+		TenantLabelName:     "tenant_id",
+		OTLPTenantAttribute: "k8s.cluster.name",
+		RemoteWriteProxy:    NewSingleHostReverseProxy(target, http.DefaultTransport),
+	})
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	NewOTLPGRPCServer(h).Register(srv)
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pmetricotlp.NewGRPCClient(conn)
+
+	export := func(ctx context.Context, clusters ...string) codes.Code {
+		tenants = nil
+		metrics := pmetric.NewMetrics()
+		for _, cluster := range clusters {
+			rm := metrics.ResourceMetrics().AppendEmpty()
+			if cluster != "" {
+				rm.Resource().Attributes().PutStr("k8s.cluster.name", cluster)
+			}
+			rm.ScopeMetrics().AppendEmpty().Metrics().AppendEmpty().SetEmptyGauge().DataPoints().AppendEmpty().SetDoubleValue(1)
+		}
+		_, err := client.Export(ctx, pmetricotlp.NewExportRequestFromMetrics(metrics))
+		return status.Code(err)
+	}
+	withTenant := metadata.AppendToOutgoingContext(context.Background(), OrgIDHeader, "t1")
+
+	for _, tc := range []struct {
+		name     string
+		ctx      context.Context
+		clusters []string
+		code     codes.Code
+		tenants  []string
+	}{
+		{name: "metadata tenant", ctx: withTenant, clusters: []string{""}, code: codes.OK, tenants: []string{"t1"}},
+		{name: "attribute tenant", ctx: withTenant, clusters: []string{"c1", "c1"}, code: codes.OK, tenants: []string{"c1"}},
+		{name: "no tenant", ctx: context.Background(), clusters: []string{""}, code: codes.NotFound},
+		{name: "split tenants", ctx: withTenant, clusters: []string{"c1", "", "c2"}, code: codes.OK, tenants: []string{"c1", "t1", "c2"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if code := export(tc.ctx, tc.clusters...); code != tc.code {
+				t.Fatalf("expected code %s, got %s", tc.code, code)
+			}
+			if diff := cmp.Diff(tc.tenants, tenants); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+
+	t.Run("retryable", func(t *testing.T) {
+		statusCode = http.StatusServiceUnavailable
+		defer func() { statusCode = http.StatusOK }()
+		if code := export(withTenant, ""); code != codes.Unavailable {
+			t.Fatalf("expected code %s, got %s", codes.Unavailable, code)
+		}
+	})
+}
@@ -0,0 +1,214 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+)
+
+// otlpPart is the part of an OTLP request of a tenant.
+type otlpPart struct {
+	// tenant is the tenant mapped from the resource attribute, or empty for the resources without the attribute.
+	tenant  string
+	metrics pmetric.Metrics
+}
+
+// splitOTLPRequest splits the resource metrics of a request by the tenant resource attribute, in the order
+// the tenants first appear.
+func splitOTLPRequest(req pmetricotlp.ExportRequest, attribute string) []otlpPart {
+	var (
+		parts []otlpPart
+		index = map[string]int{}
+	)
+	rms := req.Metrics().ResourceMetrics()
+	for i := 0; i < rms.Len(); i++ {
+		var tenant string
+		if v, ok := rms.At(i).Resource().Attributes().Get(attribute); ok {
+			tenant = v.AsString()
+		}
+		n, ok := index[tenant]
+		if !ok {
+			n = len(parts)
+			index[tenant] = n
+			parts = append(parts, otlpPart{tenant: tenant, metrics: pmetric.NewMetrics()})
+		}
+		rms.At(i).CopyTo(parts[n].metrics.ResourceMetrics().AppendEmpty())
+	}
+	return parts
+}
+
+// splitOTLP splits the resource metrics of the OTLP requests by the tenant resource attribute. The parts of the
+// attribute tenants are handled as the requests of the tenants, while the resources without the attribute are
+// handled by next as the request of the resolved tenant. Every part is authenticated, admitted and limited as
+// a request of its own.
+//
+// The request fails with a retryable status if a part does before any part is forwarded, so that the client retries
+// the whole request. Otherwise the rejected parts are reported by the partial success of the response, unless all
+// parts are rejected, so that the forwarded parts are not duplicated by the retries.
+func (h *Handler) splitOTLP(next http.HandlerFunc) http.HandlerFunc {
+	attribute := h.options.OTLPTenantAttribute
+	mapped := h.wrapWithResolver(h.otlpReceive, defaultTenantResolver)
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, ok := h.readRequestBody(w, req)
+		if !ok {
+			return
+		}
+
+		ereq, err := decodeOTLPRequest(body, req.Header, h.options.MaxRequestSize)
+		if err != nil {
+			http.Error(w, err.Error(), decodeErrorStatus(err))
+			return
+		}
+		parts := splitOTLPRequest(ereq, attribute)
+		if len(parts) <= 1 && (len(parts) == 0 || parts[0].tenant == "") {
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			next(w, req)
+			return
+		}
+
+		var (
+			resp     = pmetricotlp.NewExportResponse()
+			rejected []string
+			failed   *bufferedResponseWriter
+			failures int
+			accepted int
+		)
+		for _, part := range parts {
+			partBody, err := pmetricotlp.NewExportRequestFromMetrics(part.metrics).MarshalProto()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			partReq := req.Clone(req.Context())
+			partReq.Body = io.NopCloser(bytes.NewReader(partBody))
+			partReq.ContentLength = int64(len(partBody))
+			partReq.Header.Set("Content-Type", "application/x-protobuf")
+			partReq.Header.Del("Content-Encoding")
+
+			handler, desc := next, fmt.Sprintf("the resources without the %s attribute", attribute)
+			if part.tenant != "" {
+				partReq.URL.Path = "/" + part.tenant + apiGlobalPrefix + epOTLP
+				partReq = mux.SetURLVars(partReq, map[string]string{"tenant_id": part.tenant})
+				handler, desc = mapped, "tenant "+part.tenant
+			}
+			rec := &bufferedResponseWriter{header: http.Header{}}
+			handler(rec, partReq)
+
+			switch code := rec.statusCode(); {
+			case code < 300:
+				accepted++
+				// The partial success of the downstream, if any, is reported too.
+				if presp, ok := rec.exportResponse(); ok && presp.PartialSuccess().RejectedDataPoints() > 0 {
+					ps := presp.PartialSuccess()
+					resp.PartialSuccess().SetRejectedDataPoints(resp.PartialSuccess().RejectedDataPoints() + ps.RejectedDataPoints())
+					rejected = append(rejected, fmt.Sprintf("%s: %s", desc, ps.ErrorMessage()))
+				}
+			case (code == http.StatusTooManyRequests || code >= 500) && accepted == 0:
+				// The retryable failure fails the whole request, as long as no part is forwarded.
+				rec.copyTo(w)
+				return
+			default:
+				failures++
+				if failed == nil {
+					failed = rec
+				}
+				resp.PartialSuccess().SetRejectedDataPoints(resp.PartialSuccess().RejectedDataPoints() + int64(part.metrics.DataPointCount()))
+				rejected = append(rejected, fmt.Sprintf("%s: %s", desc, strings.TrimSpace(rec.body.String())))
+			}
+		}
+		if failures == len(parts) {
+			failed.copyTo(w)
+			return
+		}
+		if len(rejected) > 0 {
+			resp.PartialSuccess().SetErrorMessage(strings.Join(rejected, "; "))
+		}
+
+		var (
+			out         []byte
+			contentType = "application/x-protobuf"
+		)
+		if req.Header.Get("Content-Type") == "application/json" {
+			out, err = resp.MarshalJSON()
+			contentType = "application/json"
+		} else {
+			out, err = resp.MarshalProto()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(out)
+	}
+}
+
+// bufferedResponseWriter buffers the response of a request handled internally, such as the parts of the split
+// OTLP requests and the OTLP/gRPC requests.
+type bufferedResponseWriter struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+func (w *bufferedResponseWriter) statusCode() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+// exportResponse decodes the buffered OTLP export response, by its content type.
+func (w *bufferedResponseWriter) exportResponse() (pmetricotlp.ExportResponse, bool) {
+	resp := pmetricotlp.NewExportResponse()
+	if w.body.Len() == 0 {
+		return resp, false
+	}
+
+	var err error
+	switch contentType := w.header.Get("Content-Type"); {
+	case strings.HasPrefix(contentType, "application/x-protobuf"):
+		err = resp.UnmarshalProto(w.body.Bytes())
+	case strings.HasPrefix(contentType, "application/json"):
+		err = resp.UnmarshalJSON(w.body.Bytes())
+	default:
+		return resp, false
+	}
+	if err != nil {
+		return pmetricotlp.NewExportResponse(), false
+	}
+	return resp, true
+}
+
+// copyTo writes the buffered response to w.
+func (w *bufferedResponseWriter) copyTo(to http.ResponseWriter) {
+	for k, vs := range w.header {
+		to.Header()[k] = vs
+	}
+	to.WriteHeader(w.statusCode())
+	_, _ = to.Write(w.body.Bytes())
+}
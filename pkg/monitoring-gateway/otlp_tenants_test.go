@@ -0,0 +1,151 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+)
+
+func TestSplitOTLP(t *testing.T) {
+	var (
+		received = map[string]int{}
+		// unavailable is the tenant the downstream fails the requests of.
+		unavailable string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		ereq, err := decodeOTLPRequest(body, req.Header, 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tenant := req.Header.Get("WHIZARD-TENANT")
+		if tenant == unavailable {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		received[tenant] += ereq.Metrics().DataPointCount()
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantHeader:            "WHIZARD-TENANT",
+		TenantLabelName:         "tenant_id",
+		RemoteWriteProxy:        NewSingleHostReverseProxy(target, http.DefaultTransport),
+		EnabledTenantsAdmission: true,
+		OTLPTenantAttribute:     "k8s.cluster.name",
+	})
+	if err := h.SetAdmissionControlHandler(AdmissionControlConfig{Tenants: []string{"t1", "c1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	export := func(contentType string, clusters ...string) (*httptest.ResponseRecorder, pmetricotlp.ExportResponse) {
+		received = map[string]int{}
+		metrics := pmetric.NewMetrics()
+		for _, cluster := range clusters {
+			rm := metrics.ResourceMetrics().AppendEmpty()
+			if cluster != "" {
+				rm.Resource().Attributes().PutStr("k8s.cluster.name", cluster)
+			}
+			rm.ScopeMetrics().AppendEmpty().Metrics().AppendEmpty().SetEmptyGauge().DataPoints().AppendEmpty().SetDoubleValue(1)
+		}
+		ereq := pmetricotlp.NewExportRequestFromMetrics(metrics)
+		body, err := ereq.MarshalProto()
+		if contentType == "application/json" {
+			body, err = ereq.MarshalJSON()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/t1/api/v1/otlp", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, req)
+
+		resp := pmetricotlp.NewExportResponse()
+		if rec.Code == http.StatusOK && rec.Body.Len() > 0 {
+			if contentType == "application/json" {
+				err = resp.UnmarshalJSON(rec.Body.Bytes())
+			} else {
+				err = resp.UnmarshalProto(rec.Body.Bytes())
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec, resp
+	}
+
+	t.Run("split", func(t *testing.T) {
+		rec, resp := export("application/x-protobuf", "c1", "", "c1")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if diff := cmp.Diff(map[string]int{"c1": 2, "t1": 1}, received); diff != "" {
+			t.Fatal(diff)
+		}
+		if n := resp.PartialSuccess().RejectedDataPoints(); n != 0 {
+			t.Fatalf("expected no rejected data points, got %d", n)
+		}
+	})
+
+	t.Run("partial success", func(t *testing.T) {
+		rec, resp := export("application/json", "c1", "c2", "c2")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if diff := cmp.Diff(map[string]int{"c1": 1}, received); diff != "" {
+			t.Fatal(diff)
+		}
+		if n := resp.PartialSuccess().RejectedDataPoints(); n != 2 {
+			t.Fatalf("expected 2 rejected data points, got %d", n)
+		}
+		if msg := resp.PartialSuccess().ErrorMessage(); !strings.Contains(msg, "tenant c2 is not allowed to access") {
+			t.Fatalf("unexpected error message %q", msg)
+		}
+	})
+
+	t.Run("all rejected", func(t *testing.T) {
+		if rec, _ := export("application/x-protobuf", "c2", "c3"); rec.Code != http.StatusForbidden {
+			t.Fatalf("expected status 403, got %d", rec.Code)
+		}
+	})
+
+	t.Run("retryable", func(t *testing.T) {
+		unavailable = "c1"
+		defer func() { unavailable = "" }()
+		if rec, _ := export("application/x-protobuf", "c1", ""); rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected status 503, got %d", rec.Code)
+		}
+		if len(received) != 0 {
+			t.Fatalf("expected no parts forwarded, got %v", received)
+		}
+	})
+
+	t.Run("retryable after forwarded", func(t *testing.T) {
+		unavailable = "c1"
+		defer func() { unavailable = "" }()
+		// The forwarded part is not failed, so that it's not duplicated by the retries.
+		rec, resp := export("application/x-protobuf", "", "c1")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if diff := cmp.Diff(map[string]int{"t1": 1}, received); diff != "" {
+			t.Fatal(diff)
+		}
+		if n := resp.PartialSuccess().RejectedDataPoints(); n != 1 {
+			t.Fatalf("expected 1 rejected data point, got %d", n)
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+const (
+	epPush         = "/push"
+	epPushJob      = "/push/job/{job}"
+	epPushInstance = "/push/job/{job}/instance/{instance}"
+
+	// pushFallbackContentType is the format of the pushed bodies without a content type.
+	pushFallbackContentType = "text/plain"
+)
+
+// push receives the metrics in the Prometheus text or OpenMetrics exposition format, as Pushgateway does,
+// and writes them as a remote write request of the tenant. The job and instance path segments are set as
+// the grouping labels of the pushed series, overriding the labels of the same names.
+func (h *Handler) push(w http.ResponseWriter, req *http.Request) {
+	if h.remoteWriteProxy == nil {
+		http.Error(w, "There is no remote write targets configured for the server", http.StatusNotAcceptable)
+		return
+	}
+
+	body, ok := h.readRequestBody(w, req)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(req)
+	var grouping []labels.Label
+	for _, name := range []string{model.JobLabel, model.InstanceLabel} {
+		if v, ok := vars[name]; ok {
+			grouping = append(grouping, labels.Label{Name: name, Value: v})
+		}
+	}
+
+	wreq, err := parseExposition(body, req.Header.Get("Content-Type"), grouping, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(wreq.Timeseries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	encoded, err := encodeWriteRequest(wreq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(encoded))
+	req.ContentLength = int64(len(encoded))
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", appProtoContentType)
+	req.Header.Set(remote.RemoteWriteVersionHeader, remote.RemoteWriteVersion1HeaderValue)
+	h.remoteWrite(w, req)
+}
+
+// parseExposition parses an exposition body into a remote write request. The samples without timestamps are
+// stamped with now, and the grouping labels are set to all of the series.
+func parseExposition(body []byte, contentType string, grouping []labels.Label, now time.Time) (*prompb.WriteRequest, error) {
+	if contentType == "" {
+		contentType = pushFallbackContentType
+	}
+	p, err := textparse.New(body, contentType, "", false, false, false, labels.NewSymbolTable())
+	if p == nil {
+		if err == nil {
+			err = errors.Errorf("unsupported content type %s", contentType)
+		}
+		return nil, errors.Wrap(err, "parsing the pushed metrics")
+	}
+
+	var (
+		wreq     prompb.WriteRequest
+		metadata = map[string]*prompb.MetricMetadata{}
+		// families keeps the order of the metric families of the metadata.
+		families []string
+		lset     labels.Labels
+		ex       exemplar.Exemplar
+		builder  = labels.NewScratchBuilder(0)
+		defaultT = now.UnixMilli()
+	)
+	familyMetadata := func(name []byte) *prompb.MetricMetadata {
+		m, ok := metadata[string(name)]
+		if !ok {
+			m = &prompb.MetricMetadata{MetricFamilyName: string(name)}
+			metadata[string(name)] = m
+			families = append(families, string(name))
+		}
+		return m
+	}
+
+	for {
+		entry, err := p.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing the pushed metrics")
+		}
+
+		switch entry {
+		case textparse.EntryType:
+			name, typ := p.Type()
+			familyMetadata(name).Type = prompb.FromMetadataType(typ)
+			continue
+		case textparse.EntryHelp:
+			name, help := p.Help()
+			familyMetadata(name).Help = string(help)
+			continue
+		case textparse.EntryUnit:
+			name, unit := p.Unit()
+			familyMetadata(name).Unit = string(unit)
+			continue
+		case textparse.EntrySeries, textparse.EntryHistogram:
+		default:
+			continue
+		}
+
+		p.Labels(&lset)
+		builder.Reset()
+		lset.Range(func(l labels.Label) {
+			for _, g := range grouping {
+				if l.Name == g.Name {
+					return
+				}
+			}
+			builder.Add(l.Name, l.Value)
+		})
+		for _, g := range grouping {
+			builder.Add(g.Name, g.Value)
+		}
+		builder.Sort()
+
+		ts := prompb.TimeSeries{Labels: prompb.FromLabels(builder.Labels(), nil)}
+		if entry == textparse.EntryHistogram {
+			_, t, h, fh := p.Histogram()
+			if t == nil {
+				t = &defaultT
+			}
+			if h != nil {
+				ts.Histograms = []prompb.Histogram{prompb.FromIntHistogram(*t, h)}
+			} else {
+				ts.Histograms = []prompb.Histogram{prompb.FromFloatHistogram(*t, fh)}
+			}
+		} else {
+			_, t, v := p.Series()
+			if t == nil {
+				t = &defaultT
+			}
+			ts.Samples = []prompb.Sample{{Value: v, Timestamp: *t}}
+		}
+		for p.Exemplar(&ex) {
+			e := prompb.Exemplar{Labels: prompb.FromLabels(ex.Labels, nil), Value: ex.Value, Timestamp: defaultT}
+			if ex.HasTs {
+				e.Timestamp = ex.Ts
+			}
+			ts.Exemplars = append(ts.Exemplars, e)
+			ex = exemplar.Exemplar{}
+		}
+		wreq.Timeseries = append(wreq.Timeseries, ts)
+	}
+
+	for _, name := range families {
+		wreq.Metadata = append(wreq.Metadata, *metadata[name])
+	}
+	return &wreq, nil
+}
@@ -0,0 +1,169 @@
+package monitoringgateway
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestPush(t *testing.T) {
+	var (
+		received *prompb.WriteRequest
+		tenant   string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		wreq, err := decodeWriteRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received, tenant = wreq, req.Header.Get("WHIZARD-TENANT")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantHeader:     "WHIZARD-TENANT",
+		TenantLabelName:  "tenant_id",
+		RemoteWriteProxy: NewSingleHostReverseProxy(target, http.DefaultTransport),
+	})
+
+	push := func(path, contentType, body string) int {
+		received = nil
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("text", func(t *testing.T) {
+		code := push("/t1/api/v1/push/job/batch/instance/node1", "", `# HELP job_duration_seconds Duration of the job.
+# TYPE job_duration_seconds gauge
+job_duration_seconds{job="other",stage="load"} 12.5 1700000000000
+`)
+		if code != http.StatusNoContent {
+			t.Fatalf("expected status 204, got %d", code)
+		}
+		if tenant != "t1" {
+			t.Fatalf("expected tenant t1, got %s", tenant)
+		}
+		if diff := cmp.Diff(&prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{{
+				Labels: []prompb.Label{
+					{Name: "__name__", Value: "job_duration_seconds"},
+					{Name: "instance", Value: "node1"},
+					{Name: "job", Value: "batch"},
+					{Name: "stage", Value: "load"},
+				},
+				Samples: []prompb.Sample{{Value: 12.5, Timestamp: 1700000000000}},
+			}},
+			Metadata: []prompb.MetricMetadata{{
+				Type:             prompb.MetricMetadata_GAUGE,
+				MetricFamilyName: "job_duration_seconds",
+				Help:             "Duration of the job.",
+			}},
+		}, received); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("openmetrics", func(t *testing.T) {
+		code := push("/t1/api/v1/push", "application/openmetrics-text; version=1.0.0", `# TYPE jobs counter
+jobs_total 3 # {trace_id="abc"} 1 1700000000.000
+# EOF
+`)
+		if code != http.StatusNoContent {
+			t.Fatalf("expected status 204, got %d", code)
+		}
+		if len(received.Timeseries) != 1 {
+			t.Fatalf("expected 1 series, got %d", len(received.Timeseries))
+		}
+		ts := received.Timeseries[0]
+		if diff := cmp.Diff([]prompb.Label{{Name: "__name__", Value: "jobs_total"}}, ts.Labels); diff != "" {
+			t.Fatal(diff)
+		}
+		// The samples without timestamps are stamped with the push time.
+		if len(ts.Samples) != 1 || ts.Samples[0].Value != 3 || ts.Samples[0].Timestamp == 0 {
+			t.Fatalf("unexpected samples %v", ts.Samples)
+		}
+		if diff := cmp.Diff([]prompb.Exemplar{{
+			Labels:    []prompb.Label{{Name: "trace_id", Value: "abc"}},
+			Value:     1,
+			Timestamp: 1700000000000,
+		}}, ts.Exemplars); diff != "" {
+			t.Fatal(diff)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if code := push("/t1/api/v1/push", "", "up{"); code != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %d", code)
+		}
+		if code := push("/t1/api/v1/push", "application/json", "{}"); code != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %d", code)
+		}
+		if received != nil {
+			t.Fatal("expected the invalid bodies not to be forwarded")
+		}
+	})
+}
+
+// readOnlyAuthorizer allows the identities to read the tenants only.
+type readOnlyAuthorizer struct{}
+
+func (readOnlyAuthorizer) Authorize(_ context.Context, _ *Identity, _, verb string) (bool, error) {
+	return verb == verbRead, nil
+}
+
+// identityAuthenticator authenticates all requests as the identity.
+type identityAuthenticator struct {
+	identity *Identity
+}
+
+func (a identityAuthenticator) AuthenticateRequest(*http.Request) (*Identity, bool, error) {
+	return a.identity, true, nil
+}
+
+func TestPushAuthorization(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantHeader:     "WHIZARD-TENANT",
+		TenantLabelName:  "tenant_id",
+		QueryProxy:       NewSingleHostReverseProxy(target, http.DefaultTransport),
+		RemoteWriteProxy: NewSingleHostReverseProxy(target, http.DefaultTransport),
+		Authenticators:   []Authenticator{identityAuthenticator{&Identity{Name: "reader", Authorizer: readOnlyAuthorizer{}}}},
+	})
+
+	// The pushes are writes, which the read-only identities are not allowed to, and which are single-tenant.
+	for path, code := range map[string]int{
+		"/t1/api/v1/push":                          http.StatusForbidden,
+		"/t1/api/v1/push/job/batch":                http.StatusForbidden,
+		"/t1/api/v1/push/job/batch/instance/node1": http.StatusForbidden,
+		"/t1|t2/api/v1/push":                       http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("up 1\n"))
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, req)
+		if rec.Code != code {
+			t.Fatalf("%s: expected status %d, got %d: %s", path, code, rec.Code, rec.Body.String())
+		}
+	}
+}
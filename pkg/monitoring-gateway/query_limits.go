@@ -0,0 +1,339 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+const (
+	startParam   = "start"
+	endParam     = "end"
+	stepParam    = "step"
+	timeoutParam = "timeout"
+)
+
+// The error types of the Prometheus API error responses.
+const (
+	errorTypeBadData     = "bad_data"
+	errorTypeExecution   = "execution"
+	errorTypeTimeout     = "timeout"
+	errorTypeCanceled    = "canceled"
+	errorTypeUnavailable = "unavailable"
+)
+
+const (
+	queryLimitReasonLength  = "query_length"
+	queryLimitReasonStep    = "query_step"
+	queryLimitReasonSeries  = "series"
+	queryLimitReasonTimeout = "timeout"
+
+	queryLimitReasonFederateSeries = "federate_series"
+	queryLimitReasonFederateBytes  = "federate_bytes"
+)
+
+// apiError is an error responded in the Prometheus API error format.
+type apiError struct {
+	status    int
+	errorType string
+	err       error
+	// limitReason is the reason of the rejection if the request is rejected by the query limits.
+	limitReason string
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+// writeAPIError responds the error in the Prometheus API error format.
+func writeAPIError(w http.ResponseWriter, err *apiError) {
+	b, _ := json.Marshal(map[string]string{
+		"status":    "error",
+		"errorType": err.errorType,
+		"error":     err.Error(),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.status)
+	_, _ = w.Write(b)
+}
+
+// QueryLimits returns the query limits of the tenants, the strictest limits are applied to the multi-tenant queries.
+func (l *Limits) QueryLimits(tenants []string) TenantLimits {
+	var limits TenantLimits
+	if l == nil {
+		return limits
+	}
+	for _, tenant := range tenants {
+		tl := l.TenantLimits(tenant)
+		limits.MaxQueryLength = minLimit(limits.MaxQueryLength, tl.MaxQueryLength)
+		limits.MaxQuerySeries = minLimit(limits.MaxQuerySeries, tl.MaxQuerySeries)
+		limits.QueryTimeout = minLimit(limits.QueryTimeout, tl.QueryTimeout)
+		limits.MaxConcurrentQueries = minLimit(limits.MaxConcurrentQueries, tl.MaxConcurrentQueries)
+		limits.MaxQueuedQueries = minLimit(limits.MaxQueuedQueries, tl.MaxQueuedQueries)
+		limits.MaxFederateSeries = minLimit(limits.MaxFederateSeries, tl.MaxFederateSeries)
+		limits.MaxFederateBytes = minLimit(limits.MaxFederateBytes, tl.MaxFederateBytes)
+		if tl.MinQueryStep > limits.MinQueryStep {
+			limits.MinQueryStep = tl.MinQueryStep
+		}
+	}
+	return limits
+}
+
+// writeQueryError responds the error of a query request, counting the rejection if it's rejected by the query limits.
+func (h *Handler) writeQueryError(w http.ResponseWriter, tenant string, err *apiError) {
+	if err.limitReason != "" && h.limits != nil {
+		h.limits.queryLimitedCounter.WithLabelValues(tenant, err.limitReason).Inc()
+	}
+	writeAPIError(w, err)
+}
+
+// minLimit returns the lower of the limits, where zero is unlimited.
+func minLimit[T int | model.Duration](a, b T) T {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// checkQueryRange checks the time range and step of a query, series, labels or label values request against the limits.
+// The requests but the instant queries select the full time range without the start or end, so that they are rejected
+// if the query length is limited.
+func checkQueryRange(limits TenantLimits, path string, form func(string) string) *apiError {
+	rangeQuery := strings.HasSuffix(path, epQueryRange)
+
+	var length time.Duration
+	if limits.MaxQueryLength > 0 && !strings.HasSuffix(path, epQuery) && (form(startParam) == "" || form(endParam) == "") {
+		return &apiError{
+			status:      http.StatusBadRequest,
+			errorType:   errorTypeBadData,
+			err:         fmt.Errorf("the start and end parameters are required by the query time range limit %s", limits.MaxQueryLength),
+			limitReason: queryLimitReasonLength,
+		}
+	}
+	if limits.MaxQueryLength > 0 && form(startParam) != "" && form(endParam) != "" {
+		start, err := parseTime(form(startParam))
+		if err != nil {
+			return &apiError{status: http.StatusBadRequest, errorType: errorTypeBadData, err: errors.Wrap(err, "invalid parameter \"start\"")}
+		}
+		end, err := parseTime(form(endParam))
+		if err != nil {
+			return &apiError{status: http.StatusBadRequest, errorType: errorTypeBadData, err: errors.Wrap(err, "invalid parameter \"end\"")}
+		}
+		if length = end.Sub(start); length > time.Duration(limits.MaxQueryLength) {
+			return &apiError{
+				status:      http.StatusBadRequest,
+				errorType:   errorTypeBadData,
+				err:         fmt.Errorf("the query time range %s exceeds the limit %s", model.Duration(length), limits.MaxQueryLength),
+				limitReason: queryLimitReasonLength,
+			}
+		}
+	}
+
+	// The range selectors, subqueries and offsets of the query widen the time range of the selected data beyond
+	// the evaluated range. The unparsable queries are rejected later by the tenant enforcement.
+	if limits.MaxQueryLength > 0 && form(queryParam) != "" {
+		if expr, err := parser.ParseExpr(form(queryParam)); err == nil {
+			if span := length + selectedTimeSpan(expr); span > time.Duration(limits.MaxQueryLength) {
+				return &apiError{
+					status:      http.StatusBadRequest,
+					errorType:   errorTypeBadData,
+					err:         fmt.Errorf("the time range %s of the data selected by the query exceeds the limit %s", model.Duration(span), limits.MaxQueryLength),
+					limitReason: queryLimitReasonLength,
+				}
+			}
+		}
+	}
+
+	if rangeQuery && limits.MinQueryStep > 0 && form(stepParam) != "" {
+		step, err := parseDuration(form(stepParam))
+		if err != nil {
+			return &apiError{status: http.StatusBadRequest, errorType: errorTypeBadData, err: errors.Wrap(err, "invalid parameter \"step\"")}
+		}
+		if step < time.Duration(limits.MinQueryStep) {
+			return &apiError{
+				status:      http.StatusBadRequest,
+				errorType:   errorTypeBadData,
+				err:         fmt.Errorf("the query resolution step %s is lower than the limit %s", model.Duration(step), limits.MinQueryStep),
+				limitReason: queryLimitReasonStep,
+			}
+		}
+	}
+	return nil
+}
+
+// selectedTimeSpan returns how much the time range of the data selected by the expression exceeds the evaluated
+// time range, which is the span from the earliest to the latest sample selected relative to the evaluation times.
+func selectedTimeSpan(expr parser.Expr) time.Duration {
+	// The earliest and latest selected samples are the durations before the evaluation times.
+	var earliest, latest time.Duration
+	found := false
+	parser.Inspect(expr, func(node parser.Node, path []parser.Node) error {
+		vs, ok := node.(*parser.VectorSelector)
+		if !ok {
+			return nil
+		}
+		// The selector is evaluated at the times shifted by its offset and the offsets of the enclosing subqueries,
+		// and selects the samples of the range before them.
+		end := vs.OriginalOffset
+		var window time.Duration
+		for _, n := range path {
+			switch n := n.(type) {
+			case *parser.MatrixSelector:
+				window += n.Range
+			case *parser.SubqueryExpr:
+				end += n.OriginalOffset
+				window += n.Range
+			}
+		}
+		if !found || end+window > earliest {
+			earliest = end + window
+		}
+		if !found || end < latest {
+			latest = end
+		}
+		found = true
+		return nil
+	})
+	return earliest - latest
+}
+
+// capQueryTimeout caps the timeout parameter of the query values to the limit, it returns false if it's kept.
+func capQueryTimeout(limits TenantLimits, v url.Values) bool {
+	if limits.QueryTimeout <= 0 {
+		return false
+	}
+	if timeout, err := parseDuration(v.Get(timeoutParam)); err == nil && timeout > 0 && timeout <= time.Duration(limits.QueryTimeout) {
+		return false
+	}
+	v.Set(timeoutParam, limits.QueryTimeout.String())
+	return true
+}
+
+// limitQueryResponse returns a copy of the proxy which applies the series and timeout limits to the query responses.
+func (h *Handler) limitQueryResponse(proxy *httputil.ReverseProxy, req *http.Request, limits TenantLimits) (*httputil.ReverseProxy, *http.Request, context.CancelFunc) {
+	cancel := func() {}
+	if limits.MaxQuerySeries <= 0 && limits.QueryTimeout <= 0 {
+		return proxy, req, cancel
+	}
+
+	requestInfo, _ := requestInfoFrom(req.Context())
+	p := *proxy // 浅拷贝
+	if limits.QueryTimeout > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(req.Context(), time.Duration(limits.QueryTimeout))
+		req = req.WithContext(ctx)
+	}
+	if limits.MaxQuerySeries > 0 {
+		originalDirector := p.Director
+		p.Director = func(req *http.Request) {
+			originalDirector(req)
+			// The response is decoded, so that it's requested uncompressed.
+			req.Header.Del("Accept-Encoding")
+		}
+		p.ModifyResponse = func(resp *http.Response) error {
+			return checkResponseSeries(resp, limits.MaxQuerySeries)
+		}
+	}
+	p.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		var apiErr *apiError
+		switch {
+		case errors.As(err, &apiErr):
+			// The response is rejected by the series limit.
+		case errors.Is(err, context.DeadlineExceeded):
+			apiErr = &apiError{
+				status:      http.StatusServiceUnavailable,
+				errorType:   errorTypeTimeout,
+				err:         fmt.Errorf("the query exceeded the timeout %s", limits.QueryTimeout),
+				limitReason: queryLimitReasonTimeout,
+			}
+		default:
+			apiErr = &apiError{status: http.StatusBadGateway, errorType: errorTypeExecution, err: err}
+		}
+		h.writeQueryError(w, requestInfo.TenantId, apiErr)
+	}
+	return &p, req, cancel
+}
+
+// checkResponseSeries returns an *apiError if the successful query or series response has more series than the limit.
+func checkResponseSeries(resp *http.Response, maxSeries int) error {
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var apiResp struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &apiResp); err != nil {
+		return nil
+	}
+
+	// The data of the series responses is the series, while it's the result of the query responses.
+	var series []json.RawMessage
+	if err := json.Unmarshal(apiResp.Data, &series); err != nil {
+		var data struct {
+			ResultType string            `json:"resultType"`
+			Result     []json.RawMessage `json:"result"`
+		}
+		if err := json.Unmarshal(apiResp.Data, &data); err != nil || (data.ResultType != "vector" && data.ResultType != "matrix") {
+			return nil
+		}
+		series = data.Result
+	}
+
+	if len(series) > maxSeries {
+		return &apiError{
+			status:      http.StatusUnprocessableEntity,
+			errorType:   errorTypeExecution,
+			err:         fmt.Errorf("the query returned %d series, which exceeds the limit %d", len(series), maxSeries),
+			limitReason: queryLimitReasonSeries,
+		}
+	}
+	return nil
+}
+
+// parseTime parses a timestamp in the formats of the Prometheus API, either a unix timestamp or RFC3339.
+func parseTime(s string) (time.Time, error) {
+	if t, err := strconv.ParseFloat(s, 64); err == nil {
+		s, ns := math.Modf(t)
+		return time.Unix(int64(s), int64(math.Round(ns*1000))*int64(time.Millisecond)).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.Errorf("cannot parse %q to a valid timestamp", s)
+}
+
+// parseDuration parses a duration in the formats of the Prometheus API, either seconds or a duration string.
+func parseDuration(s string) (time.Duration, error) {
+	if d, err := strconv.ParseFloat(s, 64); err == nil {
+		ts := d * float64(time.Second)
+		if ts > float64(math.MaxInt64) || ts < float64(math.MinInt64) {
+			return 0, errors.Errorf("cannot parse %q to a valid duration. It overflows int64", s)
+		}
+		return time.Duration(ts), nil
+	}
+	if d, err := model.ParseDuration(s); err == nil {
+		return time.Duration(d), nil
+	}
+	return 0, errors.Errorf("cannot parse %q to a valid duration", s)
+}
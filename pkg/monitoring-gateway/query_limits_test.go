@@ -0,0 +1,236 @@
+package monitoringgateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+func TestQueryLimits(t *testing.T) {
+	// The timeouts of the proxied queries are captured by the backend, which may still be running
+	// once the gateway has given up.
+	timeouts := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		select {
+		case timeouts <- req.Form.Get(timeoutParam):
+		default:
+		}
+		if strings.Contains(req.Form.Get(queryParam), "slow") {
+			select {
+			case <-req.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"instance":"a"},"values":[[1,"1"]]},
+			{"metric":{"instance":"b"},"values":[[1,"1"]]},
+			{"metric":{"instance":"c"},"values":[[1,"1"]]}
+		]}}`))
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	limits := NewLimits(prometheus.NewRegistry())
+	limits.SetConfig(LimitsConfig{
+		Tenants: map[string]TenantLimits{
+			"t1": {
+				MaxQueryLength: model.Duration(24 * time.Hour),
+				MinQueryStep:   model.Duration(15 * time.Second),
+				MaxQuerySeries: 2,
+				QueryTimeout:   model.Duration(200 * time.Millisecond),
+			},
+			"t2": {},
+		},
+	})
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantLabelName: "tenant_id",
+		QueryProxy:      NewSingleHostReverseProxy(target, http.DefaultTransport),
+		Limits:          limits,
+	})
+
+	for _, tc := range []struct {
+		name      string
+		method    string
+		path      string
+		params    url.Values
+		code      int
+		errorType string
+		timeout   string
+	}{
+		{
+			name:   "unlimited tenant",
+			path:   "/t2/api/v1/query_range",
+			params: url.Values{queryParam: {"up"}, startParam: {"0"}, endParam: {"31536000"}, stepParam: {"1"}},
+			code:   http.StatusOK,
+		},
+		{
+			name:      "query range too long",
+			path:      "/t1/api/v1/query_range",
+			params:    url.Values{queryParam: {"up"}, startParam: {"0"}, endParam: {"31536000"}, stepParam: {"60"}},
+			code:      http.StatusBadRequest,
+			errorType: errorTypeBadData,
+		},
+		{
+			name:      "range selector too long",
+			path:      "/t1/api/v1/query",
+			params:    url.Values{queryParam: {"max_over_time(up[10y])"}},
+			code:      http.StatusBadRequest,
+			errorType: errorTypeBadData,
+		},
+		{
+			name:      "range selector of a short range query",
+			path:      "/t1/api/v1/query_range",
+			params:    url.Values{queryParam: {"rate(up[365d])"}, startParam: {"0"}, endParam: {"3600"}, stepParam: {"60"}},
+			code:      http.StatusBadRequest,
+			errorType: errorTypeBadData,
+		},
+		{
+			name:      "subquery too long",
+			path:      "/t1/api/v1/query",
+			params:    url.Values{queryParam: {"max_over_time(rate(up[5m])[30d:1h])"}},
+			code:      http.StatusBadRequest,
+			errorType: errorTypeBadData,
+		},
+		{
+			name:      "offsets too far apart",
+			path:      "/t1/api/v1/query",
+			params:    url.Values{queryParam: {"up - up offset 30d"}},
+			code:      http.StatusBadRequest,
+			errorType: errorTypeBadData,
+		},
+		{
+			name:      "series without start",
+			path:      "/t1/api/v1/series",
+			params:    url.Values{"match[]": {"up"}, endParam: {"3600"}},
+			code:      http.StatusBadRequest,
+			errorType: errorTypeBadData,
+		},
+		{
+			name:      "labels without range",
+			path:      "/t1/api/v1/labels",
+			code:      http.StatusBadRequest,
+			errorType: errorTypeBadData,
+		},
+		{
+			name:      "label values range too long",
+			path:      "/t1/api/v1/label/job/values",
+			params:    url.Values{startParam: {"0"}, endParam: {"31536000"}},
+			code:      http.StatusBadRequest,
+			errorType: errorTypeBadData,
+		},
+		{
+			name:   "label values within range",
+			path:   "/t1/api/v1/label/job/values",
+			params: url.Values{startParam: {"0"}, endParam: {"3600"}},
+			code:   http.StatusOK,
+		},
+		{
+			name: "labels of unlimited tenant without range",
+			path: "/t2/api/v1/labels",
+			code: http.StatusOK,
+		},
+		{
+			name:      "query step too low",
+			method:    http.MethodPost,
+			path:      "/t1/api/v1/query_range",
+			params:    url.Values{queryParam: {"up"}, startParam: {"2024-01-01T00:00:00Z"}, endParam: {"2024-01-01T01:00:00Z"}, stepParam: {"1s"}},
+			code:      http.StatusBadRequest,
+			errorType: errorTypeBadData,
+		},
+		{
+			name:      "too many series",
+			path:      "/t1/api/v1/query_range",
+			params:    url.Values{queryParam: {"up"}, startParam: {"0"}, endParam: {"3600"}, stepParam: {"60"}},
+			code:      http.StatusUnprocessableEntity,
+			errorType: errorTypeExecution,
+			timeout:   "200ms",
+		},
+		{
+			name:      "series of multiple tenants",
+			path:      "/t1|t2/api/v1/query",
+			params:    url.Values{queryParam: {"up"}, timeoutParam: {"100ms"}},
+			code:      http.StatusUnprocessableEntity,
+			errorType: errorTypeExecution,
+			timeout:   "100ms",
+		},
+		{
+			name:      "query timeout",
+			path:      "/t1/api/v1/query",
+			params:    url.Values{queryParam: {"slow"}, timeoutParam: {"1m"}},
+			code:      http.StatusServiceUnavailable,
+			errorType: errorTypeTimeout,
+			timeout:   "200ms",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for len(timeouts) > 0 {
+				<-timeouts
+			}
+			var req *http.Request
+			if tc.method == http.MethodPost {
+				req = httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.params.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest(http.MethodGet, tc.path+"?"+tc.params.Encode(), nil)
+			}
+			rec := httptest.NewRecorder()
+			h.Router().ServeHTTP(rec, req)
+
+			if rec.Code != tc.code {
+				t.Fatalf("expected status %d, got %d: %s", tc.code, rec.Code, rec.Body.String())
+			}
+			if tc.errorType != "" {
+				var resp struct {
+					Status    string `json:"status"`
+					ErrorType string `json:"errorType"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Status != "error" || resp.ErrorType != tc.errorType {
+					t.Fatalf("unexpected error response %s", rec.Body.String())
+				}
+			}
+			if tc.timeout != "" {
+				select {
+				case timeout := <-timeouts:
+					if timeout != tc.timeout {
+						t.Fatalf("expected timeout %s, got %s", tc.timeout, timeout)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("timed out waiting for the proxied query")
+				}
+			}
+		})
+	}
+}
+
+func TestSelectedTimeSpan(t *testing.T) {
+	for query, span := range map[string]time.Duration{
+		"up":                                     0,
+		"rate(up[5m])":                           5 * time.Minute,
+		"rate(up[5m] offset 1h)":                 5 * time.Minute,
+		"max_over_time(rate(up[5m])[1h:1m])":     time.Hour + 5*time.Minute,
+		"up - up offset 1d":                      24 * time.Hour,
+		"rate(up[5m]) - rate(up[5m] offset -1h)": time.Hour + 5*time.Minute,
+	} {
+		expr, err := parser.ParseExpr(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := selectedTimeSpan(expr); got != span {
+			t.Fatalf("expected the time span %s of %s, got %s", span, query, got)
+		}
+	}
+}
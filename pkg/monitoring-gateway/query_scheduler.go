@@ -0,0 +1,332 @@
+package monitoringgateway
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	queueRejectReasonFull    = "queue_full"
+	queueRejectReasonTimeout = "timeout"
+)
+
+// queryScheduler admits the query requests of the tenants by the per-tenant and global concurrency limits.
+// The requests over the limits are queued per tenant, and the queues are served round robin when the running
+// requests finish, so that the tenants with many requests don't starve the others.
+// The multi-tenant requests take a slot of every tenant, while they are counted once by the global limit.
+type queryScheduler struct {
+	mtx sync.Mutex
+	// maxConcurrency is the maximum number of running requests of all tenants, zero is unlimited.
+	maxConcurrency int
+	queueTimeout   time.Duration
+	running        int
+	tenants        map[string]*tenantQueue
+	// order are the tenants with queued requests in the round robin order.
+	order []string
+	next  int
+
+	queueLength     *prometheus.GaugeVec
+	inflightQueries *prometheus.GaugeVec
+	queueDuration   prometheus.Histogram
+	rejectedCounter *prometheus.CounterVec
+}
+
+type tenantQueue struct {
+	running int
+	limits  TenantLimits
+	queued  *list.List
+}
+
+type queuedQuery struct {
+	ready   chan struct{}
+	granted bool
+	// global is true if the request is counted by the global concurrency limit, which is false for the slots
+	// of the multi-tenant requests but the first one.
+	global bool
+}
+
+func newQueryScheduler(reg prometheus.Registerer, maxConcurrency int, queueTimeout time.Duration) *queryScheduler {
+	return &queryScheduler{
+		maxConcurrency: maxConcurrency,
+		queueTimeout:   queueTimeout,
+		tenants:        make(map[string]*tenantQueue),
+
+		queueLength: promauto.With(reg).NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "whizard_gateway_query_queue_length",
+				Help: "Number of the queued query requests, labeled by tenant.",
+			},
+			[]string{"tenant"},
+		),
+		inflightQueries: promauto.With(reg).NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "whizard_gateway_inflight_queries",
+				Help: "Number of the running query requests, labeled by tenant.",
+			},
+			[]string{"tenant"},
+		),
+		queueDuration: promauto.With(reg).NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "whizard_gateway_query_queue_duration_seconds",
+				Help:    "Time the query requests spent in the queue.",
+				Buckets: prometheus.ExponentialBuckets(0.005, 4, 8),
+			},
+		),
+		rejectedCounter: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_query_queue_rejected_total",
+				Help: "Total number of query requests rejected by the query queue, labeled by tenant and reason.",
+			},
+			[]string{"tenant", "reason"},
+		),
+	}
+}
+
+// acquire admits a query request of the tenant, waiting in the tenant queue if it's over the concurrency limits.
+// It returns the release func to call once the request is done, or an *apiError if the request is rejected.
+func (s *queryScheduler) acquire(ctx context.Context, tenant string, limits TenantLimits) (func(), *apiError) {
+	return s.acquireSlot(ctx, tenant, limits, true)
+}
+
+// acquireAll admits a query request of the tenants by acquiring a slot of every tenant, with the limits of the tenant.
+// The slots are acquired in the sorted order of the tenants, so that the requests of the overlapping tenants don't
+// deadlock each other, and only the first slot is counted by the global concurrency limit.
+func (s *queryScheduler) acquireAll(ctx context.Context, tenants []string, limits func(tenant string) TenantLimits) (func(), *apiError) {
+	tenants = slices.Compact(slices.Sorted(slices.Values(tenants)))
+	if len(tenants) == 0 {
+		// The global requests without tenants share a queue.
+		tenants = []string{""}
+	}
+
+	releases := make([]func(), 0, len(tenants))
+	release := func() {
+		for _, release := range slices.Backward(releases) {
+			release()
+		}
+	}
+	for i, tenant := range tenants {
+		r, err := s.acquireSlot(ctx, tenant, limits(tenant), i == 0)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+	return release, nil
+}
+
+// acquireSlot acquires a slot of the tenant, which is counted by the global concurrency limit if global is true.
+// The slots which are not counted are acquired by the requests holding a global slot already, so that they are
+// queued ahead of the others, and started regardless of the global limit.
+func (s *queryScheduler) acquireSlot(ctx context.Context, tenant string, limits TenantLimits, global bool) (func(), *apiError) {
+	start := time.Now()
+
+	s.mtx.Lock()
+	tq, ok := s.tenants[tenant]
+	if !ok {
+		tq = &tenantQueue{queued: list.New()}
+		s.tenants[tenant] = tq
+	}
+	// The limits may be changed by the reloads, the latest limits of the tenant are applied.
+	tq.limits = limits
+
+	if (tq.queued.Len() == 0 || !global) && s.runnable(tq, global) {
+		s.start(tenant, tq, global)
+		s.mtx.Unlock()
+		s.queueDuration.Observe(0)
+		return s.releaseFunc(tenant, global), nil
+	}
+	if limits.MaxQueuedQueries > 0 && tq.queued.Len() >= limits.MaxQueuedQueries {
+		s.mtx.Unlock()
+		s.rejectedCounter.WithLabelValues(tenant, queueRejectReasonFull).Inc()
+		return nil, &apiError{
+			status:    http.StatusTooManyRequests,
+			errorType: errorTypeUnavailable,
+			err:       fmt.Errorf("too many queued queries of tenant %s", tenant),
+		}
+	}
+
+	q := &queuedQuery{ready: make(chan struct{}), global: global}
+	var e *list.Element
+	if global {
+		e = tq.queued.PushBack(q)
+	} else {
+		e = tq.queued.Front()
+		for e != nil && !e.Value.(*queuedQuery).global {
+			e = e.Next()
+		}
+		if e != nil {
+			e = tq.queued.InsertBefore(q, e)
+		} else {
+			e = tq.queued.PushBack(q)
+		}
+	}
+	if tq.queued.Len() == 1 {
+		s.order = append(s.order, tenant)
+	}
+	s.queueLength.WithLabelValues(tenant).Set(float64(tq.queued.Len()))
+	s.mtx.Unlock()
+
+	var timeout <-chan time.Time
+	if s.queueTimeout > 0 {
+		timer := time.NewTimer(s.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err *apiError
+	select {
+	case <-q.ready:
+	case <-timeout:
+		err = &apiError{
+			status:    http.StatusServiceUnavailable,
+			errorType: errorTypeTimeout,
+			err:       fmt.Errorf("the query of tenant %s timed out in the queue after %s", tenant, s.queueTimeout),
+		}
+	case <-ctx.Done():
+		err = &apiError{status: http.StatusServiceUnavailable, errorType: errorTypeCanceled, err: ctx.Err()}
+	}
+
+	if err != nil {
+		s.mtx.Lock()
+		// The request may be started while it's timing out, then it runs anyway.
+		if !q.granted {
+			tq.queued.Remove(e)
+			s.queueLength.WithLabelValues(tenant).Set(float64(tq.queued.Len()))
+			if tq.queued.Len() == 0 {
+				s.removeFromOrder(tenant)
+			}
+			s.cleanup(tenant, tq)
+			s.mtx.Unlock()
+			if err.errorType == errorTypeTimeout {
+				s.rejectedCounter.WithLabelValues(tenant, queueRejectReasonTimeout).Inc()
+			}
+			return nil, err
+		}
+		s.mtx.Unlock()
+	}
+
+	s.queueDuration.Observe(time.Since(start).Seconds())
+	return s.releaseFunc(tenant, global), nil
+}
+
+func (s *queryScheduler) releaseFunc(tenant string, global bool) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mtx.Lock()
+			defer s.mtx.Unlock()
+
+			tq := s.tenants[tenant]
+			tq.running--
+			if global {
+				s.running--
+			}
+			s.inflightQueries.WithLabelValues(tenant).Set(float64(tq.running))
+			s.dispatch()
+			s.cleanup(tenant, tq)
+		})
+	}
+}
+
+// runnable returns true if a request of the tenant can be started by the concurrency limits,
+// the global limit is only checked if the request is counted by it.
+func (s *queryScheduler) runnable(tq *tenantQueue, global bool) bool {
+	return (!global || s.maxConcurrency <= 0 || s.running < s.maxConcurrency) &&
+		(tq.limits.MaxConcurrentQueries <= 0 || tq.running < tq.limits.MaxConcurrentQueries)
+}
+
+func (s *queryScheduler) start(tenant string, tq *tenantQueue, global bool) {
+	tq.running++
+	if global {
+		s.running++
+	}
+	s.inflightQueries.WithLabelValues(tenant).Set(float64(tq.running))
+}
+
+// dispatch starts the queued requests round robin over the tenants, as long as the concurrency limits allow.
+// It stops once none of the tenants with queued requests can start one.
+func (s *queryScheduler) dispatch() {
+	for skipped := 0; len(s.order) > 0 && skipped < len(s.order); {
+		if s.next >= len(s.order) {
+			s.next = 0
+		}
+		tenant := s.order[s.next]
+		tq := s.tenants[tenant]
+		if !s.runnable(tq, tq.queued.Front().Value.(*queuedQuery).global) {
+			s.next++
+			skipped++
+			continue
+		}
+
+		q := tq.queued.Remove(tq.queued.Front()).(*queuedQuery)
+		q.granted = true
+		close(q.ready)
+		s.start(tenant, tq, q.global)
+		s.queueLength.WithLabelValues(tenant).Set(float64(tq.queued.Len()))
+		skipped = 0
+
+		if tq.queued.Len() == 0 {
+			s.removeFromOrder(tenant)
+		} else {
+			s.next++
+		}
+	}
+}
+
+// removeFromOrder removes the tenant without queued requests from the round robin order.
+func (s *queryScheduler) removeFromOrder(tenant string) {
+	for i, t := range s.order {
+		if t == tenant {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			if s.next > i {
+				s.next--
+			}
+			return
+		}
+	}
+}
+
+// cleanup removes the idle tenant, together with its metrics.
+func (s *queryScheduler) cleanup(tenant string, tq *tenantQueue) {
+	if tq.running > 0 || tq.queued.Len() > 0 {
+		return
+	}
+	delete(s.tenants, tenant)
+	s.queueLength.DeleteLabelValues(tenant)
+	s.inflightQueries.DeleteLabelValues(tenant)
+}
+
+// schedule admits the query requests by the query scheduler before handling them by f,
+// and accounts the usage of the requests if the usage metrics are enabled.
+func (h *Handler) schedule(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		requestInfo, _ := requestInfoFrom(req.Context())
+
+		release, err := h.queryScheduler.acquireAll(req.Context(), requestInfo.Tenants, func(tenant string) TenantLimits {
+			return h.limits.QueryLimits([]string{tenant})
+		})
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		defer release()
+
+		if h.usage == nil {
+			f(w, req)
+			return
+		}
+		start := time.Now()
+		cw := &countingResponseWriter{ResponseWriter: w}
+		f(cw, req)
+		h.usage.observeQuery(requestInfo.Tenants, time.Since(start), cw.written)
+	}
+}
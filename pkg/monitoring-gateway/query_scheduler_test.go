@@ -0,0 +1,233 @@
+package monitoringgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestQuerySchedulerTenantLimits(t *testing.T) {
+	s := newQueryScheduler(prometheus.NewRegistry(), 0, 0)
+	limits := TenantLimits{MaxConcurrentQueries: 1, MaxQueuedQueries: 1}
+
+	release, err := s.acquire(context.Background(), "t1", limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queued := make(chan func())
+	go func() {
+		release, err := s.acquire(context.Background(), "t1", limits)
+		if err != nil {
+			t.Error(err)
+		}
+		queued <- release
+	}()
+	waitQueueLength(t, s, "t1", 1)
+
+	if _, err := s.acquire(context.Background(), "t1", limits); err == nil || err.status != http.StatusTooManyRequests {
+		t.Fatalf("expected the queue full error, got %v", err)
+	}
+	// The other tenants are not limited by the concurrency of t1.
+	releaseT2, err := s.acquire(context.Background(), "t2", limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseT2()
+
+	release()
+	(<-queued)()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.running != 0 || len(s.tenants) != 0 || len(s.order) != 0 {
+		t.Fatalf("expected an idle scheduler, got %d running, %d tenants", s.running, len(s.tenants))
+	}
+}
+
+func TestQuerySchedulerFairness(t *testing.T) {
+	s := newQueryScheduler(prometheus.NewRegistry(), 1, 0)
+
+	release, err := s.acquire(context.Background(), "t1", TenantLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	granted := make(chan string)
+	enqueue := func(tenant string, length int) {
+		go func() {
+			release, err := s.acquire(context.Background(), tenant, TenantLimits{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			granted <- tenant
+			release()
+		}()
+		waitQueueLength(t, s, tenant, length)
+	}
+	enqueue("t1", 1)
+	enqueue("t1", 2)
+	enqueue("t1", 3)
+	enqueue("t2", 1)
+
+	release()
+	var order []string
+	for range 4 {
+		order = append(order, <-granted)
+	}
+	if diff := cmp.Diff([]string{"t1", "t2", "t1", "t1"}, order); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestQuerySchedulerQueueTimeout(t *testing.T) {
+	s := newQueryScheduler(prometheus.NewRegistry(), 1, 50*time.Millisecond)
+
+	release, err := s.acquire(context.Background(), "t1", TenantLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	_, err = s.acquire(context.Background(), "t2", TenantLimits{})
+	if err == nil || err.errorType != errorTypeTimeout {
+		t.Fatalf("expected the queue timeout error, got %v", err)
+	}
+	if v := testutil.ToFloat64(s.rejectedCounter.WithLabelValues("t2", queueRejectReasonTimeout)); v != 1 {
+		t.Fatalf("expected 1 rejected query, got %v", v)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, ok := s.tenants["t2"]; ok || len(s.order) != 0 {
+		t.Fatal("expected the timed out tenant to be removed")
+	}
+}
+
+func TestQuerySchedulerMultiTenantQueue(t *testing.T) {
+	// The first query is running until released.
+	var queries atomic.Int32
+	started := make(chan struct{})
+	released := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if queries.Add(1) == 1 {
+			close(started)
+			<-released
+		}
+	}))
+	defer server.Close()
+	release := sync.OnceFunc(func() { close(released) })
+	defer release()
+	target, _ := url.Parse(server.URL)
+
+	limits := NewLimits(prometheus.NewRegistry())
+	limits.SetConfig(LimitsConfig{
+		Tenants: map[string]TenantLimits{
+			"t1": {MaxConcurrentQueries: 1},
+			"t2": {MaxConcurrentQueries: 1},
+		},
+	})
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantLabelName:   "tenant_id",
+		QueryProxy:        NewSingleHostReverseProxy(target, http.DefaultTransport),
+		Limits:            limits,
+		QueryQueueTimeout: 50 * time.Millisecond,
+	})
+	query := func(tenants string) int {
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+tenants+"/api/v1/query?query=up", nil))
+		return rec.Code
+	}
+
+	done := make(chan int)
+	go func() { done <- query("t1") }()
+	<-started
+
+	// The multi-tenant requests wait for a slot of every tenant, regardless of the order of the tenants.
+	for _, tenants := range []string{"t1|t2", "t2|t1"} {
+		if code := query(tenants); code != http.StatusServiceUnavailable {
+			t.Fatalf("expected the query of %s to time out in the queue, got %d", tenants, code)
+		}
+	}
+	if code := query("t2"); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+	release()
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+}
+
+func TestQuerySchedulerMultiTenantGlobalLimit(t *testing.T) {
+	s := newQueryScheduler(prometheus.NewRegistry(), 2, 0)
+	limits := func(tenant string) TenantLimits {
+		if tenant == "t2" {
+			return TenantLimits{MaxConcurrentQueries: 1}
+		}
+		return TenantLimits{}
+	}
+
+	releaseT2, err := s.acquire(context.Background(), "t2", limits("t2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The multi-tenant request takes the last global slot with t1, then waits for t2.
+	multiTenant := make(chan func())
+	go func() {
+		release, err := s.acquireAll(context.Background(), []string{"t2", "t1"}, limits)
+		if err != nil {
+			t.Error(err)
+		}
+		multiTenant <- release
+	}()
+	waitQueueLength(t, s, "t2", 1)
+	single := make(chan func())
+	go func() {
+		release, err := s.acquire(context.Background(), "t2", limits("t2"))
+		if err != nil {
+			t.Error(err)
+		}
+		single <- release
+	}()
+	waitQueueLength(t, s, "t2", 2)
+
+	// The multi-tenant request holding a global slot is started first, ahead of the request queued after it.
+	releaseT2()
+	release := <-multiTenant
+	waitQueueLength(t, s, "t2", 1)
+	release()
+	(<-single)()
+
+	if len(s.tenants) != 0 || s.running != 0 {
+		t.Fatalf("expected no tenants and running queries left, got %d tenants and %d queries", len(s.tenants), s.running)
+	}
+}
+
+func waitQueueLength(t *testing.T, s *queryScheduler, tenant string, length int) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		s.mtx.Lock()
+		tq, ok := s.tenants[tenant]
+		n := 0
+		if ok {
+			n = tq.queued.Len()
+		}
+		s.mtx.Unlock()
+		if n == length {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d queued queries of tenant %s", length, tenant)
+}
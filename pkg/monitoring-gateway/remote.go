@@ -12,6 +12,8 @@ import (
 
 	"github.com/pkg/errors"
 	config_util "github.com/prometheus/common/config"
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/storage/remote"
 	"gopkg.in/yaml.v2"
 )
 
@@ -19,7 +21,8 @@ type remoteWriteClient struct {
 	Client *http.Client
 	url    *config_util.URL
 
-	timeout time.Duration
+	timeout  time.Duration
+	protoMsg promconfig.RemoteWriteProtoMsg
 }
 
 // LoadExternalRemoteWriteConfig loads remotewrites config, and prefers file to content
@@ -44,21 +47,6 @@ func LoadExternalRemoteWriteConfig(file, content string) ([]ExternalRemoteWriteC
 	return rws, nil
 }
 
-func NewExternalRemoteWriteClients(rwsCfg []ExternalRemoteWriteConfig) ([]*remoteWriteClient, error) {
-
-	var clients []*remoteWriteClient
-	for _, rwCfg := range rwsCfg {
-		writeClient, err := newExternalRemoteWriteClient(&rwCfg)
-		if err != nil {
-			return nil, err
-		}
-		if writeClient != nil {
-			clients = append(clients, writeClient)
-		}
-	}
-	return clients, nil
-}
-
 func newExternalRemoteWriteClient(conf *ExternalRemoteWriteConfig) (*remoteWriteClient, error) {
 	cfg := config_util.HTTPClientConfig{
 		TLSConfig:   conf.TLSConfig,
@@ -85,10 +73,18 @@ func newExternalRemoteWriteClient(conf *ExternalRemoteWriteConfig) (*remoteWrite
 	if conf.RemoteTimeout > 0 {
 		timeout = time.Duration(conf.RemoteTimeout)
 	}
+	protoMsg := promconfig.RemoteWriteProtoMsgV1
+	if conf.ProtobufMessage != "" {
+		if err := conf.ProtobufMessage.Validate(); err != nil {
+			return nil, err
+		}
+		protoMsg = conf.ProtobufMessage
+	}
 	return &remoteWriteClient{
-		Client:  httpClient,
-		url:     conf.URL,
-		timeout: timeout,
+		Client:   httpClient,
+		url:      conf.URL,
+		timeout:  timeout,
+		protoMsg: protoMsg,
 	}, nil
 }
 
@@ -104,8 +100,12 @@ func (c *remoteWriteClient) Send(ctx context.Context, body []byte, header http.H
 	}
 
 	httpReq.Header.Add("Content-Encoding", "snappy")
-	httpReq.Header.Set("Content-Type", "application/x-protobuf")
-	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	httpReq.Header.Set("Content-Type", remoteWriteContentTypes[c.protoMsg])
+	if c.protoMsg == promconfig.RemoteWriteProtoMsgV1 {
+		httpReq.Header.Set(remote.RemoteWriteVersionHeader, remote.RemoteWriteVersion1HeaderValue)
+	} else {
+		httpReq.Header.Set(remote.RemoteWriteVersionHeader, remote.RemoteWriteVersion20HeaderValue)
+	}
 	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 
@@ -133,6 +133,11 @@ func (c remoteWriteClient) Endpoint() string {
 	return c.url.String()
 }
 
+// ProtoMsg returns the remote write protobuf message sent to the target.
+func (c remoteWriteClient) ProtoMsg() promconfig.RemoteWriteProtoMsg {
+	return c.protoMsg
+}
+
 type result struct {
 	code int
 	err  error
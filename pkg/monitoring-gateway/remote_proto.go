@@ -0,0 +1,370 @@
+package monitoringgateway
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+const appProtoContentType = "application/x-protobuf"
+
+// remoteWriteContentTypes are the content types sent for the supported remote write protobuf messages.
+var remoteWriteContentTypes = map[promconfig.RemoteWriteProtoMsg]string{
+	// Also application/x-protobuf;proto=prometheus.WriteRequest but simplified for compatibility with 1.x spec.
+	promconfig.RemoteWriteProtoMsgV1: appProtoContentType,
+	promconfig.RemoteWriteProtoMsgV2: appProtoContentType + ";proto=" + string(promconfig.RemoteWriteProtoMsgV2),
+}
+
+// remoteWriteProtoMsg negotiates the protobuf message of a remote write request from its headers.
+func remoteWriteProtoMsg(header http.Header) (promconfig.RemoteWriteProtoMsg, error) {
+	if enc := header.Get("Content-Encoding"); enc != "" && !strings.EqualFold(enc, "snappy") {
+		return "", fmt.Errorf("%v encoding (compression) is not accepted by this server; only snappy is acceptable", enc)
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		return promconfig.RemoteWriteProtoMsgV1, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing content-type %s", contentType)
+	}
+	if mediaType != appProtoContentType {
+		return "", fmt.Errorf("expected %v as the media type, got %v content-type", appProtoContentType, contentType)
+	}
+
+	proto, ok := params["proto"]
+	if !ok {
+		return promconfig.RemoteWriteProtoMsgV1, nil
+	}
+	msg := promconfig.RemoteWriteProtoMsg(proto)
+	if err := msg.Validate(); err != nil {
+		return "", err
+	}
+	return msg, nil
+}
+
+// decodeWriteRequestV2 decodes a snappy-compressed remote write 2.0 request body.
+func decodeWriteRequestV2(body []byte) (*writev2.Request, error) {
+	reqBuf, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "decompressing remote write request")
+	}
+
+	var wreq writev2.Request
+	if err := wreq.Unmarshal(reqBuf); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling remote write request")
+	}
+
+	// Make sure that the symbol references are valid, so that the later desymbolization does not panic.
+	validRef := func(ref uint32) bool { return int(ref) < len(wreq.Symbols) }
+	for _, ts := range wreq.Timeseries {
+		if len(ts.LabelsRefs)%2 != 0 {
+			return nil, errors.New("invalid remote write request: odd number of label references")
+		}
+		for _, ref := range ts.LabelsRefs {
+			if !validRef(ref) {
+				return nil, fmt.Errorf("invalid remote write request: label reference %d out of symbols", ref)
+			}
+		}
+		for _, e := range ts.Exemplars {
+			if len(e.LabelsRefs)%2 != 0 {
+				return nil, errors.New("invalid remote write request: odd number of exemplar label references")
+			}
+			for _, ref := range e.LabelsRefs {
+				if !validRef(ref) {
+					return nil, fmt.Errorf("invalid remote write request: exemplar label reference %d out of symbols", ref)
+				}
+			}
+		}
+		if !validRef(ts.Metadata.HelpRef) || !validRef(ts.Metadata.UnitRef) {
+			return nil, errors.New("invalid remote write request: metadata reference out of symbols")
+		}
+	}
+	return &wreq, nil
+}
+
+// encodeWriteRequestV2 encodes a remote write 2.0 request to a snappy-compressed body.
+func encodeWriteRequestV2(wreq *writev2.Request) ([]byte, error) {
+	reqBuf, err := wreq.Marshal()
+	if err != nil {
+		return nil, errors.Wrap(err, "marshalling remote write request")
+	}
+	return snappy.Encode(nil, reqBuf), nil
+}
+
+// writeRequestBody is a remote write request body shared by the steps of the write path. The body is decoded once
+// by the first step needing the decoded request, and re-encoded once if the steps modify the decoded request.
+type writeRequestBody struct {
+	body []byte
+	msg  promconfig.RemoteWriteProtoMsg
+
+	decoded bool
+	err     error
+	v1      *prompb.WriteRequest
+	v2      *writev2.Request
+	// modified is true if the decoded request is modified since the body is encoded.
+	modified bool
+}
+
+func newWriteRequestBody(body []byte, msg promconfig.RemoteWriteProtoMsg) *writeRequestBody {
+	return &writeRequestBody{body: body, msg: msg}
+}
+
+// decode decodes the body by its protobuf message, the later calls return the result of the first one.
+func (b *writeRequestBody) decode() error {
+	if !b.decoded {
+		b.decoded = true
+		switch b.msg {
+		case promconfig.RemoteWriteProtoMsgV2:
+			b.v2, b.err = decodeWriteRequestV2(b.body)
+		default:
+			b.v1, b.err = decodeWriteRequest(b.body)
+		}
+	}
+	return b.err
+}
+
+// bytes returns the body, which is re-encoded if the decoded request is modified.
+func (b *writeRequestBody) bytes() ([]byte, error) {
+	if !b.modified {
+		return b.body, nil
+	}
+
+	var (
+		body []byte
+		err  error
+	)
+	switch b.msg {
+	case promconfig.RemoteWriteProtoMsgV2:
+		body, err = encodeWriteRequestV2(b.v2)
+	default:
+		body, err = encodeWriteRequest(b.v1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.body, b.modified = body, false
+	return body, nil
+}
+
+// stats returns the number of series, samples, histograms and exemplars of the decoded request.
+func (b *writeRequestBody) stats() (usageStats, error) {
+	var stats usageStats
+	if err := b.decode(); err != nil {
+		return stats, err
+	}
+
+	switch b.msg {
+	case promconfig.RemoteWriteProtoMsgV2:
+		stats.series = len(b.v2.Timeseries)
+		for _, ts := range b.v2.Timeseries {
+			stats.samples += len(ts.Samples)
+			stats.histograms += len(ts.Histograms)
+			stats.exemplars += len(ts.Exemplars)
+		}
+	default:
+		stats.series = len(b.v1.Timeseries)
+		for _, ts := range b.v1.Timeseries {
+			stats.samples += len(ts.Samples)
+			stats.histograms += len(ts.Histograms)
+			stats.exemplars += len(ts.Exemplars)
+		}
+	}
+	return stats, nil
+}
+
+// setWrittenStats sets the remote write 2.0 written headers on a successful response,
+// if the downstream did not respond them.
+func setWrittenStats(resp *http.Response, b *writeRequestBody) error {
+	if b.msg != promconfig.RemoteWriteProtoMsgV2 || resp.StatusCode/100 != 2 {
+		return nil
+	}
+	if stats, err := remote.ParseWriteResponseStats(resp); err != nil || stats.Confirmed {
+		return nil
+	}
+
+	stats, err := b.stats()
+	if err != nil {
+		return err
+	}
+	resp.Header.Set("X-Prometheus-Remote-Write-Samples-Written", fmt.Sprint(stats.samples))
+	resp.Header.Set("X-Prometheus-Remote-Write-Histograms-Written", fmt.Sprint(stats.histograms))
+	resp.Header.Set("X-Prometheus-Remote-Write-Exemplars-Written", fmt.Sprint(stats.exemplars))
+	return nil
+}
+
+// transcodeWriteRequest converts a remote write request body from one protobuf message to another.
+func transcodeWriteRequest(body []byte, from, to promconfig.RemoteWriteProtoMsg) ([]byte, error) {
+	if from == to {
+		return body, nil
+	}
+
+	switch to {
+	case promconfig.RemoteWriteProtoMsgV2:
+		wreq, err := decodeWriteRequest(body)
+		if err != nil {
+			return nil, err
+		}
+		return encodeWriteRequestV2(writeRequestV1ToV2(wreq))
+	default:
+		wreq, err := decodeWriteRequestV2(body)
+		if err != nil {
+			return nil, err
+		}
+		return encodeWriteRequest(writeRequestV2ToV1(wreq))
+	}
+}
+
+// relabelWriteRequest applies the relabel configs to the series of a remote write request body, and encodes it
+// to the given protobuf message. It returns nil if all series are dropped.
+// Remote write 2.0 requests are relabeled in the 1.0 format, so the created timestamps are dropped.
+func relabelWriteRequest(body []byte, from, to promconfig.RemoteWriteProtoMsg, cfgs []*relabel.Config) ([]byte, error) {
+	var wreq *prompb.WriteRequest
+	switch from {
+	case promconfig.RemoteWriteProtoMsgV2:
+		req, err := decodeWriteRequestV2(body)
+		if err != nil {
+			return nil, err
+		}
+		wreq = writeRequestV2ToV1(req)
+	default:
+		req, err := decodeWriteRequest(body)
+		if err != nil {
+			return nil, err
+		}
+		wreq = req
+	}
+
+	var (
+		b      = labels.NewScratchBuilder(0)
+		series = wreq.Timeseries[:0]
+	)
+	for _, ts := range wreq.Timeseries {
+		lbls, keep := relabel.Process(ts.ToLabels(&b, nil), cfgs...)
+		if !keep || lbls.IsEmpty() {
+			continue
+		}
+		ts.Labels = prompb.FromLabels(lbls, nil)
+		series = append(series, ts)
+	}
+	if len(series) == 0 {
+		return nil, nil
+	}
+	wreq.Timeseries = series
+
+	if to == promconfig.RemoteWriteProtoMsgV2 {
+		return encodeWriteRequestV2(writeRequestV1ToV2(wreq))
+	}
+	return encodeWriteRequest(wreq)
+}
+
+// writeRequestV1ToV2 converts a remote write 1.0 request to a remote write 2.0 request.
+// The metadata of the metric families are attached to the series of the same metric name.
+func writeRequestV1ToV2(wreq *prompb.WriteRequest) *writev2.Request {
+	metadata := make(map[string]prompb.MetricMetadata, len(wreq.Metadata))
+	for _, md := range wreq.Metadata {
+		metadata[md.MetricFamilyName] = md
+	}
+
+	var (
+		st     = writev2.NewSymbolTable()
+		b      = labels.NewScratchBuilder(0)
+		series = make([]writev2.TimeSeries, 0, len(wreq.Timeseries))
+	)
+	for _, ts := range wreq.Timeseries {
+		lbls := ts.ToLabels(&b, nil)
+		out := writev2.TimeSeries{
+			LabelsRefs: st.SymbolizeLabels(lbls, nil),
+			Samples:    make([]writev2.Sample, 0, len(ts.Samples)),
+		}
+		for _, s := range ts.Samples {
+			out.Samples = append(out.Samples, writev2.Sample{Value: s.Value, Timestamp: s.Timestamp})
+		}
+		for _, h := range ts.Histograms {
+			if h.IsFloatHistogram() {
+				out.Histograms = append(out.Histograms, writev2.FromFloatHistogram(h.Timestamp, h.ToFloatHistogram()))
+			} else {
+				out.Histograms = append(out.Histograms, writev2.FromIntHistogram(h.Timestamp, h.ToIntHistogram()))
+			}
+		}
+		for _, e := range ts.Exemplars {
+			out.Exemplars = append(out.Exemplars, writev2.Exemplar{
+				LabelsRefs: st.SymbolizeLabels(e.ToExemplar(&b, nil).Labels, nil),
+				Value:      e.Value,
+				Timestamp:  e.Timestamp,
+			})
+		}
+		if md, ok := metadata[lbls.Get(labels.MetricName)]; ok {
+			out.Metadata = writev2.Metadata{
+				Type:    writev2.FromMetadataType(model.MetricType(strings.ToLower(md.Type.String()))),
+				HelpRef: st.Symbolize(md.Help),
+				UnitRef: st.Symbolize(md.Unit),
+			}
+		}
+		series = append(series, out)
+	}
+
+	return &writev2.Request{Symbols: st.Symbols(), Timeseries: series}
+}
+
+// writeRequestV2ToV1 converts a remote write 2.0 request to a remote write 1.0 request.
+// The metadata of the series are deduplicated by metric name, while created timestamps are dropped.
+func writeRequestV2ToV1(wreq *writev2.Request) *prompb.WriteRequest {
+	var (
+		b        = labels.NewScratchBuilder(0)
+		out      = &prompb.WriteRequest{Timeseries: make([]prompb.TimeSeries, 0, len(wreq.Timeseries))}
+		metadata = make(map[string]struct{})
+	)
+	for _, ts := range wreq.Timeseries {
+		lbls := ts.ToLabels(&b, wreq.Symbols)
+		series := prompb.TimeSeries{
+			Labels:  prompb.FromLabels(lbls, nil),
+			Samples: make([]prompb.Sample, 0, len(ts.Samples)),
+		}
+		for _, s := range ts.Samples {
+			series.Samples = append(series.Samples, prompb.Sample{Value: s.Value, Timestamp: s.Timestamp})
+		}
+		for _, h := range ts.Histograms {
+			if h.IsFloatHistogram() {
+				series.Histograms = append(series.Histograms, prompb.FromFloatHistogram(h.Timestamp, h.ToFloatHistogram()))
+			} else {
+				series.Histograms = append(series.Histograms, prompb.FromIntHistogram(h.Timestamp, h.ToIntHistogram()))
+			}
+		}
+		for _, e := range ts.Exemplars {
+			ex := e.ToExemplar(&b, wreq.Symbols)
+			series.Exemplars = append(series.Exemplars, prompb.Exemplar{
+				Labels:    prompb.FromLabels(ex.Labels, nil),
+				Value:     ex.Value,
+				Timestamp: ex.Ts,
+			})
+		}
+		out.Timeseries = append(out.Timeseries, series)
+
+		name := lbls.Get(labels.MetricName)
+		if _, ok := metadata[name]; ok || name == "" || ts.Metadata.Type == writev2.Metadata_METRIC_TYPE_UNSPECIFIED {
+			continue
+		}
+		metadata[name] = struct{}{}
+		md := ts.ToMetadata(wreq.Symbols)
+		out.Metadata = append(out.Metadata, prompb.MetricMetadata{
+			Type:             prompb.FromMetadataType(md.Type),
+			MetricFamilyName: name,
+			Help:             md.Help,
+			Unit:             md.Unit,
+		})
+	}
+	return out
+}
@@ -0,0 +1,145 @@
+package monitoringgateway
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/common/model"
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRemoteWriteProtoMsg(t *testing.T) {
+	for _, tc := range []struct {
+		contentType string
+		encoding    string
+		expected    promconfig.RemoteWriteProtoMsg
+		err         bool
+	}{
+		{contentType: "", expected: promconfig.RemoteWriteProtoMsgV1},
+		{contentType: "application/x-protobuf", encoding: "snappy", expected: promconfig.RemoteWriteProtoMsgV1},
+		{contentType: "application/x-protobuf;proto=prometheus.WriteRequest", expected: promconfig.RemoteWriteProtoMsgV1},
+		{contentType: "application/x-protobuf;proto=io.prometheus.write.v2.Request", expected: promconfig.RemoteWriteProtoMsgV2},
+		{contentType: "application/x-protobuf;proto=unknown", err: true},
+		{contentType: "application/json", err: true},
+		{contentType: "application/x-protobuf", encoding: "zstd", err: true},
+	} {
+		header := http.Header{}
+		header.Set("Content-Type", tc.contentType)
+		header.Set("Content-Encoding", tc.encoding)
+
+		msg, err := remoteWriteProtoMsg(header)
+		if tc.err {
+			if err == nil {
+				t.Fatalf("%q: expected error", tc.contentType)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tc.contentType, err)
+		}
+		if msg != tc.expected {
+			t.Fatalf("%q: expected %s, got %s", tc.contentType, tc.expected, msg)
+		}
+	}
+}
+
+func TestTranscodeWriteRequest(t *testing.T) {
+	wreq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:    []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "a"}},
+				Samples:   []prompb.Sample{{Value: 1, Timestamp: 1000}, {Value: 0, Timestamp: 2000}},
+				Exemplars: []prompb.Exemplar{{Labels: []prompb.Label{{Name: "trace_id", Value: "abc"}}, Value: 1, Timestamp: 1000}},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "b"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			},
+		},
+		Metadata: []prompb.MetricMetadata{
+			{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up", Help: "Target is up."},
+		},
+	}
+	body, err := encodeWriteRequest(wreq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v2, err := transcodeWriteRequest(body, promconfig.RemoteWriteProtoMsgV1, promconfig.RemoteWriteProtoMsgV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := newWriteRequestBody(v2, promconfig.RemoteWriteProtoMsgV2).stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.samples != 3 || stats.exemplars != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	v1, err := transcodeWriteRequest(v2, promconfig.RemoteWriteProtoMsgV2, promconfig.RemoteWriteProtoMsgV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeWriteRequest(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(wreq, got); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestRelabelWriteRequest(t *testing.T) {
+	body, err := encodeWriteRequest(&prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "a"}, {Name: "secret", Value: "s"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "internal_metric"}, {Name: "job", Value: "a"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfgs []*relabel.Config
+	if err := yaml.UnmarshalStrict([]byte(`
+- source_labels: [__name__]
+  regex: internal_.*
+  action: drop
+- regex: secret
+  action: labeldrop
+`), &cfgs); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := relabelWriteRequest(body, promconfig.RemoteWriteProtoMsgV1, promconfig.RemoteWriteProtoMsgV1, cfgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeWriteRequest(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "a"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}, got.Timeseries); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// Nothing is left to be sent if all series are dropped.
+	dropAll := []*relabel.Config{{Action: relabel.Drop, Regex: relabel.MustNewRegexp(".*"), SourceLabels: []model.LabelName{"__name__"}, Separator: ";"}}
+	if out, err = relabelWriteRequest(body, promconfig.RemoteWriteProtoMsgV1, promconfig.RemoteWriteProtoMsgV2, dropAll); err != nil || out != nil {
+		t.Fatalf("expected all series to be dropped, got %v", err)
+	}
+}
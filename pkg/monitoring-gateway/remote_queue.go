@@ -0,0 +1,562 @@
+package monitoringgateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	dropReasonNonRecoverable = "non_recoverable"
+	dropReasonMaxRetries     = "max_retries"
+	dropReasonTranscode      = "transcode_failed"
+	dropReasonEnqueue        = "enqueue_failed"
+	dropReasonTargetRemoved  = "target_removed"
+)
+
+// DefaultQueueConfig is the default queue configuration of an external remote write target.
+var DefaultQueueConfig = QueueConfig{
+	Shards:           4,
+	MaxSizeBytes:     512 << 20,
+	SegmentSizeBytes: 16 << 20,
+	MinBackoff:       model.Duration(100 * time.Millisecond),
+	MaxBackoff:       model.Duration(30 * time.Second),
+	MaxRetries:       10,
+}
+
+// QueueConfig configures the durable queue of an external remote write target.
+type QueueConfig struct {
+	// Shards is the number of concurrent senders. The requests of a tenant are sent by the same shard in order.
+	Shards int `yaml:"shards,omitempty"`
+	// MaxSizeBytes bounds the size of the queue on disk. The oldest requests are dropped when it's exceeded.
+	MaxSizeBytes int64 `yaml:"max_size_bytes,omitempty"`
+	// SegmentSizeBytes is the size of the files the queue is split into.
+	SegmentSizeBytes int64 `yaml:"segment_size_bytes,omitempty"`
+	// MinBackoff is the initial delay of retrying a failed request, which is doubled on every retry up to MaxBackoff.
+	MinBackoff model.Duration `yaml:"min_backoff,omitempty"`
+	MaxBackoff model.Duration `yaml:"max_backoff,omitempty"`
+	// MaxRetries is the number of retries before a request is dropped.
+	MaxRetries int `yaml:"max_retries,omitempty"`
+}
+
+// withDefaults returns the queue config with the unset fields taken from DefaultQueueConfig.
+func (c QueueConfig) withDefaults() QueueConfig {
+	if c.Shards <= 0 {
+		c.Shards = DefaultQueueConfig.Shards
+	}
+	if c.MaxSizeBytes <= 0 {
+		c.MaxSizeBytes = DefaultQueueConfig.MaxSizeBytes
+	}
+	if c.SegmentSizeBytes <= 0 {
+		c.SegmentSizeBytes = DefaultQueueConfig.SegmentSizeBytes
+	}
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = DefaultQueueConfig.MinBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = DefaultQueueConfig.MaxBackoff
+	}
+	if c.MaxRetries <= 0 {
+		c.MaxRetries = DefaultQueueConfig.MaxRetries
+	}
+	return c
+}
+
+type remoteWriteQueueMetrics struct {
+	requests    *prometheus.CounterVec
+	retries     *prometheus.CounterVec
+	dropped     *prometheus.CounterVec
+	queueLength *prometheus.GaugeVec
+	queueBytes  *prometheus.GaugeVec
+	queueLag    *prometheus.GaugeVec
+}
+
+func newRemoteWriteQueueMetrics(reg prometheus.Registerer) *remoteWriteQueueMetrics {
+	return &remoteWriteQueueMetrics{
+		requests: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_external_remote_write_requests_total",
+				Help: "Total number of remote write results, labeled by endpoint and code.",
+			},
+			[]string{"endpoint", "code"},
+		),
+		retries: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_external_remote_write_retries_total",
+				Help: "Total number of retried remote write requests, labeled by endpoint.",
+			},
+			[]string{"endpoint"},
+		),
+		dropped: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_external_remote_write_dropped_requests_total",
+				Help: "Total number of remote write requests dropped without being delivered, labeled by endpoint and reason.",
+			},
+			[]string{"endpoint", "reason"},
+		),
+		queueLength: promauto.With(reg).NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "whizard_external_remote_write_queue_length",
+				Help: "Number of remote write requests pending in the queue, labeled by endpoint.",
+			},
+			[]string{"endpoint"},
+		),
+		queueBytes: promauto.With(reg).NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "whizard_external_remote_write_queue_size_bytes",
+				Help: "Size of the queue on disk, labeled by endpoint.",
+			},
+			[]string{"endpoint"},
+		),
+		queueLag: promauto.With(reg).NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "whizard_external_remote_write_queue_lag_seconds",
+				Help: "Time between enqueuing and delivering the most recently delivered remote write request, labeled by endpoint.",
+			},
+			[]string{"endpoint"},
+		),
+	}
+}
+
+// remoteWriteQueue delivers the requests of a durable queue to an external remote write target.
+type remoteWriteQueue struct {
+	logger         log.Logger
+	client         *remoteWriteClient
+	cfg            QueueConfig
+	relabelConfigs []*relabel.Config
+	allowedTenants map[string]struct{}
+	deniedTenants  map[string]struct{}
+	tenantHeader   string
+	queue          *diskQueue
+	dir            string
+	metrics        *remoteWriteQueueMetrics
+
+	// fingerprint identifies the configuration of the target, so that the queue is only recreated on changes.
+	fingerprint string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newRemoteWriteQueue creates the sender of a target. The durable queue is opened in dir,
+// unless the queue of a previous sender of the same target is given.
+func newRemoteWriteQueue(logger log.Logger, dir, tenantHeader string, conf *ExternalRemoteWriteConfig, metrics *remoteWriteQueueMetrics, queue *diskQueue) (*remoteWriteQueue, error) {
+	fingerprint, err := yaml.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newExternalRemoteWriteClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	cfg := conf.QueueConfig.withDefaults()
+	ep := client.Endpoint()
+
+	// The queue directory is derived from the endpoint, so that it's kept across restarts.
+	sum := sha256.Sum256([]byte(ep))
+	dir = filepath.Join(dir, hex.EncodeToString(sum[:8]))
+	if queue == nil {
+		queue, err = openDiskQueue(dir, cfg.SegmentSizeBytes, cfg.MaxSizeBytes,
+			metrics.queueLength.WithLabelValues(ep), metrics.queueBytes.WithLabelValues(ep),
+			func(reason string, n int) {
+				metrics.dropped.WithLabelValues(ep, reason).Add(float64(n))
+			})
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening queue of %s", ep)
+		}
+	} else {
+		queue.SetLimits(cfg.SegmentSizeBytes, cfg.MaxSizeBytes)
+	}
+
+	return &remoteWriteQueue{
+		logger:         log.With(logger, "endpoint", ep),
+		client:         client,
+		cfg:            cfg,
+		relabelConfigs: conf.WriteRelabelConfigs,
+		allowedTenants: stringSet(conf.AllowedTenants),
+		deniedTenants:  stringSet(conf.DeniedTenants),
+		tenantHeader:   tenantHeader,
+		queue:          queue,
+		dir:            dir,
+		metrics:        metrics,
+		fingerprint:    string(fingerprint),
+	}, nil
+}
+
+func stringSet(ss []string) map[string]struct{} {
+	if len(ss) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(ss))
+	for _, s := range ss {
+		set[s] = struct{}{}
+	}
+	return set
+}
+
+// allowsTenant reports whether the requests of the tenant are sent to the target.
+func (q *remoteWriteQueue) allowsTenant(tenant string) bool {
+	if _, ok := q.deniedTenants[tenant]; ok {
+		return false
+	}
+	if q.allowedTenants == nil {
+		return true
+	}
+	_, ok := q.allowedTenants[tenant]
+	return ok
+}
+
+// payload returns the request body to be sent to the target, or nil if all series are dropped by relabeling.
+func (q *remoteWriteQueue) payload(e *queueEntry) ([]byte, error) {
+	if len(q.relabelConfigs) == 0 {
+		return transcodeWriteRequest(e.body, e.msg, q.client.ProtoMsg())
+	}
+	return relabelWriteRequest(e.body, e.msg, q.client.ProtoMsg(), q.relabelConfigs)
+}
+
+// start delivers the queued requests in background until stop is called.
+func (q *remoteWriteQueue) start(ctx context.Context) {
+	ctx, q.cancel = context.WithCancel(ctx)
+	q.done = make(chan struct{})
+	q.queue.Resume()
+
+	go func() {
+		defer close(q.done)
+		q.run(ctx)
+	}()
+}
+
+// stop stops reading the queue, and waits for the requests being sent up to the drain timeout.
+// The requests which are not delivered are kept in the queue.
+func (q *remoteWriteQueue) stop(drainTimeout time.Duration) {
+	if q.done == nil {
+		return
+	}
+	q.queue.Pause()
+
+	select {
+	case <-q.done:
+	case <-time.After(drainTimeout):
+		level.Warn(q.logger).Log("msg", "timed out draining requests being sent", "timeout", drainTimeout)
+	}
+	q.cancel()
+	<-q.done
+}
+
+// run dispatches the queued requests to the shards until the queue is paused or the context is canceled.
+func (q *remoteWriteQueue) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	shards := make([]chan *queueEntry, q.cfg.Shards)
+	for i := range shards {
+		shards[i] = make(chan *queueEntry, 1)
+		wg.Add(1)
+		go func(entries <-chan *queueEntry) {
+			defer wg.Done()
+			for e := range entries {
+				if q.send(ctx, e) {
+					q.queue.Ack(e)
+				} else {
+					q.queue.Release(e)
+				}
+			}
+		}(shards[i])
+	}
+	defer func() {
+		for _, shard := range shards {
+			close(shard)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		e, err := q.queue.Next()
+		if err == errQueueClosed || err == errQueuePaused {
+			return
+		}
+		if err != nil {
+			level.Error(q.logger).Log("msg", "failed to read queue", "err", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(q.cfg.MinBackoff)):
+			}
+			continue
+		}
+
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(e.tenant))
+		select {
+		case shards[h.Sum32()%uint32(len(shards))] <- e:
+		case <-ctx.Done():
+			q.queue.Release(e)
+			return
+		}
+	}
+}
+
+// send delivers a request with retries, and returns whether it's done with the request,
+// either delivered or dropped. The request is kept in the queue if the context is canceled.
+func (q *remoteWriteQueue) send(ctx context.Context, e *queueEntry) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	ep := q.client.Endpoint()
+
+	body, err := q.payload(e)
+	if err != nil {
+		level.Error(q.logger).Log("msg", "failed to transcode request", "protobuf_message", q.client.ProtoMsg(), "err", err)
+		q.metrics.dropped.WithLabelValues(ep, dropReasonTranscode).Inc()
+		return true
+	}
+	if body == nil {
+		return true
+	}
+
+	header := make(http.Header)
+	if e.tenant != "" {
+		header.Set(q.tenantHeader, e.tenant)
+	}
+
+	backoff := time.Duration(q.cfg.MinBackoff)
+	for try := 0; ; try++ {
+		result := q.client.Send(ctx, body, header)
+		if ctx.Err() != nil {
+			return false
+		}
+		q.metrics.requests.WithLabelValues(ep, strconv.Itoa(result.code)).Inc()
+		if result.err == nil {
+			q.metrics.queueLag.WithLabelValues(ep).Set(time.Since(e.enqueued).Seconds())
+			return true
+		}
+
+		// Only server errors and throttling are worth retrying.
+		if result.code/100 != 5 && result.code != http.StatusTooManyRequests {
+			level.Error(q.logger).Log("msg", "dropping request failed with non-recoverable error", "err", result.err)
+			q.metrics.dropped.WithLabelValues(ep, dropReasonNonRecoverable).Inc()
+			return true
+		}
+		if try >= q.cfg.MaxRetries {
+			level.Error(q.logger).Log("msg", "dropping request after max retries", "retries", try, "err", result.err)
+			q.metrics.dropped.WithLabelValues(ep, dropReasonMaxRetries).Inc()
+			return true
+		}
+
+		level.Warn(q.logger).Log("msg", "failed to forward request, retrying", "backoff", backoff, "err", result.err)
+		q.metrics.retries.WithLabelValues(ep).Inc()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, time.Duration(q.cfg.MaxBackoff))
+	}
+}
+
+// ExternalRemoteWriter forwards the received remote write requests to the external remote write targets.
+// Each target is backed by a durable queue, so that the requests are delivered in background with retries.
+// The targets can be reconfigured at runtime by ApplyConfig.
+type ExternalRemoteWriter struct {
+	logger       log.Logger
+	dir          string
+	tenantHeader string
+	metrics      *remoteWriteQueueMetrics
+
+	// reloadMtx serializes the configuration reloads.
+	reloadMtx sync.Mutex
+
+	mtx sync.RWMutex
+	// ctx is set once running, so that the queues of new targets are started.
+	ctx    context.Context
+	queues map[string]*remoteWriteQueue // keyed by the endpoint
+}
+
+// NewExternalRemoteWriter creates a writer, which keeps the queues of the external remote write targets in dir.
+// The targets are configured by ApplyConfig.
+func NewExternalRemoteWriter(logger log.Logger, reg prometheus.Registerer, dir, tenantHeader string) *ExternalRemoteWriter {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	return &ExternalRemoteWriter{
+		logger:       logger,
+		dir:          dir,
+		tenantHeader: tenantHeader,
+		metrics:      newRemoteWriteQueueMetrics(reg),
+		queues:       map[string]*remoteWriteQueue{},
+	}
+}
+
+// ApplyConfig replaces the external remote write targets. The queues of the unchanged targets are kept.
+// The senders of the changed targets are replaced, while their pending requests are kept.
+// The removed targets are drained from the requests being sent, and their queues are deleted.
+// The configuration is either fully applied, or not at all on error.
+func (w *ExternalRemoteWriter) ApplyConfig(rwsCfg []ExternalRemoteWriteConfig) error {
+	w.reloadMtx.Lock()
+	defer w.reloadMtx.Unlock()
+
+	configs := make(map[string]*ExternalRemoteWriteConfig, len(rwsCfg))
+	for i := range rwsCfg {
+		if rwsCfg[i].URL == nil {
+			return errors.Errorf("no url is given for external remote write %q", rwsCfg[i].Name)
+		}
+		ep := rwsCfg[i].URL.String()
+		if _, ok := configs[ep]; ok {
+			return errors.Errorf("duplicate external remote write url %s", ep)
+		}
+		configs[ep] = &rwsCfg[i]
+	}
+	if len(configs) > 0 && w.dir == "" {
+		return errors.New("no queue directory is given for external remote write targets")
+	}
+
+	w.mtx.RLock()
+	current := w.queues
+	w.mtx.RUnlock()
+
+	var (
+		queues  = make(map[string]*remoteWriteQueue, len(configs))
+		changed = map[string]*remoteWriteQueue{}
+		added   []*remoteWriteQueue
+	)
+	for ep, conf := range configs {
+		old, ok := current[ep]
+		var queue *diskQueue
+		if ok {
+			queue = old.queue
+		}
+		q, err := newRemoteWriteQueue(w.logger, w.dir, w.tenantHeader, conf, w.metrics, queue)
+		if err != nil {
+			for _, q := range added {
+				_ = q.queue.Close()
+			}
+			return err
+		}
+		switch {
+		case !ok:
+			added = append(added, q)
+			queues[ep] = q
+		case old.fingerprint != q.fingerprint:
+			changed[ep] = q
+			queues[ep] = q
+		default:
+			queues[ep] = old
+		}
+	}
+
+	var (
+		wg  sync.WaitGroup
+		ctx context.Context
+	)
+	w.mtx.Lock()
+	ctx = w.ctx
+	for ep, old := range current {
+		if q, ok := changed[ep]; ok {
+			// The old sender is drained before the new one reads the same queue, while requests are still appended.
+			wg.Add(1)
+			go func(old, q *remoteWriteQueue) {
+				defer wg.Done()
+				old.stop(old.client.timeout)
+				if ctx != nil {
+					q.start(ctx)
+				}
+			}(old, q)
+			continue
+		}
+		if _, ok := queues[ep]; ok {
+			continue
+		}
+		wg.Add(1)
+		go func(ep string, old *remoteWriteQueue) {
+			defer wg.Done()
+			w.removeQueue(ep, old)
+		}(ep, old)
+	}
+	w.queues = queues
+	if ctx != nil {
+		for _, q := range added {
+			q.start(ctx)
+		}
+	}
+	w.mtx.Unlock()
+
+	wg.Wait()
+	return nil
+}
+
+// removeQueue stops the sender of a removed target, and deletes its queue.
+func (w *ExternalRemoteWriter) removeQueue(ep string, q *remoteWriteQueue) {
+	q.stop(q.client.timeout)
+	if n := q.queue.Length(); n > 0 {
+		w.metrics.dropped.WithLabelValues(ep, dropReasonTargetRemoved).Add(float64(n))
+	}
+	if err := q.queue.Close(); err != nil {
+		level.Error(q.logger).Log("msg", "failed to close queue", "err", err)
+	}
+	if err := os.RemoveAll(q.dir); err != nil {
+		level.Error(q.logger).Log("msg", "failed to remove queue", "err", err)
+	}
+	w.metrics.queueLength.DeleteLabelValues(ep)
+	w.metrics.queueBytes.DeleteLabelValues(ep)
+	w.metrics.queueLag.DeleteLabelValues(ep)
+	level.Info(w.logger).Log("msg", "removed external remote write target", "endpoint", ep)
+}
+
+// Enqueue adds a remote write request to the queues of the targets the tenant is allowed for.
+// It does not wait for the delivery.
+func (w *ExternalRemoteWriter) Enqueue(tenant string, msg promconfig.RemoteWriteProtoMsg, body []byte) {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+
+	for _, q := range w.queues {
+		if !q.allowsTenant(tenant) {
+			continue
+		}
+		if err := q.queue.Append(tenant, msg, body); err != nil {
+			level.Error(q.logger).Log("msg", "failed to enqueue request", "err", err)
+			q.metrics.dropped.WithLabelValues(q.client.Endpoint(), dropReasonEnqueue).Inc()
+		}
+	}
+}
+
+// Run delivers the queued requests until the context is canceled. The pending requests are kept on disk.
+func (w *ExternalRemoteWriter) Run(ctx context.Context) error {
+	w.mtx.Lock()
+	w.ctx = ctx
+	for _, q := range w.queues {
+		q.start(ctx)
+	}
+	w.mtx.Unlock()
+
+	<-ctx.Done()
+
+	// Wait for a reload in progress, so that no queue is started afterwards.
+	w.reloadMtx.Lock()
+	defer w.reloadMtx.Unlock()
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	for _, q := range w.queues {
+		q.stop(0)
+		if err := q.queue.Close(); err != nil {
+			level.Error(q.logger).Log("msg", "failed to close queue", "err", err)
+		}
+	}
+	return nil
+}
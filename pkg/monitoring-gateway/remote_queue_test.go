@@ -0,0 +1,220 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestExternalRemoteWriterRetries(t *testing.T) {
+	var (
+		calls    int
+		received = make(chan string, 1)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			received <- r.Header.Get("WHIZARD-TENANT")
+		}
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	w := NewExternalRemoteWriter(nil, prometheus.NewRegistry(), t.TempDir(), "WHIZARD-TENANT")
+	if err := w.ApplyConfig([]ExternalRemoteWriteConfig{{
+		URL:         &config_util.URL{URL: u},
+		QueueConfig: QueueConfig{MinBackoff: model.Duration(time.Millisecond)},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = w.Run(ctx)
+	}()
+
+	body, err := encodeWriteRequest(&prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: time.Now().UnixMilli()}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Enqueue("a", promconfig.RemoteWriteProtoMsgV1, body)
+
+	select {
+	case tenant := <-received:
+		if tenant != "a" {
+			t.Fatalf("expected tenant a, got %s", tenant)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("request was not delivered")
+	}
+
+	// The delivered request is acknowledged.
+	metrics := w.metrics
+	for deadline := time.Now().Add(10 * time.Second); testutil.ToFloat64(metrics.queueLength.WithLabelValues(srv.URL)) != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("request was not acknowledged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if v := testutil.ToFloat64(metrics.retries.WithLabelValues(srv.URL)); v != 2 {
+		t.Fatalf("expected 2 retries, got %v", v)
+	}
+}
+
+func TestExternalRemoteWritesOfWrittenRequests(t *testing.T) {
+	var status atomic.Int32
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer downstream.Close()
+	target, _ := url.Parse(downstream.URL)
+
+	// The writer is not run, so that the enqueued requests are kept in the queue.
+	external, _ := url.Parse("http://external.example.com/api/v1/write")
+	w := NewExternalRemoteWriter(nil, prometheus.NewRegistry(), t.TempDir(), "WHIZARD-TENANT")
+	if err := w.ApplyConfig([]ExternalRemoteWriteConfig{{URL: &config_util.URL{URL: external}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantHeader:         "WHIZARD-TENANT",
+		TenantLabelName:      "tenant_id",
+		RemoteWriteProxy:     NewSingleHostReverseProxy(target, http.DefaultTransport),
+		ExternalRemoteWriter: w,
+	})
+
+	body, err := encodeWriteRequest(&prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: time.Now().UnixMilli()}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the requests written downstream are forwarded to the external targets.
+	for _, tc := range []struct {
+		status int
+		queued float64
+	}{
+		{http.StatusInternalServerError, 0},
+		{http.StatusTooManyRequests, 0},
+		{http.StatusNoContent, 1},
+	} {
+		status.Store(int32(tc.status))
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/t1/api/v1/receive", bytes.NewReader(body)))
+		if rec.Code != tc.status {
+			t.Fatalf("expected status %d, got %d", tc.status, rec.Code)
+		}
+		if v := testutil.ToFloat64(w.metrics.queueLength.WithLabelValues(external.String())); v != tc.queued {
+			t.Fatalf("status %d: expected %v queued requests, got %v", tc.status, tc.queued, v)
+		}
+	}
+}
+
+func TestRemoteWriteQueueAllowsTenant(t *testing.T) {
+	for _, tc := range []struct {
+		allowed, denied []string
+		tenant          string
+		expected        bool
+	}{
+		{tenant: "a", expected: true},
+		{allowed: []string{"a"}, tenant: "a", expected: true},
+		{allowed: []string{"a"}, tenant: "b", expected: false},
+		{allowed: []string{"a"}, tenant: "", expected: false},
+		{denied: []string{"a"}, tenant: "a", expected: false},
+		{denied: []string{"a"}, tenant: "b", expected: true},
+		{allowed: []string{"a"}, denied: []string{"a"}, tenant: "a", expected: false},
+	} {
+		q := &remoteWriteQueue{allowedTenants: stringSet(tc.allowed), deniedTenants: stringSet(tc.denied)}
+		if got := q.allowsTenant(tc.tenant); got != tc.expected {
+			t.Fatalf("allowed %v, denied %v: expected %v for tenant %q, got %v", tc.allowed, tc.denied, tc.expected, tc.tenant, got)
+		}
+	}
+}
+
+func TestExternalRemoteWriterApplyConfig(t *testing.T) {
+	received := make(chan http.Header, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	dir := t.TempDir()
+	w := NewExternalRemoteWriter(nil, prometheus.NewRegistry(), dir, "WHIZARD-TENANT")
+	if err := w.ApplyConfig([]ExternalRemoteWriteConfig{{URL: &config_util.URL{URL: u}}, {URL: &config_util.URL{URL: u}}}); err == nil {
+		t.Fatal("expected error for duplicate urls")
+	}
+	if err := w.ApplyConfig([]ExternalRemoteWriteConfig{{URL: &config_util.URL{URL: u}, Headers: map[string]string{"X-Version": "1"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = w.Run(ctx) }()
+
+	expectHeader := func(version string) {
+		t.Helper()
+		w.Enqueue("a", promconfig.RemoteWriteProtoMsgV1, []byte("body"))
+		select {
+		case h := <-received:
+			if h.Get("X-Version") != version {
+				t.Fatalf("expected version %s, got %s", version, h.Get("X-Version"))
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("request was not delivered")
+		}
+	}
+	expectHeader("1")
+
+	// The changed target is sent to by a new sender.
+	if err := w.ApplyConfig([]ExternalRemoteWriteConfig{{URL: &config_util.URL{URL: u}, Headers: map[string]string{"X-Version": "2"}}}); err != nil {
+		t.Fatal(err)
+	}
+	expectHeader("2")
+
+	// The queue of a removed target is deleted.
+	if err := w.ApplyConfig(nil); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected queue to be removed, got %v", entries)
+	}
+	w.Enqueue("a", promconfig.RemoteWriteProtoMsgV1, []byte("body"))
+	select {
+	case <-received:
+		t.Fatal("unexpected request to removed target")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,114 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// remoteRead enforces the tenant matcher on every query of a remote read request, and proxies it to the query target.
+// The responses are proxied as is, including the streamed chunked responses which are flushed immediately.
+func (h *Handler) remoteRead(w http.ResponseWriter, req *http.Request) {
+	if h.queryProxy == nil {
+		http.Error(w, "The query target is not configured for the server", http.StatusNotAcceptable)
+		return
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = req.Body.Close()
+
+	rreq, err := decodeReadRequest(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	requestInfo, _ := requestInfoFrom(req.Context())
+	enforcer := newTenantEnforcer(h.options.TenantLabelName, requestInfo.Tenants)
+	limits := h.limits.QueryLimits(requestInfo.Tenants)
+
+	for _, q := range rreq.Queries {
+		if err := checkReadQueryLength(limits, q); err != nil {
+			h.writeQueryError(w, requestInfo.TenantId, err)
+			return
+		}
+		if err := enforceReadQuery(enforcer, q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if body, err = encodeReadRequest(rreq); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	proxy := *h.queryProxy // 浅拷贝
+	proxy.FlushInterval = -1
+	originalDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	proxy.ServeHTTP(w, req)
+}
+
+// enforceReadQuery validates the tenant matchers of a remote read query, and appends the tenant matcher to it.
+func enforceReadQuery(e *tenantEnforcer, q *prompb.Query) error {
+	ms := make([]*labels.Matcher, 0, len(q.Matchers))
+	for _, m := range q.Matchers {
+		var t labels.MatchType
+		switch m.Type {
+		case prompb.LabelMatcher_EQ:
+			t = labels.MatchEqual
+		case prompb.LabelMatcher_NEQ:
+			t = labels.MatchNotEqual
+		case prompb.LabelMatcher_RE:
+			t = labels.MatchRegexp
+		case prompb.LabelMatcher_NRE:
+			t = labels.MatchNotRegexp
+		default:
+			return fmt.Errorf("invalid matcher type %s", m.Type)
+		}
+		matcher, err := labels.NewMatcher(t, m.Name, m.Value)
+		if err != nil {
+			return err
+		}
+		ms = append(ms, matcher)
+	}
+	if err := e.validateMatchers(ms); err != nil {
+		return err
+	}
+
+	matcher := &prompb.LabelMatcher{Type: prompb.LabelMatcher_EQ, Name: e.matcher.Name, Value: e.matcher.Value}
+	if e.matcher.Type == labels.MatchRegexp {
+		matcher.Type = prompb.LabelMatcher_RE
+	}
+	q.Matchers = append(q.Matchers, matcher)
+	return nil
+}
+
+// checkReadQueryLength checks the time range of a remote read query against the limits.
+func checkReadQueryLength(limits TenantLimits, q *prompb.Query) *apiError {
+	length := time.Duration(q.EndTimestampMs-q.StartTimestampMs) * time.Millisecond
+	if limits.MaxQueryLength > 0 && length > time.Duration(limits.MaxQueryLength) {
+		return &apiError{
+			status:      http.StatusBadRequest,
+			errorType:   errorTypeBadData,
+			err:         fmt.Errorf("the query time range %s exceeds the limit %s", model.Duration(length), limits.MaxQueryLength),
+			limitReason: queryLimitReasonLength,
+		}
+	}
+	return nil
+}
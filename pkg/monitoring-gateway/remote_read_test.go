@@ -0,0 +1,97 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestRemoteRead(t *testing.T) {
+	var matchers [][]prompb.LabelMatcher
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		rreq, err := decodeReadRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		matchers = nil
+		for _, q := range rreq.Queries {
+			var ms []prompb.LabelMatcher
+			for _, m := range q.Matchers {
+				ms = append(ms, *m)
+			}
+			matchers = append(matchers, ms)
+		}
+
+		// Respond a streamed chunked response of a frame per query.
+		w.Header().Set("Content-Type", "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse")
+		for range rreq.Queries {
+			_, _ = w.Write([]byte("frame"))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantLabelName: "tenant_id",
+		QueryProxy:      NewSingleHostReverseProxy(target, http.DefaultTransport),
+	})
+
+	read := func(tenant string, queries ...*prompb.Query) *httptest.ResponseRecorder {
+		body, err := encodeReadRequest(&prompb.ReadRequest{
+			Queries:               queries,
+			AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_STREAMED_XOR_CHUNKS},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/"+tenant+"/api/v1/read", bytes.NewReader(body))
+		req.Header.Set("Content-Encoding", "snappy")
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, req)
+		return rec
+	}
+	up := prompb.LabelMatcher{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "up"}
+
+	rec := read("t1",
+		&prompb.Query{Matchers: []*prompb.LabelMatcher{&up}},
+		&prompb.Query{Matchers: []*prompb.LabelMatcher{&up, {Type: prompb.LabelMatcher_EQ, Name: "tenant_id", Value: "t2"}}},
+	)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Body.String() != "frameframe" {
+		t.Fatalf("unexpected response %q", rec.Body.String())
+	}
+	tenant := prompb.LabelMatcher{Type: prompb.LabelMatcher_EQ, Name: "tenant_id", Value: "t1"}
+	if diff := cmp.Diff([][]prompb.LabelMatcher{
+		{up, tenant},
+		{up, {Type: prompb.LabelMatcher_EQ, Name: "tenant_id", Value: "t2"}, tenant},
+	}, matchers); diff != "" {
+		t.Fatal(diff)
+	}
+
+	rec = read("t1|t2", &prompb.Query{Matchers: []*prompb.LabelMatcher{&up}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if diff := cmp.Diff([][]prompb.LabelMatcher{
+		{up, {Type: prompb.LabelMatcher_RE, Name: "tenant_id", Value: "t1|t2"}},
+	}, matchers); diff != "" {
+		t.Fatal(diff)
+	}
+
+	rec = read("t1|t2", &prompb.Query{Matchers: []*prompb.LabelMatcher{&up, {Type: prompb.LabelMatcher_RE, Name: "tenant_id", Value: ".+"}}})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
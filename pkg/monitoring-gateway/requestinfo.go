@@ -2,17 +2,133 @@ package monitoringgateway
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 )
 
 type requestInfoKeyType int
 
 const requestInfoKey requestInfoKeyType = iota
 
+// tenantSeparator separates the tenants of a multi-tenant read, e.g. /t1|t2/api/v1/query.
+const tenantSeparator = "|"
+
+// The verbs of the tenant requests, which the tenant access is authorized for.
+const (
+	verbRead  = "read"
+	verbWrite = "write"
+)
+
+// TenantSource is a source the tenants of a request are resolved from.
+type TenantSource string
+
+const (
+	// TenantSourcePath resolves the tenants from the path prefix, e.g. /t1/api/v1/query.
+	TenantSourcePath TenantSource = "path"
+	// TenantSourceHeader resolves the tenants from the tenant headers, e.g. X-Scope-OrgID.
+	TenantSourceHeader TenantSource = "header"
+	// TenantSourceCert resolves the tenant from the common name of the client certificate.
+	TenantSourceCert TenantSource = "cert"
+	// TenantSourceClaim resolves the tenants from the tenants of the authenticated identity,
+	// e.g. the tenants claim of an OIDC token.
+	TenantSourceClaim TenantSource = "claim"
+)
+
+// OrgIDHeader is the tenant header of the Cortex and Mimir convention.
+const OrgIDHeader = "X-Scope-OrgID"
+
+// TenantResolver resolves the tenants of a request from the sources in order, the first source
+// which the tenants are resolved from wins.
+type TenantResolver struct {
+	Sources []TenantSource
+	// Headers are the headers the header source resolves the tenants from, in order.
+	Headers []string
+}
+
+// defaultTenantResolver resolves the tenants from the path prefix only.
+var defaultTenantResolver = &TenantResolver{Sources: []TenantSource{TenantSourcePath}}
+
+// NewTenantResolver returns a tenant resolver for the sources, the tenants are resolved from the path
+// if no source is given.
+func NewTenantResolver(sources []string, headers []string) (*TenantResolver, error) {
+	r := &TenantResolver{Headers: headers}
+	for _, s := range sources {
+		switch source := TenantSource(s); source {
+		case TenantSourcePath, TenantSourceHeader, TenantSourceCert, TenantSourceClaim:
+			r.Sources = append(r.Sources, source)
+		default:
+			return nil, fmt.Errorf("unknown tenant source %q", s)
+		}
+	}
+	if len(r.Sources) == 0 {
+		r.Sources = []TenantSource{TenantSourcePath}
+	}
+	if r.resolvesFrom(TenantSourceHeader) && len(r.Headers) == 0 {
+		return nil, fmt.Errorf("no headers are given for the tenant source %q", TenantSourceHeader)
+	}
+	return r, nil
+}
+
+func (r *TenantResolver) resolvesFrom(source TenantSource) bool {
+	for _, s := range r.Sources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve resolves the tenants of a request. The claim source needs the authenticated identity,
+// so the resolution is deferred if the identity is not known yet when reaching the claim source.
+func (r *TenantResolver) resolve(req *http.Request, identity *Identity) (tenants []string, deferred bool, err error) {
+	for _, source := range r.Sources {
+		switch source {
+		case TenantSourcePath:
+			tenants, err = parseTenants(mux.Vars(req)["tenant_id"])
+		case TenantSourceHeader:
+			for _, header := range r.Headers {
+				if v := req.Header.Get(header); v != "" {
+					tenants, err = parseTenants(v)
+					break
+				}
+			}
+		case TenantSourceCert:
+			if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+				tenants, err = parseTenants(req.TLS.PeerCertificates[0].Subject.CommonName)
+			}
+		case TenantSourceClaim:
+			if identity == nil {
+				return nil, true, nil
+			}
+			// The identities allowed to access all tenants don't tell the tenants of the request.
+			if !identity.AllowsTenant(allTenants) {
+				tenants = identity.Tenants
+			}
+		}
+		if err != nil || len(tenants) > 0 {
+			return tenants, false, err
+		}
+	}
+	return nil, false, nil
+}
+
 type RequestInfo struct {
+	// TenantId is the tenant of the request, or the tenants separated by "|" for multi-tenant reads.
 	TenantId string
+	// Tenants are the tenants of the request, there are more than one for multi-tenant reads.
+	Tenants []string
+	// Verb is either read or write, depending on the endpoint of the request.
+	Verb string
+	// Identity is the authenticated requester, if the request is authenticated.
+	Identity *Identity
+
+	// resolveTenants resolves the tenants once the requester is authenticated,
+	// it's set if the tenant resolution is deferred to the authentication.
+	resolveTenants func(identity *Identity) error
 }
 
 func requestInfoFrom(ctx context.Context) (*RequestInfo, bool) {
@@ -20,19 +136,85 @@ func requestInfoFrom(ctx context.Context) (*RequestInfo, bool) {
 	return info, ok
 }
 
-func withRequestInfo(f http.HandlerFunc) http.HandlerFunc {
+// setTenants sets the resolved tenants of the request, the writes are accepted for a single tenant only.
+func (info *RequestInfo) setTenants(tenants []string) error {
+	if info.Verb == verbWrite && len(tenants) > 1 {
+		return errors.New("writes are accepted for a single tenant only")
+	}
+	info.Tenants = tenants
+	info.TenantId = strings.Join(tenants, tenantSeparator)
+	return nil
+}
+
+func withRequestInfo(f http.HandlerFunc, resolver *TenantResolver) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 
 		// remove the prefix /:tenant_id from path
-		if index := indexByteNth(req.URL.Path, '/', 2); index > 0 {
-			req.URL.Path = req.URL.Path[index:]
+		if _, ok := mux.Vars(req)["tenant_id"]; ok {
+			if index := indexByteNth(req.URL.Path, '/', 2); index > 0 {
+				req.URL.Path = req.URL.Path[index:]
+			}
 		}
 		ctx := req.Context()
 
-		req = req.WithContext(context.WithValue(ctx, requestInfoKey, &RequestInfo{
-			TenantId: mux.Vars(req)["tenant_id"],
-		}))
+		info := &RequestInfo{Verb: requestVerb(req.URL.Path)}
+		tenants, deferred, err := resolver.resolve(req, nil)
+		if err == nil {
+			err = info.setTenants(tenants)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if deferred {
+			info.resolveTenants = func(identity *Identity) error {
+				tenants, _, err := resolver.resolve(req, identity)
+				if err != nil {
+					return err
+				}
+				return info.setTenants(tenants)
+			}
+		}
+
+		req = req.WithContext(context.WithValue(ctx, requestInfoKey, info))
 
 		f.ServeHTTP(w, req)
 	})
 }
+
+// requestVerb returns the verb of a request by its endpoint, without the tenant prefix.
+func requestVerb(path string) string {
+	switch {
+	case path == apiGlobalPrefix+epReceive, path == apiGlobalPrefix+epOTLP:
+		return verbWrite
+	// The pushes are grouped by the job and instance of their paths.
+	case path == apiGlobalPrefix+epPush, strings.HasPrefix(path, apiGlobalPrefix+epPush+"/"):
+		return verbWrite
+	default:
+		return verbRead
+	}
+}
+
+// parseTenants parses the tenants separated by "|", the duplicates are removed.
+func parseTenants(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var (
+		parts   = strings.Split(s, tenantSeparator)
+		tenants = make([]string, 0, len(parts))
+		seen    = make(map[string]struct{}, len(parts))
+	)
+	for _, t := range parts {
+		if t == "" {
+			return nil, fmt.Errorf("invalid tenants %q", s)
+		}
+		if _, ok := seen[t]; ok {
+			continue
+		}
+		seen[t] = struct{}{}
+		tenants = append(tenants, t)
+	}
+	return tenants, nil
+}
@@ -0,0 +1,175 @@
+package monitoringgateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestTenantResolver(t *testing.T) {
+	var downstream *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		downstream = req
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	apiKeys, err := NewAPIKeyAuthenticator(APIKeysAuthConfig{Keys: []APIKey{{Name: "grafana", Key: "secret", Tenants: []string{"t2"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name           string
+		sources        []string
+		authenticators []Authenticator
+		path           string
+		header         http.Header
+		code           int
+		query          string
+	}{
+		{
+			name:    "path",
+			sources: nil,
+			path:    "/t1/api/v1/query",
+			code:    http.StatusOK,
+			query:   `up{tenant_id="t1"}`,
+		},
+		{
+			name:    "global route without tenant",
+			sources: []string{"path", "header"},
+			path:    "/api/v1/query",
+			code:    http.StatusOK,
+			query:   `up`,
+		},
+		{
+			name:    "org id header",
+			sources: []string{"path", "header"},
+			path:    "/api/v1/query",
+			header:  http.Header{OrgIDHeader: []string{"t1"}},
+			code:    http.StatusOK,
+			query:   `up{tenant_id="t1"}`,
+		},
+		{
+			name:    "tenant header before org id header",
+			sources: []string{"header"},
+			path:    "/api/v1/query",
+			header:  http.Header{OrgIDHeader: []string{"t1"}, "Whizard-Tenant": []string{"t3"}},
+			code:    http.StatusOK,
+			query:   `up{tenant_id="t3"}`,
+		},
+		{
+			name:    "multiple tenants header",
+			sources: []string{"header"},
+			path:    "/api/v1/query",
+			header:  http.Header{OrgIDHeader: []string{"t1|t2"}},
+			code:    http.StatusOK,
+			query:   `up{tenant_id=~"t1|t2"}`,
+		},
+		{
+			name:    "invalid tenants header",
+			sources: []string{"header"},
+			path:    "/api/v1/query",
+			header:  http.Header{OrgIDHeader: []string{"t1||t2"}},
+			code:    http.StatusBadRequest,
+		},
+		{
+			name:    "path before header",
+			sources: []string{"path", "header"},
+			path:    "/t1/api/v1/query",
+			header:  http.Header{OrgIDHeader: []string{"t2"}},
+			code:    http.StatusOK,
+			query:   `up{tenant_id="t1"}`,
+		},
+		{
+			name:           "claim",
+			sources:        []string{"claim"},
+			authenticators: []Authenticator{apiKeys},
+			path:           "/api/v1/query",
+			header:         http.Header{"X-Api-Key": []string{"secret"}},
+			code:           http.StatusOK,
+			query:          `up{tenant_id="t2"}`,
+		},
+		{
+			name:           "claim without credentials",
+			sources:        []string{"claim"},
+			authenticators: []Authenticator{apiKeys},
+			path:           "/api/v1/query",
+			code:           http.StatusUnauthorized,
+		},
+		{
+			name:           "global route without tenant authenticated",
+			sources:        []string{"path", "header"},
+			authenticators: []Authenticator{apiKeys},
+			path:           "/api/v1/query",
+			header:         http.Header{"X-Api-Key": []string{"secret"}},
+			code:           http.StatusUnauthorized,
+		},
+		{
+			name:           "global endpoint not tenant aware authenticated",
+			sources:        []string{"path", "header"},
+			authenticators: []Authenticator{apiKeys},
+			path:           "/api/v1/status/buildinfo",
+			header:         http.Header{"X-Api-Key": []string{"secret"}},
+			code:           http.StatusNotFound,
+		},
+		{
+			name:           "header not allowed by claim",
+			sources:        []string{"header", "claim"},
+			authenticators: []Authenticator{apiKeys},
+			path:           "/api/v1/query",
+			header:         http.Header{OrgIDHeader: []string{"t1"}, "X-Api-Key": []string{"secret"}},
+			code:           http.StatusForbidden,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resolver, err := NewTenantResolver(tc.sources, []string{"WHIZARD-TENANT", OrgIDHeader})
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+				TenantHeader:    "WHIZARD-TENANT",
+				TenantLabelName: "tenant_id",
+				TenantResolver:  resolver,
+				QueryProxy:      NewSingleHostReverseProxy(target, http.DefaultTransport),
+				Authenticators:  tc.authenticators,
+			})
+
+			downstream = nil
+			req := httptest.NewRequest(http.MethodGet, tc.path+"?query=up", nil)
+			for k, v := range tc.header {
+				req.Header[http.CanonicalHeaderKey(k)] = v
+			}
+			rec := httptest.NewRecorder()
+			h.Router().ServeHTTP(rec, req)
+
+			if rec.Code != tc.code {
+				t.Fatalf("expected status %d, got %d: %s", tc.code, rec.Code, rec.Body.String())
+			}
+			if tc.code != http.StatusOK {
+				return
+			}
+			if downstream == nil {
+				t.Fatal("the request is not proxied")
+			}
+			if downstream.URL.Path != "/api/v1/query" {
+				t.Fatalf("unexpected downstream path %s", downstream.URL.Path)
+			}
+			if got := downstream.URL.Query().Get(queryParam); got != tc.query {
+				t.Fatalf("expected query %s, got %s", tc.query, got)
+			}
+		})
+	}
+}
+
+func TestNewTenantResolver(t *testing.T) {
+	if _, err := NewTenantResolver([]string{"cookie"}, nil); err == nil {
+		t.Fatal("expected error for an unknown tenant source")
+	}
+	if _, err := NewTenantResolver([]string{"header"}, nil); err == nil {
+		t.Fatal("expected error for the header source without headers")
+	}
+}
@@ -0,0 +1,286 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ruleNameParam  = "rule_name[]"
+	ruleGroupParam = "rule_group[]"
+	fileParam      = "file[]"
+	ruleTypeParam  = "type"
+)
+
+// The states of the alerting rules and alerts, in the order of precedence.
+var alertStates = []string{"inactive", "pending", "firing"}
+
+// rulesProxy returns the proxy of the rules and alerts requests, the rules query target if it's configured.
+func (h *Handler) rulesProxy() *httputil.ReverseProxy {
+	if h.rulesQueryProxy != nil {
+		return h.rulesQueryProxy
+	}
+	return h.queryProxy
+}
+
+// alerts proxies the alerts requests to the rules query target, and filters the alerts of the response by the tenants,
+// since thanos doesn't support filtering the alerts by matchers.
+func (h *Handler) alerts(w http.ResponseWriter, req *http.Request) {
+	proxy := h.rulesProxy()
+	if proxy == nil {
+		http.Error(w, "The query target is not configured for the server", http.StatusNotAcceptable)
+		return
+	}
+
+	requestInfo, _ := requestInfoFrom(req.Context())
+	f := &rulesFilter{labelName: h.options.TenantLabelName, tenants: requestInfo.Tenants}
+
+	filterResponseData(proxy, func(data map[string]json.RawMessage) error {
+		alerts, _, err := f.filterAlerts(data["alerts"])
+		if err != nil {
+			return err
+		}
+		data["alerts"] = alerts
+		return nil
+	}).ServeHTTP(w, req)
+}
+
+// rules proxies the rules requests to the rules query target with the tenant matchers injected, and filters the groups
+// and rules of the response by the tenants, without relying on the target honoring the matchers.
+func (h *Handler) rules(w http.ResponseWriter, req *http.Request) {
+	proxy := h.rulesProxy()
+	if proxy == nil {
+		http.Error(w, "The query target is not configured for the server", http.StatusNotAcceptable)
+		return
+	}
+
+	requestInfo, _ := requestInfoFrom(req.Context())
+	q := req.URL.Query()
+
+	f, err := newRulesFilter(q, h.options.TenantLabelName, requestInfo.Tenants, h.options.StrictRulesTenancy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := injectMatcher(q, newTenantEnforcer(h.options.TenantLabelName, requestInfo.Tenants), matchersParam); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.URL.RawQuery = q.Encode()
+
+	filterResponseData(proxy, f.filterRules).ServeHTTP(w, req)
+}
+
+// rulesFilter filters the rules and alerts of the responses by the tenants, and the rules by the request filters.
+type rulesFilter struct {
+	labelName string
+	tenants   []string
+	// strict drops the rules without the tenant label, which are kept otherwise.
+	strict bool
+
+	ruleNames  []string
+	ruleGroups []string
+	files      []string
+	ruleType   string
+}
+
+func newRulesFilter(q url.Values, labelName string, tenants []string, strict bool) (*rulesFilter, error) {
+	f := &rulesFilter{
+		labelName:  labelName,
+		tenants:    tenants,
+		strict:     strict,
+		ruleNames:  q[ruleNameParam],
+		ruleGroups: q[ruleGroupParam],
+		files:      q[fileParam],
+	}
+	switch t := q.Get(ruleTypeParam); t {
+	case "":
+	case "alert":
+		f.ruleType = "alerting"
+	case "record":
+		f.ruleType = "recording"
+	default:
+		return nil, errors.Errorf("invalid query parameter type='%s'", t)
+	}
+	return f, nil
+}
+
+// filterRules filters the rule groups of the rules response data, the groups without rules left are dropped.
+func (f *rulesFilter) filterRules(data map[string]json.RawMessage) error {
+	var groups []map[string]json.RawMessage
+	if err := json.Unmarshal(data["groups"], &groups); err != nil {
+		return errors.Wrap(err, "failed to decode rule groups")
+	}
+
+	filteredGroups := make([]map[string]json.RawMessage, 0, len(groups))
+	for _, group := range groups {
+		var name, file string
+		_ = json.Unmarshal(group["name"], &name)
+		_ = json.Unmarshal(group["file"], &file)
+		if !matchesAny(f.ruleGroups, name) || !matchesAny(f.files, file) {
+			continue
+		}
+
+		var rules []map[string]json.RawMessage
+		if err := json.Unmarshal(group["rules"], &rules); err != nil {
+			return errors.Wrap(err, "failed to decode rules")
+		}
+		filteredRules := make([]map[string]json.RawMessage, 0, len(rules))
+		for _, rule := range rules {
+			ok, err := f.filterRule(rule)
+			if err != nil {
+				return err
+			}
+			if ok {
+				filteredRules = append(filteredRules, rule)
+			}
+		}
+		if len(filteredRules) == 0 {
+			continue
+		}
+
+		var err error
+		if group["rules"], err = json.Marshal(filteredRules); err != nil {
+			return err
+		}
+		filteredGroups = append(filteredGroups, group)
+	}
+
+	var err error
+	data["groups"], err = json.Marshal(filteredGroups)
+	return err
+}
+
+// filterRule returns true if the rule is kept, the alerts of an alerting rule are filtered by the tenants,
+// and the state of the rule is updated by the alerts left.
+func (f *rulesFilter) filterRule(rule map[string]json.RawMessage) (bool, error) {
+	var r struct {
+		Name   string            `json:"name"`
+		Type   string            `json:"type"`
+		Labels map[string]string `json:"labels"`
+	}
+	for k, v := range map[string]interface{}{"name": &r.Name, "type": &r.Type, "labels": &r.Labels} {
+		if raw, ok := rule[k]; ok {
+			if err := json.Unmarshal(raw, v); err != nil {
+				return false, errors.Wrapf(err, "failed to decode rule %s", k)
+			}
+		}
+	}
+
+	if !matchesAny(f.ruleNames, r.Name) || (f.ruleType != "" && r.Type != f.ruleType) {
+		return false, nil
+	}
+	if tenant, ok := r.Labels[f.labelName]; ok {
+		if !slices.Contains(f.tenants, tenant) {
+			return false, nil
+		}
+	} else if f.strict {
+		return false, nil
+	}
+
+	if raw, ok := rule["alerts"]; ok {
+		alerts, state, err := f.filterAlerts(raw)
+		if err != nil {
+			return false, err
+		}
+		rule["alerts"] = alerts
+		if _, ok := rule["state"]; ok {
+			if rule["state"], err = json.Marshal(state); err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// filterAlerts filters the alerts by the tenants, and returns the alerts left with their highest state.
+func (f *rulesFilter) filterAlerts(raw json.RawMessage) (json.RawMessage, string, error) {
+	var alerts []json.RawMessage
+	if err := json.Unmarshal(raw, &alerts); err != nil {
+		return nil, "", errors.Wrap(err, "failed to decode alerts")
+	}
+
+	state := alertStates[0]
+	filtered := make([]json.RawMessage, 0, len(alerts))
+	for _, raw := range alerts {
+		var alert struct {
+			Labels map[string]string `json:"labels"`
+			State  string            `json:"state"`
+		}
+		if err := json.Unmarshal(raw, &alert); err != nil {
+			return nil, "", errors.Wrap(err, "failed to decode alert")
+		}
+		if !slices.Contains(f.tenants, alert.Labels[f.labelName]) {
+			continue
+		}
+		filtered = append(filtered, raw)
+		if slices.Index(alertStates, alert.State) > slices.Index(alertStates, state) {
+			state = alert.State
+		}
+	}
+
+	out, err := json.Marshal(filtered)
+	return out, state, err
+}
+
+// matchesAny returns true if no values are given to match, or v is one of the values.
+func matchesAny(values []string, v string) bool {
+	return len(values) == 0 || slices.Contains(values, v)
+}
+
+// filterResponseData returns a copy of the proxy, which modifies the data of the successful API responses by filter.
+func filterResponseData(proxy *httputil.ReverseProxy, filter func(data map[string]json.RawMessage) error) *httputil.ReverseProxy {
+	p := *proxy // 浅拷贝
+	originalDirector := p.Director
+	p.Director = func(req *http.Request) {
+		originalDirector(req)
+		// The response is decoded, so that it's requested uncompressed.
+		req.Header.Del("Accept-Encoding")
+	}
+	p.ModifyResponse = func(resp *http.Response) error {
+		if resp.StatusCode != http.StatusOK {
+			return nil
+		}
+		if encoding := resp.Header.Get("Content-Encoding"); encoding != "" {
+			return errors.Errorf("unsupported content encoding %s of the response", encoding)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+
+		var apiResp map[string]json.RawMessage
+		if err := json.Unmarshal(body, &apiResp); err != nil {
+			return errors.Wrap(err, "failed to decode response")
+		}
+		var data map[string]json.RawMessage
+		if err := json.Unmarshal(apiResp["data"], &data); err != nil {
+			return errors.Wrap(err, "failed to decode response data")
+		}
+		if err := filter(data); err != nil {
+			return err
+		}
+		if apiResp["data"], err = json.Marshal(data); err != nil {
+			return err
+		}
+		if body, err = json.Marshal(apiResp); err != nil {
+			return err
+		}
+
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		return nil
+	}
+	return &p
+}
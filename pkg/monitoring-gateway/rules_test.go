@@ -0,0 +1,201 @@
+package monitoringgateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAlerts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/alerts" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"success","data":{"alerts":[
+			{"labels":{"alertname":"A","tenant_id":"t1"},"state":"firing","value":"1e+00"},
+			{"labels":{"alertname":"B","tenant_id":"t2"},"state":"firing","value":"1e+00"},
+			{"labels":{"alertname":"C"},"state":"pending","value":"1e+00"},
+			{"labels":{"alertname":"D","tenant_id":"t3"},"state":"firing","value":"1e+00"}
+		]}}`))
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantLabelName: "tenant_id",
+		QueryProxy:      NewSingleHostReverseProxy(target, http.DefaultTransport),
+		RulesQueryProxy: NewSingleHostReverseProxy(target, http.DefaultTransport),
+	})
+
+	for _, tc := range []struct {
+		path   string
+		alerts []string
+	}{
+		{path: "/t1/api/v1/alerts", alerts: []string{"A"}},
+		{path: "/t1|t3/api/v1/alerts", alerts: []string{"A", "D"}},
+		{path: "/t4/api/v1/alerts", alerts: []string{}},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", tc.path, rec.Code, rec.Body.String())
+		}
+
+		var resp struct {
+			Status string `json:"status"`
+			Data   struct {
+				Alerts []struct {
+					Labels map[string]string `json:"labels"`
+					State  string            `json:"state"`
+				} `json:"alerts"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != "success" {
+			t.Fatalf("%s: unexpected status %s", tc.path, resp.Status)
+		}
+		names := []string{}
+		for _, alert := range resp.Data.Alerts {
+			names = append(names, alert.Labels["alertname"])
+			if alert.State == "" {
+				t.Fatalf("%s: the alert fields are not preserved", tc.path)
+			}
+		}
+		if diff := cmp.Diff(tc.alerts, names); diff != "" {
+			t.Fatalf("%s: %s", tc.path, diff)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	var downstream *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		downstream = req
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"success","data":{"groups":[
+			{"name":"g1","file":"f1","interval":30,"rules":[
+				{"name":"A","type":"alerting","state":"firing","labels":{"tenant_id":"t1"},"alerts":[{"labels":{"alertname":"A","tenant_id":"t1"},"state":"pending"}]},
+				{"name":"r1","type":"recording","labels":{"tenant_id":"t1"}},
+				{"name":"B","type":"alerting","state":"firing","labels":{"tenant_id":"t2"},"alerts":[{"labels":{"alertname":"B","tenant_id":"t2"},"state":"firing"}]}
+			]},
+			{"name":"g2","file":"f2","interval":30,"rules":[
+				{"name":"C","type":"alerting","state":"firing","alerts":[{"labels":{"alertname":"C","tenant_id":"t2"},"state":"firing"},{"labels":{"alertname":"C","tenant_id":"t1"},"state":"pending"}]}
+			]},
+			{"name":"g3","file":"f3","interval":30,"rules":[
+				{"name":"D","type":"alerting","state":"firing","labels":{"tenant_id":"t2"},"alerts":[]}
+			]}
+		]}}`))
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	type alert struct {
+		State string `json:"state"`
+	}
+	type rule struct {
+		Name   string  `json:"name"`
+		State  string  `json:"state"`
+		Alerts []alert `json:"alerts"`
+	}
+	type group struct {
+		Name     string `json:"name"`
+		Interval int    `json:"interval"`
+		Rules    []rule `json:"rules"`
+	}
+
+	for _, tc := range []struct {
+		name   string
+		strict bool
+		query  string
+		code   int
+		groups []group
+	}{
+		{
+			name: "tenant",
+			groups: []group{
+				{Name: "g1", Interval: 30, Rules: []rule{{Name: "A", State: "pending", Alerts: []alert{{State: "pending"}}}, {Name: "r1"}}},
+				{Name: "g2", Interval: 30, Rules: []rule{{Name: "C", State: "pending", Alerts: []alert{{State: "pending"}}}}},
+			},
+		},
+		{
+			name:   "strict",
+			strict: true,
+			groups: []group{
+				{Name: "g1", Interval: 30, Rules: []rule{{Name: "A", State: "pending", Alerts: []alert{{State: "pending"}}}, {Name: "r1"}}},
+			},
+		},
+		{
+			name:  "rule type",
+			query: "type=record",
+			groups: []group{
+				{Name: "g1", Interval: 30, Rules: []rule{{Name: "r1"}}},
+			},
+		},
+		{
+			name:  "rule names and groups",
+			query: "rule_name[]=A&rule_name[]=C&rule_group[]=g2",
+			groups: []group{
+				{Name: "g2", Interval: 30, Rules: []rule{{Name: "C", State: "pending", Alerts: []alert{{State: "pending"}}}}},
+			},
+		},
+		{
+			name:   "files",
+			query:  "file[]=f3",
+			groups: []group{},
+		},
+		{
+			name:  "invalid rule type",
+			query: "type=unknown",
+			code:  http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+				TenantLabelName:    "tenant_id",
+				QueryProxy:         NewSingleHostReverseProxy(target, http.DefaultTransport),
+				StrictRulesTenancy: tc.strict,
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/t1/api/v1/rules?"+tc.query, nil)
+			rec := httptest.NewRecorder()
+			h.Router().ServeHTTP(rec, req)
+
+			code := tc.code
+			if code == 0 {
+				code = http.StatusOK
+			}
+			if rec.Code != code {
+				t.Fatalf("expected status %d, got %d: %s", code, rec.Code, rec.Body.String())
+			}
+			if code != http.StatusOK {
+				return
+			}
+			if got := downstream.URL.Query()[matchersParam]; len(got) != 1 || got[0] != `{tenant_id="t1"}` {
+				t.Fatalf("unexpected matchers %v", got)
+			}
+
+			var resp struct {
+				Data struct {
+					Groups []group `json:"groups"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.groups, resp.Data.Groups); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package monitoringgateway
+
+import (
+	"context"
+	"reflect"
+	"slices"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// TenantGVR is the resource of the Tenants the TenantWatcher watches.
+var TenantGVR = schema.GroupVersionResource{Group: "monitoring.whizard.io", Version: "v1alpha1", Resource: "tenants"}
+
+// AdmissionConfigSource is a source of the tenants admission configuration, such as the ConfigWatcher of a file
+// or the TenantWatcher of the Tenant resources.
+type AdmissionConfigSource interface {
+	// Run starts the source until the given context is canceled, and closes the chan of C on return.
+	Run(ctx context.Context)
+	// C returns a chan that gets configuration updates.
+	C() <-chan AdmissionControlConfig
+}
+
+// TenantWatcher watches the Tenant resources matching a label selector by an informer, and sends the admitted
+// tenants on changes. Unlike the ConfigWatcher of the file rendered by the controller, the new tenants are
+// admitted without the sync delay of the mounted ConfigMaps.
+type TenantWatcher struct {
+	ch       chan AdmissionControlConfig
+	client   dynamic.Interface
+	selector labels.Selector
+	logger   log.Logger
+
+	changesCounter prometheus.Counter
+	tenantsGauge   prometheus.Gauge
+}
+
+// NewTenantWatcher creates a TenantWatcher of the Tenants matching the label selector.
+func NewTenantWatcher(logger log.Logger, reg prometheus.Registerer, client dynamic.Interface, selector string) (*TenantWatcher, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	s, err := labels.Parse(selector)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing tenant label selector %q", selector)
+	}
+
+	return &TenantWatcher{
+		ch:       make(chan AdmissionControlConfig),
+		client:   client,
+		selector: s,
+		logger:   logger,
+
+		changesCounter: promauto.With(reg).NewCounter(
+			prometheus.CounterOpts{
+				Name: "whizard_tenant_admission_kubernetes_changes_total",
+				Help: "The number of times the admitted tenants watched from Kubernetes have changed.",
+			}),
+		tenantsGauge: promauto.With(reg).NewGauge(
+			prometheus.GaugeOpts{
+				Name: "whizard_tenant_admission_tenants",
+				Help: "The number of tenants allowed.",
+			}),
+	}, nil
+}
+
+// NewTenantWatcherFromFlags creates the TenantWatcher of the flag configuration, or nil if it's disabled.
+func NewTenantWatcherFromFlags(logger log.Logger, reg prometheus.Registerer, tc *TenantWatcherFlagConfig) (*TenantWatcher, error) {
+	if !tc.Enabled {
+		return nil, nil
+	}
+
+	restConfig, err := clientcmd.BuildConfigFromFlags("", tc.Kubeconfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading kubernetes client configuration")
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating kubernetes client")
+	}
+	return NewTenantWatcher(logger, reg, client, tc.Selector)
+}
+
+// Run starts the TenantWatcher until the given context is canceled.
+func (tw *TenantWatcher) Run(ctx context.Context) {
+	defer close(tw.ch)
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(tw.client, 0, metav1.NamespaceAll, func(opts *metav1.ListOptions) {
+		opts.LabelSelector = tw.selector.String()
+	})
+	informer := factory.ForResource(TenantGVR).Informer()
+
+	// The changes are coalesced, the admitted tenants are rebuilt from the cache once per notification.
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { notify() },
+		UpdateFunc: func(any, any) { notify() },
+		DeleteFunc: func(any) { notify() },
+	}); err != nil {
+		level.Error(tw.logger).Log("msg", "failed to watch tenants", "err", err)
+		return
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return
+	}
+	level.Info(tw.logger).Log("msg", "tenants informer synced", "selector", tw.selector.String())
+
+	var last *AdmissionControlConfig
+	// The first configuration is sent even without tenants, which marks the gateway ready.
+	notify()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+			config := tw.config(informer.GetStore().List())
+			if last != nil && reflect.DeepEqual(*last, config) {
+				break
+			}
+			last = &config
+
+			tw.changesCounter.Inc()
+			tw.tenantsGauge.Set(float64(len(config.Tenants)))
+			level.Debug(tw.logger).Log("msg", "refreshed tenants", "tenants", len(config.Tenants))
+
+			select {
+			case <-ctx.Done():
+				return
+			case tw.ch <- config:
+			}
+		}
+	}
+}
+
+// C returns a chan that gets configuration updates.
+func (tw *TenantWatcher) C() <-chan AdmissionControlConfig {
+	return tw.ch
+}
+
+// config returns the sorted tenants of the Tenant resources that aren't being deleted and their access modes,
+// the same as the controller renders to the admission ConfigMap.
+func (tw *TenantWatcher) config(objs []any) AdmissionControlConfig {
+	config := AdmissionControlConfig{Tenants: []string{}}
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok || u.GetDeletionTimestamp() != nil || !tw.selector.Matches(labels.Set(u.GetLabels())) {
+			continue
+		}
+		tenant, _, _ := unstructured.NestedString(u.Object, "spec", "tenant")
+		if tenant == "" {
+			continue
+		}
+		config.Tenants = append(config.Tenants, tenant)
+
+		mode, _, _ := unstructured.NestedString(u.Object, "spec", "accessMode")
+		if mode != "" && AccessMode(mode) != AccessModeActive {
+			if config.AccessModes == nil {
+				config.AccessModes = map[string]AccessMode{}
+			}
+			config.AccessModes[tenant] = AccessMode(mode)
+		}
+	}
+	slices.Sort(config.Tenants)
+	config.Tenants = slices.Compact(config.Tenants)
+	return config
+}
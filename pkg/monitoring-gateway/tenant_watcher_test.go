@@ -0,0 +1,85 @@
+package monitoringgateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newTenantObject(name, tenant, service string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(schema.GroupVersionKind{Group: "monitoring.whizard.io", Version: "v1alpha1", Kind: "Tenant"})
+	u.SetName(name)
+	u.SetLabels(map[string]string{"monitoring.whizard.io/service": service})
+	_ = unstructured.SetNestedField(u.Object, tenant, "spec", "tenant")
+	return u
+}
+
+func TestTenantWatcher(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{TenantGVR: "TenantList"},
+		newTenantObject("t1", "t1", "ns.svc"),
+		newTenantObject("t2", "t2", "ns.other"),
+	)
+	tw, err := NewTenantWatcher(nil, prometheus.NewRegistry(), client, "monitoring.whizard.io/service=ns.svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan AdmissionControlConfig)
+	done := make(chan error)
+	go func() { done <- ConfigFromWatcher(ctx, updates, tw) }()
+
+	var last AdmissionControlConfig
+	next := func() []string {
+		select {
+		case last = <-updates:
+			return last.Tenants
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the tenants")
+		}
+		return nil
+	}
+
+	if diff := cmp.Diff([]string{"t1"}, next()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	tenants := client.Resource(TenantGVR)
+	t3 := newTenantObject("t3", "t3", "ns.svc")
+	_ = unstructured.SetNestedField(t3.Object, string(AccessModeReadOnly), "spec", "accessMode")
+	if _, err := tenants.Create(ctx, t3, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"t1", "t3"}, next()); diff != "" {
+		t.Fatal(diff)
+	}
+	if mode := last.AccessMode("t3"); mode != AccessModeReadOnly {
+		t.Fatalf("expected t3 to be read-only, got %s", mode)
+	}
+
+	// The tenants of the other services aren't admitted.
+	if _, err := tenants.Create(ctx, newTenantObject("t4", "t4", "ns.other"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tenants.Delete(ctx, "t1", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"t3"}, next()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	cancel()
+	if err := <-done; err == nil {
+		t.Fatal("expected the watcher to stop with an error")
+	}
+}
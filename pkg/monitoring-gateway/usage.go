@@ -0,0 +1,229 @@
+package monitoringgateway
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// usageOverflowTenant is the tenant label value the usage of the tenants over the tenant limit is accounted to.
+const usageOverflowTenant = "__overflow__"
+
+// usageStats is the usage of a write request.
+type usageStats struct {
+	series            int
+	samples           int
+	histograms        int
+	exemplars         int
+	bytes             int
+	uncompressedBytes int
+}
+
+// usageMetrics accounts the usage of the tenants, for chargeback and capacity planning.
+// The number of tenant label values is bounded by maxTenants, the usage of the tenants over it is accounted
+// to the overflow tenant.
+type usageMetrics struct {
+	mtx sync.Mutex
+	// maxTenants is the maximum number of the accounted tenants, zero is unlimited.
+	maxTenants int
+	tenants    map[string]struct{}
+
+	receivedSeries            *prometheus.CounterVec
+	receivedSamples           *prometheus.CounterVec
+	receivedHistograms        *prometheus.CounterVec
+	receivedExemplars         *prometheus.CounterVec
+	receivedBytes             *prometheus.CounterVec
+	receivedUncompressedBytes *prometheus.CounterVec
+	queries                   *prometheus.CounterVec
+	queryDuration             *prometheus.HistogramVec
+	queryResponseBytes        *prometheus.CounterVec
+}
+
+func newUsageMetrics(reg prometheus.Registerer, maxTenants int) *usageMetrics {
+	counter := func(name, help string) *prometheus.CounterVec {
+		return promauto.With(reg).NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, []string{"tenant"})
+	}
+
+	return &usageMetrics{
+		maxTenants: maxTenants,
+		tenants:    make(map[string]struct{}),
+
+		receivedSeries:            counter("whizard_gateway_tenant_received_series_total", "Total number of series in the write requests, labeled by tenant."),
+		receivedSamples:           counter("whizard_gateway_tenant_received_samples_total", "Total number of float samples in the write requests, labeled by tenant."),
+		receivedHistograms:        counter("whizard_gateway_tenant_received_histograms_total", "Total number of histogram samples in the write requests, labeled by tenant."),
+		receivedExemplars:         counter("whizard_gateway_tenant_received_exemplars_total", "Total number of exemplars in the write requests, labeled by tenant."),
+		receivedBytes:             counter("whizard_gateway_tenant_received_bytes_total", "Total number of received bytes of the write requests as sent, labeled by tenant."),
+		receivedUncompressedBytes: counter("whizard_gateway_tenant_received_uncompressed_bytes_total", "Total number of received bytes of the write requests after decompression, labeled by tenant."),
+		queries:                   counter("whizard_gateway_tenant_queries_total", "Total number of query requests, labeled by tenant."),
+		queryDuration: promauto.With(reg).NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "whizard_gateway_tenant_query_duration_seconds",
+				Help:    "Time the query requests took to respond, labeled by tenant.",
+				Buckets: prometheus.ExponentialBuckets(0.005, 4, 8),
+			},
+			[]string{"tenant"},
+		),
+		queryResponseBytes: counter("whizard_gateway_tenant_query_response_bytes_total", "Total number of bytes responded to the query requests, labeled by tenant."),
+	}
+}
+
+// tenantLabel returns the tenant label value the usage of the tenant is accounted to.
+func (u *usageMetrics) tenantLabel(tenant string) string {
+	if u.maxTenants <= 0 {
+		return tenant
+	}
+
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	if _, ok := u.tenants[tenant]; ok {
+		return tenant
+	}
+	if len(u.tenants) >= u.maxTenants {
+		return usageOverflowTenant
+	}
+	u.tenants[tenant] = struct{}{}
+	return tenant
+}
+
+func (u *usageMetrics) observeWrite(tenant string, stats usageStats) {
+	if u == nil || tenant == "" {
+		return
+	}
+
+	tenant = u.tenantLabel(tenant)
+	u.receivedSeries.WithLabelValues(tenant).Add(float64(stats.series))
+	u.receivedSamples.WithLabelValues(tenant).Add(float64(stats.samples))
+	u.receivedHistograms.WithLabelValues(tenant).Add(float64(stats.histograms))
+	u.receivedExemplars.WithLabelValues(tenant).Add(float64(stats.exemplars))
+	u.receivedBytes.WithLabelValues(tenant).Add(float64(stats.bytes))
+	u.receivedUncompressedBytes.WithLabelValues(tenant).Add(float64(stats.uncompressedBytes))
+}
+
+// observeQuery accounts a query request to the tenants, the multi-tenant requests are accounted to every tenant.
+func (u *usageMetrics) observeQuery(tenants []string, duration time.Duration, responseBytes int) {
+	if u == nil {
+		return
+	}
+
+	for _, tenant := range slices.Compact(slices.Sorted(slices.Values(tenants))) {
+		if tenant == "" {
+			continue
+		}
+		tenant = u.tenantLabel(tenant)
+		u.queries.WithLabelValues(tenant).Inc()
+		u.queryDuration.WithLabelValues(tenant).Observe(duration.Seconds())
+		u.queryResponseBytes.WithLabelValues(tenant).Add(float64(responseBytes))
+	}
+}
+
+// writeRequestUsage returns the usage of a remote write request body.
+// The bytes are accounted even if the body fails to decode.
+func writeRequestUsage(b *writeRequestBody) (usageStats, error) {
+	body, err := b.bytes()
+	if err != nil {
+		return usageStats{}, err
+	}
+	stats, err := b.stats()
+	stats.bytes = len(body)
+	if n, err := snappy.DecodedLen(body); err == nil {
+		stats.uncompressedBytes = n
+	}
+	return stats, err
+}
+
+// otlpRequestUsage returns the usage of an OTLP/HTTP metrics export request body, where every data point is
+// accounted as a series. The data points of the histograms and exponential histograms are accounted as histograms.
+func otlpRequestUsage(body []byte, header http.Header, maxSize int64) (usageStats, error) {
+	stats := usageStats{bytes: len(body)}
+	uncompressed, err := decompressOTLPRequest(body, header, maxSize)
+	if err != nil {
+		return stats, err
+	}
+	stats.uncompressedBytes = len(uncompressed)
+
+	// The body is decompressed already.
+	header = header.Clone()
+	header.Del("Content-Encoding")
+	req, err := decodeOTLPRequest(uncompressed, header, 0)
+	if err != nil {
+		return stats, err
+	}
+
+	rms := req.Metrics().ResourceMetrics()
+	for i := 0; i < rms.Len(); i++ {
+		sms := rms.At(i).ScopeMetrics()
+		for j := 0; j < sms.Len(); j++ {
+			ms := sms.At(j).Metrics()
+			for k := 0; k < ms.Len(); k++ {
+				m := ms.At(k)
+				switch m.Type() {
+				case pmetric.MetricTypeGauge:
+					dps := m.Gauge().DataPoints()
+					stats.samples += dps.Len()
+					for l := 0; l < dps.Len(); l++ {
+						stats.exemplars += dps.At(l).Exemplars().Len()
+					}
+				case pmetric.MetricTypeSum:
+					dps := m.Sum().DataPoints()
+					stats.samples += dps.Len()
+					for l := 0; l < dps.Len(); l++ {
+						stats.exemplars += dps.At(l).Exemplars().Len()
+					}
+				case pmetric.MetricTypeHistogram:
+					dps := m.Histogram().DataPoints()
+					stats.histograms += dps.Len()
+					for l := 0; l < dps.Len(); l++ {
+						stats.exemplars += dps.At(l).Exemplars().Len()
+					}
+				case pmetric.MetricTypeExponentialHistogram:
+					dps := m.ExponentialHistogram().DataPoints()
+					stats.histograms += dps.Len()
+					for l := 0; l < dps.Len(); l++ {
+						stats.exemplars += dps.At(l).Exemplars().Len()
+					}
+				case pmetric.MetricTypeSummary:
+					stats.samples += m.Summary().DataPoints().Len()
+				}
+			}
+		}
+	}
+	stats.series = stats.samples + stats.histograms
+	return stats, nil
+}
+
+// accountWrite accounts the usage of a write request of the tenant, if the usage metrics are enabled.
+func (h *Handler) accountWrite(tenant string, usage func() (usageStats, error)) {
+	if h.usage == nil || tenant == "" {
+		return
+	}
+
+	stats, err := usage()
+	if err != nil {
+		level.Debug(h.logger).Log("msg", "failed to decode the write request for the usage accounting", "tenant", tenant, "err", err)
+	}
+	h.usage.observeWrite(tenant, stats)
+}
+
+// countingResponseWriter counts the bytes written to the response.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	written int
+}
+
+func (w *countingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.written += n
+	return n, err
+}
+
+// Unwrap returns the underlying writer, so that the http.ResponseController is able to flush the responses.
+func (w *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,148 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/prompb"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+)
+
+func TestUsageMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/v1/query" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+		}
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantHeader:           "WHIZARD-TENANT",
+		TenantLabelName:        "tenant_id",
+		QueryProxy:             NewSingleHostReverseProxy(target, http.DefaultTransport),
+		RemoteWriteProxy:       NewSingleHostReverseProxy(target, http.DefaultTransport),
+		EnabledUsageMetrics:    true,
+		UsageMetricsMaxTenants: 2,
+	})
+
+	serve := func(req *http.Request) {
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	body, err := encodeWriteRequest(&prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+		{
+			Labels:    []prompb.Label{{Name: "__name__", Value: "up"}},
+			Samples:   []prompb.Sample{{Value: 1, Timestamp: 1}, {Value: 1, Timestamp: 2}},
+			Exemplars: []prompb.Exemplar{{Value: 1, Timestamp: 1}},
+		},
+		{
+			Labels:     []prompb.Label{{Name: "__name__", Value: "latency"}},
+			Histograms: []prompb.Histogram{{Timestamp: 1}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/t1/api/v1/receive", bytes.NewReader(body))
+	req.Header.Set("Content-Encoding", "snappy")
+	serve(req)
+
+	metrics := pmetric.NewMetrics()
+	ms := metrics.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	gauge := ms.AppendEmpty().SetEmptyGauge()
+	gauge.DataPoints().AppendEmpty().SetDoubleValue(1)
+	gauge.DataPoints().AppendEmpty().SetDoubleValue(2)
+	ms.AppendEmpty().SetEmptyHistogram().DataPoints().AppendEmpty().Exemplars().AppendEmpty()
+	otlpBody, err := pmetricotlp.NewExportRequestFromMetrics(metrics).MarshalProto()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/t2/api/v1/otlp", bytes.NewReader(otlpBody))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	serve(req)
+
+	serve(httptest.NewRequest(http.MethodGet, "/t1/api/v1/query?query=up", nil))
+	// The multi-tenant queries are accounted to every tenant.
+	serve(httptest.NewRequest(http.MethodGet, "/t1|t2/api/v1/query?query=up", nil))
+	// The tenants over the tenant limit are accounted to the overflow tenant.
+	serve(httptest.NewRequest(http.MethodGet, "/t3/api/v1/query?query=up", nil))
+
+	for _, tc := range []struct {
+		name     string
+		c        prometheus.Collector
+		expected float64
+	}{
+		{"t1 series", h.usage.receivedSeries.WithLabelValues("t1"), 2},
+		{"t1 samples", h.usage.receivedSamples.WithLabelValues("t1"), 2},
+		{"t1 histograms", h.usage.receivedHistograms.WithLabelValues("t1"), 1},
+		{"t1 exemplars", h.usage.receivedExemplars.WithLabelValues("t1"), 1},
+		{"t1 bytes", h.usage.receivedBytes.WithLabelValues("t1"), float64(len(body))},
+		{"t2 series", h.usage.receivedSeries.WithLabelValues("t2"), 3},
+		{"t2 samples", h.usage.receivedSamples.WithLabelValues("t2"), 2},
+		{"t2 histograms", h.usage.receivedHistograms.WithLabelValues("t2"), 1},
+		{"t2 exemplars", h.usage.receivedExemplars.WithLabelValues("t2"), 1},
+		{"t2 uncompressed bytes", h.usage.receivedUncompressedBytes.WithLabelValues("t2"), float64(len(otlpBody))},
+		{"t1 queries", h.usage.queries.WithLabelValues("t1"), 2},
+		{"t2 queries", h.usage.queries.WithLabelValues("t2"), 1},
+		{"overflow queries", h.usage.queries.WithLabelValues(usageOverflowTenant), 1},
+	} {
+		if v := testutil.ToFloat64(tc.c); v != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, v)
+		}
+	}
+	if v := testutil.ToFloat64(h.usage.queryResponseBytes.WithLabelValues("t1")); v == 0 {
+		t.Error("expected the query response bytes to be accounted")
+	}
+	if n := testutil.CollectAndCount(h.usage.queries); n != 3 {
+		t.Errorf("expected 3 tenants in the query metrics, got %d", n)
+	}
+}
+
+func TestGlobalRemoteWriteWithoutTenant(t *testing.T) {
+	var forwarded atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		forwarded.Store(true)
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	body, err := encodeWriteRequest(&prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, usageMetrics := range []bool{false, true} {
+		forwarded.Store(false)
+		h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+			TenantHeader:        "WHIZARD-TENANT",
+			TenantLabelName:     "tenant_id",
+			RemoteWriteProxy:    NewSingleHostReverseProxy(target, http.DefaultTransport),
+			EnabledUsageMetrics: usageMetrics,
+		})
+
+		// The global remote writes without tenants are proxied as is.
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/receive", bytes.NewReader(body))
+		req.Header.Set("Content-Encoding", "snappy")
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || !forwarded.Load() {
+			t.Fatalf("usage metrics %v: expected the request to be forwarded, got status %d: %s", usageMetrics, rec.Code, rec.Body.String())
+		}
+	}
+}
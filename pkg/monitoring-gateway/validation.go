@@ -0,0 +1,236 @@
+package monitoringgateway
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+)
+
+const (
+	reasonMissingMetricName       = "missing_metric_name"
+	reasonInvalidMetricName       = "invalid_metric_name"
+	reasonInvalidLabelName        = "invalid_label_name"
+	reasonMaxLabelNamesPerSeries  = "max_label_names_per_series"
+	reasonLabelNameTooLong        = "label_name_too_long"
+	reasonLabelValueTooLong       = "label_value_too_long"
+	reasonSampleTooOld            = "sample_too_old"
+	reasonSampleTooFarInFuture    = "sample_too_far_in_future"
+	maxValidationErrorsInResponse = 10
+)
+
+// validateRemoteWrite applies the validation rules of the tenant to a remote write request body.
+// It removes the rejected series and samples from the request, and returns the validation errors.
+// If nothing is left to be forwarded, the errors are responded and false is returned.
+func (h *Handler) validateRemoteWrite(w http.ResponseWriter, tenant string, b *writeRequestBody) ([]error, bool) {
+	if h.limits == nil || tenant == "" {
+		return nil, true
+	}
+	limits := h.limits.TenantLimits(tenant)
+	if !limits.validationEnabled() {
+		return nil, true
+	}
+	if err := b.decode(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	var (
+		errs    []error
+		empty   bool
+		now     = time.Now()
+		discard = func(reason string, samples int) {
+			h.discardedSamplesCounter.WithLabelValues(tenant, reason).Add(float64(samples))
+		}
+	)
+	switch {
+	case b.v2 != nil:
+		errs = validateWriteRequestV2(limits, b.v2, now, discard)
+		empty = len(b.v2.Timeseries) == 0
+	default:
+		errs = validateWriteRequest(limits, b.v1, now, discard)
+		empty = len(b.v1.Timeseries) == 0 && len(b.v1.Metadata) == 0
+	}
+	if len(errs) == 0 {
+		return nil, true
+	}
+	b.modified = true
+
+	if empty {
+		http.Error(w, validationErrorMessage(errs), http.StatusBadRequest)
+		return nil, false
+	}
+	return errs, true
+}
+
+// validateWriteRequest removes the series and samples violating the validation rules from the write request.
+// It returns the validation errors, and reports the number of discarded samples by reason to the discard func.
+func validateWriteRequest(limits TenantLimits, wreq *prompb.WriteRequest, now time.Time, discard func(reason string, samples int)) []error {
+	var (
+		errs   []error
+		b      = labels.NewScratchBuilder(0)
+		series = wreq.Timeseries[:0]
+	)
+
+	for _, ts := range wreq.Timeseries {
+		if reason, err := validateSeriesLabels(limits, ts.Labels); err != nil {
+			errs = append(errs, fmt.Errorf("%w, series: %s", err, ts.ToLabels(&b, nil).String()))
+			discard(reason, len(ts.Samples)+len(ts.Histograms))
+			continue
+		}
+
+		var dropped map[string]int
+		ts.Samples, dropped = filterByTimestamp(limits, ts.Samples, func(s prompb.Sample) int64 { return s.Timestamp }, now, dropped)
+		ts.Histograms, dropped = filterByTimestamp(limits, ts.Histograms, func(h prompb.Histogram) int64 { return h.Timestamp }, now, dropped)
+		for reason, n := range dropped {
+			errs = append(errs, fmt.Errorf("%d samples rejected (%s), series: %s", n, reason, ts.ToLabels(&b, nil).String()))
+			discard(reason, n)
+		}
+
+		if len(ts.Samples) == 0 && len(ts.Histograms) == 0 && len(ts.Exemplars) == 0 {
+			continue
+		}
+		series = append(series, ts)
+	}
+
+	wreq.Timeseries = series
+	return errs
+}
+
+// validateWriteRequestV2 is the remote write 2.0 counterpart of validateWriteRequest.
+// The symbols of the rejected series are kept, as they may be referenced by other series.
+func validateWriteRequestV2(limits TenantLimits, wreq *writev2.Request, now time.Time, discard func(reason string, samples int)) []error {
+	var (
+		errs   []error
+		b      = labels.NewScratchBuilder(0)
+		series = wreq.Timeseries[:0]
+	)
+
+	for _, ts := range wreq.Timeseries {
+		lbls := ts.ToLabels(&b, wreq.Symbols)
+		if reason, err := validateSeriesLabels(limits, prompb.FromLabels(lbls, nil)); err != nil {
+			errs = append(errs, fmt.Errorf("%w, series: %s", err, lbls.String()))
+			discard(reason, len(ts.Samples)+len(ts.Histograms))
+			continue
+		}
+
+		var dropped map[string]int
+		ts.Samples, dropped = filterByTimestamp(limits, ts.Samples, writev2.Sample.T, now, dropped)
+		ts.Histograms, dropped = filterByTimestamp(limits, ts.Histograms, func(h writev2.Histogram) int64 { return h.Timestamp }, now, dropped)
+		for reason, n := range dropped {
+			errs = append(errs, fmt.Errorf("%d samples rejected (%s), series: %s", n, reason, lbls.String()))
+			discard(reason, n)
+		}
+
+		if len(ts.Samples) == 0 && len(ts.Histograms) == 0 && len(ts.Exemplars) == 0 {
+			continue
+		}
+		series = append(series, ts)
+	}
+
+	wreq.Timeseries = series
+	return errs
+}
+
+func validateSeriesLabels(limits TenantLimits, ls []prompb.Label) (string, error) {
+	if limits.MaxLabelNamesPerSeries > 0 && len(ls) > limits.MaxLabelNamesPerSeries {
+		return reasonMaxLabelNamesPerSeries, fmt.Errorf("series has too many labels (actual: %d, limit: %d)", len(ls), limits.MaxLabelNamesPerSeries)
+	}
+
+	if scheme := limits.MetricNameValidationScheme; scheme != model.UnsetValidation {
+		var name string
+		for _, l := range ls {
+			if l.Name == labels.MetricName {
+				name = l.Value
+				break
+			}
+		}
+		if name == "" {
+			return reasonMissingMetricName, fmt.Errorf("series has no metric name")
+		}
+		if !scheme.IsValidMetricName(name) {
+			return reasonInvalidMetricName, fmt.Errorf("series has invalid metric name %q", name)
+		}
+	}
+
+	for _, l := range ls {
+		if scheme := limits.MetricNameValidationScheme; scheme != model.UnsetValidation && !scheme.IsValidLabelName(l.Name) {
+			return reasonInvalidLabelName, fmt.Errorf("series has invalid label name %q", l.Name)
+		}
+		if limits.MaxLabelNameLength > 0 && len(l.Name) > limits.MaxLabelNameLength {
+			return reasonLabelNameTooLong, fmt.Errorf("label name too long (actual: %d, limit: %d): %q", len(l.Name), limits.MaxLabelNameLength, l.Name)
+		}
+		if limits.MaxLabelValueLength > 0 && len(l.Value) > limits.MaxLabelValueLength {
+			return reasonLabelValueTooLong, fmt.Errorf("label value too long (actual: %d, limit: %d) for label %q", len(l.Value), limits.MaxLabelValueLength, l.Name)
+		}
+	}
+
+	return "", nil
+}
+
+// sampleTimestampReason returns the reason to reject a sample with the given timestamp in milliseconds,
+// or an empty string if it's accepted.
+func sampleTimestampReason(limits TenantLimits, ts int64, now time.Time) string {
+	if limits.RejectOldSamplesMaxAge > 0 && ts < now.Add(-time.Duration(limits.RejectOldSamplesMaxAge)).UnixMilli() {
+		return reasonSampleTooOld
+	}
+	if limits.CreationGracePeriod > 0 && ts > now.Add(time.Duration(limits.CreationGracePeriod)).UnixMilli() {
+		return reasonSampleTooFarInFuture
+	}
+	return ""
+}
+
+// filterByTimestamp drops the samples with a rejected timestamp, and counts them by reason.
+func filterByTimestamp[T any](limits TenantLimits, samples []T, timestamp func(T) int64, now time.Time, dropped map[string]int) ([]T, map[string]int) {
+	kept := samples[:0]
+	for _, s := range samples {
+		if reason := sampleTimestampReason(limits, timestamp(s), now); reason != "" {
+			if dropped == nil {
+				dropped = make(map[string]int)
+			}
+			dropped[reason]++
+			continue
+		}
+		kept = append(kept, s)
+	}
+	return kept, dropped
+}
+
+// validationErrorMessage joins the validation errors to a response body of a bounded size.
+func validationErrorMessage(errs []error) string {
+	var sb strings.Builder
+	for i, err := range errs {
+		if i == maxValidationErrorsInResponse {
+			fmt.Fprintf(&sb, "and %d more rejected series\n", len(errs)-i)
+			break
+		}
+		sb.WriteString(err.Error())
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// rejectPartially turns a successful downstream response into a 400 carrying the validation errors,
+// so that the client learns about the rejected series while the valid ones have been written.
+func rejectPartially(resp *http.Response, errs []error) error {
+	if resp.StatusCode/100 != 2 {
+		return nil
+	}
+
+	msg := validationErrorMessage(errs)
+	_ = resp.Body.Close()
+	resp.StatusCode = http.StatusBadRequest
+	resp.Status = strconv.Itoa(http.StatusBadRequest) + " " + http.StatusText(http.StatusBadRequest)
+	resp.Body = io.NopCloser(strings.NewReader(msg))
+	resp.ContentLength = int64(len(msg))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(msg)))
+	resp.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	return nil
+}
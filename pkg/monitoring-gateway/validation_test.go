@@ -0,0 +1,71 @@
+package monitoringgateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestValidateWriteRequest(t *testing.T) {
+	now := time.Now()
+	limits := TenantLimits{
+		MaxLabelNamesPerSeries:     3,
+		MaxLabelValueLength:        10,
+		MetricNameValidationScheme: model.LegacyValidation,
+		RejectOldSamplesMaxAge:     model.Duration(time.Hour),
+		CreationGracePeriod:        model.Duration(time.Minute),
+	}
+
+	series := func(samples []prompb.Sample, ls ...string) prompb.TimeSeries {
+		ts := prompb.TimeSeries{Samples: samples}
+		for i := 0; i < len(ls); i += 2 {
+			ts.Labels = append(ts.Labels, prompb.Label{Name: ls[i], Value: ls[i+1]})
+		}
+		return ts
+	}
+	valid := []prompb.Sample{{Value: 1, Timestamp: now.UnixMilli()}}
+
+	wreq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			series(valid, "__name__", "up", "job", "a"),
+			series(valid, "job", "no_name"),
+			series(valid, "__name__", "invalid-name"),
+			series(valid, "__name__", "up", "a", "1", "b", "2", "c", "3"),
+			series(valid, "__name__", "up", "job", "a-very-long-value"),
+			series([]prompb.Sample{
+				{Value: 1, Timestamp: now.Add(-2 * time.Hour).UnixMilli()},
+				{Value: 2, Timestamp: now.UnixMilli()},
+				{Value: 3, Timestamp: now.Add(time.Hour).UnixMilli()},
+			}, "__name__", "up", "job", "b"),
+		},
+	}
+
+	discarded := map[string]int{}
+	errs := validateWriteRequest(limits, wreq, now, func(reason string, samples int) {
+		discarded[reason] += samples
+	})
+
+	if len(errs) != 6 {
+		t.Fatalf("expected 6 errors, got %d: %v", len(errs), errs)
+	}
+	if diff := cmp.Diff(map[string]int{
+		reasonMissingMetricName:      1,
+		reasonInvalidMetricName:      1,
+		reasonMaxLabelNamesPerSeries: 1,
+		reasonLabelValueTooLong:      1,
+		reasonSampleTooOld:           1,
+		reasonSampleTooFarInFuture:   1,
+	}, discarded); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if len(wreq.Timeseries) != 2 {
+		t.Fatalf("expected 2 series to be kept, got %d", len(wreq.Timeseries))
+	}
+	if diff := cmp.Diff([]prompb.Sample{{Value: 2, Timestamp: now.UnixMilli()}}, wreq.Timeseries[1].Samples); diff != "" {
+		t.Fatal(diff)
+	}
+}